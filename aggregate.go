@@ -0,0 +1,263 @@
+package IDB
+
+import (
+	"errors"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+var (
+	ErrInvalidAggregate = errors.New("storage: invalid aggregate")
+)
+
+type AggFunc int
+
+const (
+	COUNT AggFunc = iota
+	SUM
+	MIN
+	MAX
+	AVG
+)
+
+// Agg 聚合函数。COUNT的Field为空时为COUNT(*)
+type Agg struct {
+	Func  AggFunc
+	Field string
+}
+
+// AggRow 聚合结果中的一行
+type AggRow struct {
+	// GROUP BY字段的值，与groupBy顺序一致。空值为nil，INT为int，STRING为string
+	Group []interface{}
+	// 聚合结果，与aggs顺序一致。COUNT、SUM为int，AVG为float64，MIN、MAX与字段类型一致。没有非空值时除COUNT外为nil
+	Values []interface{}
+}
+
+type aggState struct {
+	count int
+	sum   int
+	// MIN、MAX当前的值，空表示还没有值
+	value string
+}
+
+type aggGroup struct {
+	key    []string
+	states []aggState
+}
+
+// aggregator 哈希聚合
+type aggregator struct {
+	aggs []Agg
+	// 聚合字段，COUNT(*)为nil
+	aggFields   []*FieldMeta
+	groupFields []*FieldMeta
+	groups      map[string]*aggGroup
+}
+
+func newAggregator(fields []*FieldMeta, groupBy []string, aggs []Agg) (*aggregator, error) {
+	a := &aggregator{
+		aggs:        aggs,
+		aggFields:   make([]*FieldMeta, len(aggs)),
+		groupFields: make([]*FieldMeta, len(groupBy)),
+		groups:      make(map[string]*aggGroup),
+	}
+	for i, name := range groupBy {
+		f, err := exprField(fields, name)
+		if err != nil {
+			return nil, err
+		}
+		a.groupFields[i] = f
+	}
+	for i, agg := range aggs {
+		if agg.Field == "" {
+			if agg.Func != COUNT {
+				return nil, ErrInvalidAggregate
+			}
+			continue
+		}
+		f, err := exprField(fields, agg.Field)
+		if err != nil {
+			return nil, err
+		}
+		switch agg.Func {
+		case COUNT, MIN, MAX:
+		case SUM, AVG:
+			if f.tp != INT {
+				return nil, &FieldError{Field: agg.Field, Err: ErrMismatchFieldType}
+			}
+		default:
+			return nil, ErrInvalidAggregate
+		}
+		a.aggFields[i] = f
+	}
+	return a, nil
+}
+
+func (a *aggregator) add(r *Record) {
+	key := make([]string, len(a.groupFields))
+	for i, f := range a.groupFields {
+		key[i] = fieldValue(f, r)
+	}
+	hashKey := strings.Join(key, indexKeySeparator)
+	g, ok := a.groups[hashKey]
+	if !ok {
+		g = &aggGroup{key: key, states: make([]aggState, len(a.aggs))}
+		a.groups[hashKey] = g
+	}
+
+	for i, agg := range a.aggs {
+		st := &g.states[i]
+		f := a.aggFields[i]
+		if f == nil {
+			st.count++
+			continue
+		}
+		v := fieldValue(f, r)
+		if v == "" {
+			continue
+		}
+		st.count++
+		switch agg.Func {
+		case SUM, AVG:
+			n, _ := strconv.Atoi(v)
+			st.sum += n
+		case MIN:
+			if st.value == "" || compareValues(f.tp, v, st.value) < 0 {
+				st.value = v
+			}
+		case MAX:
+			if st.value == "" || compareValues(f.tp, v, st.value) > 0 {
+				st.value = v
+			}
+		}
+	}
+}
+
+// result 按GROUP BY字段排序返回结果。没有GROUP BY时总是返回一行
+func (a *aggregator) result() []*AggRow {
+	if len(a.groupFields) == 0 && len(a.groups) == 0 {
+		a.groups[""] = &aggGroup{states: make([]aggState, len(a.aggs))}
+	}
+
+	groups := make([]*aggGroup, 0, len(a.groups))
+	for _, g := range a.groups {
+		groups = append(groups, g)
+	}
+	sort.Slice(groups, func(i, j int) bool {
+		for k, f := range a.groupFields {
+			if c := compareValues(f.tp, groups[i].key[k], groups[j].key[k]); c != 0 {
+				return c < 0
+			}
+		}
+		return false
+	})
+
+	rows := make([]*AggRow, len(groups))
+	for i, g := range groups {
+		row := &AggRow{
+			Group:  make([]interface{}, len(a.groupFields)),
+			Values: make([]interface{}, len(a.aggs)),
+		}
+		for k, f := range a.groupFields {
+			row.Group[k] = typedValue(f, g.key[k])
+		}
+		for k, agg := range a.aggs {
+			st := g.states[k]
+			switch {
+			case agg.Func == COUNT:
+				row.Values[k] = st.count
+			case st.count == 0:
+			case agg.Func == SUM:
+				row.Values[k] = st.sum
+			case agg.Func == AVG:
+				row.Values[k] = float64(st.sum) / float64(st.count)
+			default:
+				row.Values[k] = typedValue(a.aggFields[k], st.value)
+			}
+		}
+		rows[i] = row
+	}
+	return rows
+}
+
+// countOnly 是否只有COUNT(*)
+func countOnly(aggs []Agg) bool {
+	for _, agg := range aggs {
+		if agg.Func != COUNT || agg.Field != "" {
+			return false
+		}
+	}
+	return true
+}
+
+// Aggregate 对满足filter的数据按groupBy分组聚合，filter为nil时聚合所有数据。
+// 没有filter以及GROUP BY的COUNT(*)直接使用维护的记录数量
+func (s *idbServer) Aggregate(tableName string, filter Expr, groupBy []string, aggs []Agg) ([]*AggRow, error) {
+	t, err := s.getTable(tableName)
+	if err != nil {
+		return nil, err
+	}
+
+	fields := t.meta.getFields()
+	a, err := newAggregator(fields, groupBy, aggs)
+	if err != nil {
+		return nil, err
+	}
+	if filter == nil && len(groupBy) == 0 && countOnly(aggs) {
+		count := t.data.Count()
+		row := &AggRow{Values: make([]interface{}, len(aggs))}
+		for i := range aggs {
+			row.Values[i] = count
+		}
+		return []*AggRow{row}, nil
+	}
+
+	if filter == nil {
+		filter = And()
+	}
+	isTarget, err := bindTarget(filter, fields)
+	if err != nil {
+		return nil, err
+	}
+	t.scan(fields, filter, func(r *Record) bool {
+		if isTarget(r) {
+			a.add(r)
+		}
+		return true
+	})
+	return a.result(), nil
+}
+
+// AggregateTx 在事务快照上聚合，包括事务中未提交的修改
+func (s *idbServer) AggregateTx(tx *Tx, tableName string, filter Expr, groupBy []string, aggs []Agg) ([]*AggRow, error) {
+	tx.newStatement()
+	t, err := s.getTable(tableName)
+	if err != nil {
+		return nil, err
+	}
+
+	fields := t.meta.getFields()
+	a, err := newAggregator(fields, groupBy, aggs)
+	if err != nil {
+		return nil, err
+	}
+	if filter == nil {
+		filter = And()
+	}
+	isTarget, err := bindTarget(filter, fields)
+	if err != nil {
+		return nil, err
+	}
+	err = s.scanTx(tx, t, tableName, func(r *Record) bool {
+		if isTarget(r) {
+			a.add(r)
+		}
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+	return a.result(), nil
+}
@@ -0,0 +1,166 @@
+package IDB
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestAggregate(t *testing.T) {
+	server := NewIDBServer()
+	err := createPeopleTable(server)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = server.Insert("people", []interface{}{"frank", 7, "beijing"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 没有filter时使用维护的记录数量
+	rows, err := server.Aggregate("people", nil, nil, []Agg{{Func: COUNT}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rows) != 1 || rows[0].Values[0] != 6 {
+		t.Fatalf("unexpected %v", rows[0])
+	}
+	err = server.DeleteByID("people", 6)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rows, err = server.Aggregate("people", nil, nil, []Agg{{Func: COUNT}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rows[0].Values[0] != 5 {
+		t.Fatalf("unexpected %v", rows[0])
+	}
+
+	rows, err = server.Aggregate("people", nil, []string{"city"}, []Agg{
+		{Func: COUNT},
+		{Func: COUNT, Field: "age"},
+		{Func: SUM, Field: "age"},
+		{Func: MIN, Field: "age"},
+		{Func: MAX, Field: "name"},
+		{Func: AVG, Field: "age"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expects := []*AggRow{
+		{Group: []interface{}{nil}, Values: []interface{}{1, 1, 100, 100, "eve_1", 100.0}},
+		{Group: []interface{}{"beijing"}, Values: []interface{}{2, 2, 12, 3, "carol", 6.0}},
+		{Group: []interface{}{"shanghai"}, Values: []interface{}{1, 1, 25, 25, "bob", 25.0}},
+		{Group: []interface{}{"shenzhen"}, Values: []interface{}{1, 0, nil, nil, "dave", nil}},
+	}
+	if len(rows) != len(expects) {
+		t.Fatalf("expected %v got %v", len(expects), len(rows))
+	}
+	for i, expect := range expects {
+		if rows[i].Group[0] != expect.Group[0] {
+			t.Fatalf("%d: expected %v got %v", i, expect.Group, rows[i].Group)
+		}
+		for j := range expect.Values {
+			if rows[i].Values[j] != expect.Values[j] {
+				t.Fatalf("%d: expected %v got %v", i, expect.Values, rows[i].Values)
+			}
+		}
+	}
+
+	// 有filter时没有数据也返回一行
+	rows, err = server.Aggregate("people", Gt("age", 1000), nil, []Agg{{Func: COUNT}, {Func: SUM, Field: "age"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rows) != 1 || rows[0].Values[0] != 0 || rows[0].Values[1] != nil {
+		t.Fatalf("unexpected %v", rows[0])
+	}
+	rows, err = server.Aggregate("people", Gt("age", 1000), []string{"city"}, []Agg{{Func: COUNT}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rows) != 0 {
+		t.Fatalf("unexpected %v", rows)
+	}
+
+	_, err = server.Aggregate("people", nil, nil, []Agg{{Func: SUM, Field: "name"}})
+	if !errors.Is(err, ErrMismatchFieldType) {
+		t.Fatalf("expected %v got %v", ErrMismatchFieldType, err)
+	}
+	_, err = server.Aggregate("people", nil, nil, []Agg{{Func: MAX}})
+	if err != ErrInvalidAggregate {
+		t.Fatalf("expected %v got %v", ErrInvalidAggregate, err)
+	}
+	_, err = server.Aggregate("people", nil, []string{"height"}, []Agg{{Func: COUNT}})
+	if !errors.Is(err, ErrFieldNotExist) {
+		t.Fatalf("expected %v got %v", ErrFieldNotExist, err)
+	}
+}
+
+func TestAggregateTx(t *testing.T) {
+	server := NewIDBServer()
+	inspector := NewUndoInspector()
+	server.WithOptions(func(option *ServerOptionConfig) {
+		option.inspector = inspector
+	})
+	err := createPeopleTable(server)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tm := NewTxMgr(server, inspector)
+
+	sumAge := func(tx *Tx) (interface{}, interface{}) {
+		rows, err := server.AggregateTx(tx, "people", nil, nil, []Agg{{Func: COUNT}, {Func: SUM, Field: "age"}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return rows[0].Values[0], rows[0].Values[1]
+	}
+
+	// tx2在tx1开始前开始，在tx1开始后提交，tx1看不到tx2的修改
+	tx2 := tm.StartTransaction()
+	tx1 := tm.StartTransaction()
+	err = server.InsertTx(tx2, "people", []interface{}{"frank", 7, "beijing"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = server.DeleteByIDTx(tx2, "people", 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = server.UpdateByIDTx(tx2, "people", map[string]interface{}{"age": 4}, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = tx2.Commit()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	count, sum := sumAge(tx1)
+	if count != 5 || sum != 137 {
+		t.Fatalf("expected 5 137 got %v %v", count, sum)
+	}
+
+	// 包括事务自己的修改
+	err = server.InsertTx(tx1, "people", []interface{}{"grace", 10, "beijing"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = server.DeleteByIDTx(tx1, "people", 5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	count, sum = sumAge(tx1)
+	if count != 5 || sum != 47 {
+		t.Fatalf("expected 5 47 got %v %v", count, sum)
+	}
+	tx1.Rollback()
+
+	// 新事务看到tx2提交后的数据
+	tx3 := tm.StartTransaction()
+	count, sum = sumAge(tx3)
+	if count != 5 || sum != 120 {
+		t.Fatalf("expected 5 120 got %v %v", count, sum)
+	}
+}
@@ -0,0 +1,198 @@
+package IDB
+
+import "errors"
+
+var (
+	ErrFieldExists = errors.New("storage: field exists")
+	ErrLastField   = errors.New("storage: can not drop the last field")
+)
+
+// AddColumn 新增字段。已有的record不会被重写，读取时没有该字段的record返回默认值
+// 唯一字段会同时建立唯一约束，已有record的默认值冲突时无法新增
+func (s *idbServer) AddColumn(tableName string, field *FieldMeta, defaultValue interface{}) error {
+	t, err := s.getTable(tableName)
+	if err != nil {
+		return err
+	}
+
+	// 不能新增主键
+	if field.isPrimaryKey || field.autoIncrement {
+		return ErrPrimaryKeyImmutable
+	}
+	// 已有的record无法计算生成列
+	if field.generated != nil {
+		return ErrGeneratedColumn
+	}
+
+	// 必填字段需要默认值，否则已有的record无法满足
+	if defaultValue == nil && field.required {
+		return ErrFieldRequired
+	}
+	dv, err := convertValueToString(field.tp, defaultValue)
+	if err != nil {
+		return err
+	}
+
+	t.writeMu.Lock()
+	defer t.writeMu.Unlock()
+	t.meta.mu.Lock()
+	defer t.meta.mu.Unlock()
+
+	if _, err = findField(t.meta.fields, field.name); err == nil {
+		return ErrFieldExists
+	}
+
+	// 新字段使用一个新位置，不复用被删除字段的位置
+	nf := *field
+	nf.pos = t.meta.slotCount
+	nf.defaultValue = dv
+	if nf.unique {
+		idx, err := t.buildIndex(nf.name, []*FieldMeta{&nf}, true)
+		if err != nil {
+			return withTableName(err, tableName)
+		}
+		t.meta.publishIndex(idx)
+	}
+	t.meta.slotCount++
+
+	fields := make([]*FieldMeta, len(t.meta.fields), len(t.meta.fields)+1)
+	copy(fields, t.meta.fields)
+	t.meta.fields = append(fields, &nf)
+	return nil
+}
+
+// DropColumn 删除字段以及该字段上的外键、索引和CHECK约束。字段在record中的位置不再被读取，旧数据留在原处。
+// 与写入互斥，写入中不会看到一半的字段和索引
+func (s *idbServer) DropColumn(tableName string, fieldName string) error {
+	t, err := s.getTable(tableName)
+	if err != nil {
+		return err
+	}
+
+	t.writeMu.Lock()
+	defer t.writeMu.Unlock()
+	t.meta.mu.Lock()
+	defer t.meta.mu.Unlock()
+
+	f, err := findField(t.meta.fields, fieldName)
+	if err != nil {
+		return err
+	}
+	if f.isPrimaryKey {
+		return ErrPrimaryKeyImmutable
+	}
+	if len(t.meta.fields) == 1 {
+		return ErrLastField
+	}
+
+	fields := make([]*FieldMeta, 0, len(t.meta.fields)-1)
+	for _, of := range t.meta.fields {
+		if of.name != fieldName {
+			fields = append(fields, of)
+		}
+	}
+	t.meta.fields = fields
+
+	// 该字段上的外键一起删除
+	fks := make([]*foreignKey, 0, len(t.meta.foreignKeys))
+	for _, fk := range t.meta.foreignKeys {
+		if fk.field.pos != f.pos {
+			fks = append(fks, fk)
+		}
+	}
+	t.meta.foreignKeys = fks
+
+	// 包含该字段的索引一起删除
+	indexes := make([]*index, 0, len(t.meta.indexes))
+	for _, idx := range t.meta.indexes {
+		if !idx.contains(f.pos) {
+			indexes = append(indexes, idx)
+		}
+	}
+	t.meta.indexes = indexes
+
+	// 使用该字段的CHECK约束一起删除
+	checks := make([]*check, 0, len(t.meta.checks))
+	for _, c := range t.meta.checks {
+		if !c.uses(fieldName) {
+			checks = append(checks, c)
+		}
+	}
+	t.meta.checks = checks
+	return nil
+}
+
+// AlterColumnType 修改字段类型。已有数据都能转换为新类型时才修改，包含该字段的索引按新类型重建，
+// 唯一索引中转换后相同的值冲突时不修改。检查期间阻塞写但不阻塞读
+func (s *idbServer) AlterColumnType(tableName string, fieldName string, tp fieldType) error {
+	t, err := s.getTable(tableName)
+	if err != nil {
+		return err
+	}
+	if tp != INT && tp != STRING {
+		return ErrUnsupportedFieldType
+	}
+
+	t.writeMu.Lock()
+	defer t.writeMu.Unlock()
+
+	f, err := findField(t.meta.getFields(), fieldName)
+	if err != nil {
+		return err
+	}
+	if f.tp == tp {
+		return nil
+	}
+	if f.isPrimaryKey {
+		return ErrPrimaryKeyImmutable
+	}
+	// 外键字段类型需要与父表主键一致
+	for _, fk := range t.meta.getForeignKeys() {
+		if fk.field.pos == f.pos {
+			return ErrInvalidForeignKey
+		}
+	}
+
+	// 数据以string存储，只需检查已有数据能否转换成新类型
+	nf := *f
+	nf.tp = tp
+	if err = checkStoredValue(&nf, nf.defaultValue); err != nil {
+		return err
+	}
+	t.data.scanLeaves(func(r *Record) bool {
+		err = checkStoredValue(&nf, fieldValue(&nf, r))
+		return err == nil
+	})
+	if err != nil {
+		return err
+	}
+
+	oldFields := t.meta.getFields()
+	fields := make([]*FieldMeta, len(oldFields))
+	for i, of := range oldFields {
+		if of.name == fieldName {
+			fields[i] = &nf
+			continue
+		}
+		fields[i] = of
+	}
+	// 如"007"修改为INT后需要能用7查到
+	oldIndexes := t.meta.getIndexes()
+	indexes := make([]*index, len(oldIndexes))
+	for i, idx := range oldIndexes {
+		if !idx.contains(f.pos) {
+			indexes[i] = idx
+			continue
+		}
+		indexes[i], err = t.buildIndex(idx.name, idx.fieldsOf(fields), idx.unique)
+		if err != nil {
+			return withTableName(err, tableName)
+		}
+	}
+
+	t.meta.mu.Lock()
+	defer t.meta.mu.Unlock()
+	t.meta.fields = fields
+	t.meta.indexes = indexes
+	return nil
+}
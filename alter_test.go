@@ -0,0 +1,417 @@
+package IDB
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestAddColumn(t *testing.T) {
+	server := NewIDBServer()
+	fms := []*FieldMeta{
+		{
+			name:         "name",
+			isPrimaryKey: false,
+			tp:           STRING,
+		},
+	}
+	tableName := "test"
+	server.CreateTable(tableName, fms)
+	err := server.Insert(tableName, []interface{}{"hello"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = server.AddColumn(tableName, &FieldMeta{name: "age", tp: INT}, 18)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = server.AddColumn(tableName, &FieldMeta{name: "age", tp: INT}, 18)
+	if err != ErrFieldExists {
+		t.Fatalf("expected %v got %v", ErrFieldExists, err)
+	}
+	err = server.AddColumn(tableName, &FieldMeta{name: "city", tp: STRING, required: true}, nil)
+	if err != ErrFieldRequired {
+		t.Fatalf("expected %v got %v", ErrFieldRequired, err)
+	}
+
+	// 旧record读到默认值
+	record, err := server.SelectByID(tableName, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(record.Value) != 2 || record.Value[0] != "hello" || record.Value[1] != "18" {
+		t.Fatalf("unexpected %v", record.Value)
+	}
+
+	// 新record需要传新字段
+	err = server.Insert(tableName, []interface{}{"world"})
+	if err != ErrFieldRequired {
+		t.Fatalf("expected %v got %v", ErrFieldRequired, err)
+	}
+	err = server.Insert(tableName, []interface{}{"world", 20})
+	if err != nil {
+		t.Fatal(err)
+	}
+	records, err := server.SelectByFields(tableName, map[string]interface{}{"age": "18"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 1 || records[0].Key != 1 {
+		t.Fatalf("unexpected %v", records)
+	}
+
+	// 更新旧record的其他字段，新字段仍为默认值
+	err = server.UpdateByID(tableName, map[string]interface{}{"name": "python"}, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	record, err = server.SelectByID(tableName, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if record.Value[0] != "python" || record.Value[1] != "18" {
+		t.Fatalf("unexpected %v", record.Value)
+	}
+	err = server.UpdateByID(tableName, map[string]interface{}{"age": 30}, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	record, err = server.SelectByID(tableName, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if record.Value[0] != "python" || record.Value[1] != "30" {
+		t.Fatalf("unexpected %v", record.Value)
+	}
+}
+
+func TestDropColumn(t *testing.T) {
+	server := NewIDBServer()
+	fms := []*FieldMeta{
+		{
+			name: "name",
+			tp:   STRING,
+		},
+		{
+			name: "city",
+			tp:   STRING,
+		},
+	}
+	tableName := "test"
+	server.CreateTable(tableName, fms)
+	err := server.Insert(tableName, []interface{}{"hello", "beijing"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = server.DropColumn(tableName, "name")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = server.DropColumn(tableName, "name")
+	if err != ErrFieldNotExist {
+		t.Fatalf("expected %v got %v", ErrFieldNotExist, err)
+	}
+	err = server.DropColumn(tableName, "city")
+	if err != ErrLastField {
+		t.Fatalf("expected %v got %v", ErrLastField, err)
+	}
+
+	record, err := server.SelectByID(tableName, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(record.Value) != 1 || record.Value[0] != "beijing" {
+		t.Fatalf("unexpected %v", record.Value)
+	}
+
+	// 重新加回同名字段，不会读到被删除的旧数据
+	err = server.AddColumn(tableName, &FieldMeta{name: "name", tp: STRING}, "unknown")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = server.Insert(tableName, []interface{}{"shanghai", "world"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	record, err = server.SelectByID(tableName, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(record.Value) != 2 || record.Value[0] != "beijing" || record.Value[1] != "unknown" {
+		t.Fatalf("unexpected %v", record.Value)
+	}
+	record, err = server.SelectByID(tableName, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(record.Value) != 2 || record.Value[0] != "shanghai" || record.Value[1] != "world" {
+		t.Fatalf("unexpected %v", record.Value)
+	}
+}
+
+func TestDropColumnCheck(t *testing.T) {
+	server := NewIDBServer()
+	fms := []*FieldMeta{
+		{
+			name: "name",
+			tp:   STRING,
+		},
+		{
+			name: "qty",
+			tp:   INT,
+		},
+	}
+	tableName := "test"
+	server.CreateTable(tableName, fms)
+	err := server.AddCheck(tableName, "ck_qty", []string{"qty"}, func(row map[string]interface{}) bool {
+		qty, ok := row["qty"].(int)
+		return ok && qty > 0
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = server.AddCheck(tableName, "ck_name", []string{"name"}, func(row map[string]interface{}) bool {
+		return row["name"] != nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = server.AddCheck(tableName, "ck_city", []string{"city"}, func(row map[string]interface{}) bool {
+		return true
+	})
+	if err != ErrFieldNotExist {
+		t.Fatalf("expected %v got %v", ErrFieldNotExist, err)
+	}
+	err = server.Insert(tableName, []interface{}{"hello", 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 删除字段后使用该字段的约束一起删除，其余约束仍然检查
+	err = server.DropColumn(tableName, "qty")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = server.Insert(tableName, []interface{}{"world"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = server.Insert(tableName, []interface{}{nil})
+	if !errors.Is(err, ErrCheckViolation) {
+		t.Fatalf("expected %v got %v", ErrCheckViolation, err)
+	}
+	err = server.DropCheck(tableName, "ck_qty")
+	if err != ErrCheckNotExist {
+		t.Fatalf("expected %v got %v", ErrCheckNotExist, err)
+	}
+}
+
+func TestAlterColumnType(t *testing.T) {
+	server := NewIDBServer()
+	fms := []*FieldMeta{
+		{
+			name: "age",
+			tp:   STRING,
+		},
+	}
+	tableName := "test"
+	server.CreateTable(tableName, fms)
+	err := server.Insert(tableName, []interface{}{"18"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = server.Insert(tableName, []interface{}{"unknown"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 存在无法转换的数据
+	err = server.AlterColumnType(tableName, "age", INT)
+	if err != ErrMismatchFieldType {
+		t.Fatalf("expected %v got %v", ErrMismatchFieldType, err)
+	}
+
+	err = server.UpdateByID(tableName, map[string]interface{}{"age": "20"}, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = server.AlterColumnType(tableName, "age", INT)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = server.Insert(tableName, []interface{}{"30"})
+	if err != ErrMismatchFieldType {
+		t.Fatalf("expected %v got %v", ErrMismatchFieldType, err)
+	}
+	err = server.Insert(tableName, []interface{}{30})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = server.AlterColumnType(tableName, "age", STRING)
+	if err != nil {
+		t.Fatal(err)
+	}
+	record, err := server.SelectByID(tableName, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if record.Value[0] != "30" {
+		t.Fatalf("unexpected %v", record.Value)
+	}
+}
+
+func TestAlterColumnTypeIndex(t *testing.T) {
+	server := NewIDBServer()
+	fms := []*FieldMeta{
+		{
+			name: "code",
+			tp:   STRING,
+		},
+		{
+			name: "sku",
+			tp:   STRING,
+		},
+	}
+	tableName := "test"
+	server.CreateTable(tableName, fms)
+	err := server.CreateIndex(tableName, "code", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = server.CreateIndex(tableName, "sku", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, row := range [][]interface{}{{"007", "007"}, {"8", "7"}} {
+		if err = server.Insert(tableName, row); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// 修改类型后索引中的值按新类型比较
+	err = server.AlterColumnType(tableName, "code", INT)
+	if err != nil {
+		t.Fatal(err)
+	}
+	records, err := server.SelectByFields(tableName, map[string]interface{}{"code": 7})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 1 || records[0].Key != 1 {
+		t.Fatalf("unexpected %v", records)
+	}
+
+	// 转换后唯一索引中的值相同
+	err = server.AlterColumnType(tableName, "sku", INT)
+	if !errors.Is(err, ErrDuplicateKey) {
+		t.Fatalf("expected %v got %v", ErrDuplicateKey, err)
+	}
+	records, err = server.SelectByFields(tableName, map[string]interface{}{"sku": "007"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 1 || records[0].Key != 1 {
+		t.Fatalf("unexpected %v", records)
+	}
+}
+
+func TestAlterTableInTx(t *testing.T) {
+	server := NewIDBServer()
+	inspector := NewUndoInspector()
+	server.WithOptions(func(option *ServerOptionConfig) {
+		option.inspector = inspector
+	})
+	fms := []*FieldMeta{
+		{
+			name: "name",
+			tp:   STRING,
+		},
+		{
+			name: "age",
+			tp:   STRING,
+		},
+	}
+	tableName := "test"
+	server.CreateTable(tableName, fms)
+	err := server.Insert(tableName, []interface{}{"hello", "18"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 事务在旧表结构下开始
+	tm := NewTxMgr(server, inspector)
+	tx := tm.StartTransaction()
+	err = server.InsertTx(tx, tableName, []interface{}{"world", "20"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = server.UpdateByIDTx(tx, tableName, map[string]interface{}{"name": "python"}, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = server.AddColumn(tableName, &FieldMeta{name: "city", tp: STRING}, "beijing")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = server.DropColumn(tableName, "name")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 事务内按新表结构读取
+	record, err := server.SelectByIDTx(tx, tableName, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(record.Value) != 2 || record.Value[0] != "20" || record.Value[1] != "beijing" {
+		t.Fatalf("unexpected %v", record.Value)
+	}
+	err = server.UpdateByIDTx(tx, tableName, map[string]interface{}{"city": "shanghai"}, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		t.Fatal(err)
+	}
+	record, err = server.SelectByID(tableName, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(record.Value) != 2 || record.Value[0] != "18" || record.Value[1] != "shanghai" {
+		t.Fatalf("unexpected %v", record.Value)
+	}
+	record, err = server.SelectByID(tableName, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(record.Value) != 2 || record.Value[0] != "20" || record.Value[1] != "beijing" {
+		t.Fatalf("unexpected %v", record.Value)
+	}
+
+	// 事务写入的数据不符合修改后的字段类型，提交失败
+	tx = tm.StartTransaction()
+	err = server.UpdateByIDTx(tx, tableName, map[string]interface{}{"age": "unknown"}, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = server.AlterColumnType(tableName, "age", INT)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = tx.Commit()
+	if err != ErrMismatchFieldType {
+		t.Fatalf("expected %v got %v", ErrMismatchFieldType, err)
+	}
+	record, err = server.SelectByID(tableName, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if record.Value[0] != "18" {
+		t.Fatalf("unexpected %v", record.Value)
+	}
+}
@@ -2,7 +2,6 @@ package IDB
 
 import (
 	"errors"
-	"reflect"
 	"sync"
 )
 
@@ -33,6 +32,8 @@ type Tree struct {
 	mu         *sync.RWMutex
 	recordLock *sync.RWMutex
 	inspector  Inspector
+	// 记录数量，插入删除时维护
+	count int
 }
 
 // pointer 0, 1, 2 ... last point to sliding(count n+1)
@@ -74,10 +75,6 @@ func (t *Tree) WithInspector(inspector Inspector) {
 
 func (t *Tree) Insert(record *Record) error {
 	key := record.Key
-	// 尝试找到key，若能找到则返回已经存在的错误
-	if _, err := t.Find(key); err == nil {
-		return ErrKeyExists
-	}
 
 	// 若根节点为空，则创建新树
 	// 锁。若thread1创建好了，可是thread2看到的却还是nil，这就会出问题
@@ -85,19 +82,29 @@ func (t *Tree) Insert(record *Record) error {
 	defer t.mu.Unlock()
 	if t.Root == nil {
 		t.createNewTree(key, record)
+		t.count++
 		return nil
 	}
 
 	// 找到key对应的叶节点
 	leaf := t.findLeaf(key)
 
+	// 尝试找到key，若能找到则返回已经存在的错误。在锁内检查，避免并发插入相同key
+	for i := 0; i < leaf.NumKeys; i++ {
+		if leaf.Keys[i] == key {
+			return ErrKeyExists
+		}
+	}
+
 	// 若找到叶节点数量小于order直接插入
 	if leaf.NumKeys < order-1 {
 		insertIntoLeaf(leaf, key, record)
+		t.count++
 		return nil
 	}
 
 	t.insertIntoLeafAfterSplitting(leaf, key, record)
+	t.count++
 	return nil
 }
 
@@ -180,11 +187,12 @@ func (t *Tree) insertIntoLeafAfterSplitting(leaf *Node, key int, r *Record) {
 	nl.Parent = leaf.Parent
 
 	// 将新叶节点插入父节点
-	t.insertIntoParent(leaf, nl.Keys[nl.NumKeys-1], nl)
+	t.insertIntoParent(leaf, nl)
 }
 
-// insertIntoParent 插入右节点到parent
-func (t *Tree) insertIntoParent(left *Node, key int, right *Node) {
+// insertIntoParent 插入右节点到parent。除最后一个子节点外，父节点的key是子节点key的上界，
+// 右节点继承原节点的上界，原节点的上界改为它现在最后一个key。删除时调整节点也遵守同样的约定
+func (t *Tree) insertIntoParent(left *Node, right *Node) {
 	// 若parent为nil，则新建parent插入
 	parent := left.Parent
 	if parent == nil {
@@ -195,10 +203,15 @@ func (t *Tree) insertIntoParent(left *Node, key int, right *Node) {
 	// 更新left key
 	// TODO 困扰我这么久的为什么找到key居然是被删除了的
 	leftIndex := getNodeIndex(parent, left)
+	key := parent.Keys[leftIndex]
+	// 最后一个子节点接收所有更大的key，它的key不是上界
+	if leftIndex == parent.NumKeys-1 {
+		key = right.Keys[right.NumKeys-1]
+	}
 	parent.Keys[leftIndex] = left.Keys[left.NumKeys-1]
 
 	// 若父节点子节点未满，直接插入节点中
-	if parent.NumKeys < order-1 {
+	if parent.NumKeys < maxEntries(parent) {
 		insertIntoNode(parent, leftIndex+1, key, right)
 		return
 	}
@@ -245,8 +258,8 @@ func insertIntoNode(parent *Node, rightIndex, key int, right *Node) {
 
 // insertIntoNodeAfterSplitting 在分裂之后插入新节点
 func (t *Tree) insertIntoNodeAfterSplitting(oldNode *Node, rightIndex, key int, right *Node) {
-	values := make([]interface{}, order)
-	keys := make([]int, order)
+	values := make([]interface{}, order+1)
+	keys := make([]int, order+1)
 
 	// 将旧节点value、key复制到临时keys、values
 	var j int
@@ -272,7 +285,7 @@ func (t *Tree) insertIntoNodeAfterSplitting(oldNode *Node, rightIndex, key int,
 	// 将临时keys、values前半部分移到旧节点前半部分，后半部分移到新节点前半部分
 	// TODO 为什么没有将oldNode后半部分置为nil呢？只要有numKeys标记就可以了，为什么还要置为nil呢
 	newNode := makeNode()
-	split := cut(order)
+	split := cut(order + 1)
 	oldNode.NumKeys = 0
 	var valuesIndex int
 	for valuesIndex = 0; valuesIndex < split; valuesIndex++ {
@@ -281,7 +294,7 @@ func (t *Tree) insertIntoNodeAfterSplitting(oldNode *Node, rightIndex, key int,
 		oldNode.NumKeys++
 	}
 	var l int
-	for l = 0; valuesIndex < order; valuesIndex++ {
+	for l = 0; valuesIndex < order+1; valuesIndex++ {
 		newNode.Pointers[l] = values[valuesIndex]
 		newNode.Keys[l] = keys[valuesIndex]
 		newNode.NumKeys++
@@ -296,7 +309,7 @@ func (t *Tree) insertIntoNodeAfterSplitting(oldNode *Node, rightIndex, key int,
 	}
 
 	// 插入新节点到parent
-	t.insertIntoParent(oldNode, newNode.Keys[newNode.NumKeys-1], newNode)
+	t.insertIntoParent(oldNode, newNode)
 }
 
 func (t *Tree) createNewTree(key int, r *Record) {
@@ -368,11 +381,11 @@ func (t *Tree) UpdateRecord(updatedData map[int]string, key int, ma metaAlter) e
 	// 更新数据
 	sameValueUpdate := 0
 	for index, value := range updatedData {
-		if record.Value[index] == value {
+		if index < len(record.Value) && record.Value[index] == value {
 			sameValueUpdate++
 			continue
 		}
-		record.Value[index] = value
+		record.Value = setSlot(record.Value, index, value)
 	}
 	if sameValueUpdate == len(updatedData) {
 		return ErrUpdateSame
@@ -383,38 +396,117 @@ func (t *Tree) UpdateRecord(updatedData map[int]string, key int, ma metaAlter) e
 	return nil
 }
 
+// restoreRecord 恢复record的值以及meta，不通知inspector。用于撤销提交失败的事务已经执行的更新
+func (t *Tree) restoreRecord(key int, values []string, meta *RecordMeta) error {
+	record, err := t.Find(key)
+	if err != nil {
+		return err
+	}
+
+	t.recordLock.Lock()
+	if record.lock == nil {
+		record.lock = &sync.Mutex{}
+	}
+	record.lock.Lock()
+	defer record.lock.Unlock()
+	t.recordLock.Unlock()
+
+	if record.deleted {
+		return ErrKeyNotFound
+	}
+	record.Value = values
+	record.Meta = meta
+	return nil
+}
+
 func (t *Tree) FineByValue(isTarget IsTarget) ([]*Record, error) {
+	// 遍历叶节点，记录所有符合条件的记录
+	rs := make([]*Record, 0)
+	t.scanLeaves(func(r *Record) bool {
+		if isTarget(r) {
+			rs = append(rs, r)
+		}
+		return true
+	})
+
+	if len(rs) == 0 {
+		return nil, ErrValueNotFound
+	}
+	return rs, nil
+}
+
+// Count 统计树中记录数量
+func (t *Tree) Count() int {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	return t.count
+}
+
+// scanLeaves 从最左边的叶节点开始按key顺序遍历所有记录。fn返回false时停止遍历
+func (t *Tree) scanLeaves(fn func(r *Record) bool) {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
 	// 找到最左边的叶节点
 	n := t.Root
 	if n == nil {
-		return nil, ErrValueNotFound
+		return
 	}
-
 	for !n.IsLeaf {
 		n = n.Pointers[0].(*Node)
 	}
 
-	// 遍历叶节点，记录所有符合条件的记录
-	rs := make([]*Record, 0)
-	var r *Record
+	// 叶节点的右兄弟节点在pointers最后一位
 	ok := true
 	for ok {
 		for i := 0; i < n.NumKeys; i++ {
-			r = n.Pointers[i].(*Record)
-			if isTarget(r) {
-				rs = append(rs, r)
+			if !fn(n.Pointers[i].(*Record)) {
+				return
 			}
 		}
-		if n.Pointers[order-1] == nil {
+		if n.Pointers[order] == nil {
 			break
 		}
-		n, ok = n.Pointers[order-1].(*Node)
+		n, ok = n.Pointers[order].(*Node)
 	}
+}
 
-	if len(rs) == 0 {
-		return nil, ErrValueNotFound
+// scanRange 按key顺序遍历[lo, hi]之间的记录。fn返回false时停止遍历
+func (t *Tree) scanRange(lo, hi int, fn func(r *Record) bool) {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	n := t.findLeaf(lo)
+	for n != nil {
+		for i := 0; i < n.NumKeys; i++ {
+			if n.Keys[i] < lo {
+				continue
+			}
+			if n.Keys[i] > hi || !fn(n.Pointers[i].(*Record)) {
+				return
+			}
+		}
+		n, _ = n.Pointers[order].(*Node)
 	}
-	return rs, nil
+}
+
+// keyBounds 最小以及最大的key，空树时ok为false
+func (t *Tree) keyBounds() (min, max int, ok bool) {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	if t.Root == nil {
+		return 0, 0, false
+	}
+	// 空叶节点在删除时已经被移除
+	l, r := t.Root, t.Root
+	for !l.IsLeaf {
+		l = l.Pointers[0].(*Node)
+	}
+	for !r.IsLeaf {
+		r = r.Pointers[r.NumKeys-1].(*Node)
+	}
+	return l.Keys[0], r.Keys[r.NumKeys-1], true
 }
 
 func (t *Tree) Find(key int) (*Record, error) {
@@ -476,7 +568,17 @@ func cut(l int) int {
 	return l/2 + 1
 }
 
+// Delete 删除key对应的record，不通知inspector
 func (t *Tree) Delete(key int) error {
+	return t.delete(key, false)
+}
+
+// deleteInspected 删除前把原来的数据交给inspector，用于事务提交时的删除
+func (t *Tree) deleteInspected(key int) error {
+	return t.delete(key, true)
+}
+
+func (t *Tree) delete(key int, inspect bool) error {
 	record, err := t.Find(key)
 	if err != nil {
 		return err
@@ -499,13 +601,13 @@ func (t *Tree) Delete(key int) error {
 	}
 
 	// 删除前处理数据
-	if t.inspector != nil {
+	if inspect && t.inspector != nil {
 		t.inspector.HandleDataBeforeDelete(record)
 	}
 
 	leaf := t.findLeaf(key)
 	if record != nil && leaf != nil {
-		err = t.deleteEntry(leaf, key, record)
+		err = t.deleteEntry(leaf, key)
 		if err != nil {
 			if err == ErrNoSuchChild {
 				return ErrKeyNotFound
@@ -515,198 +617,214 @@ func (t *Tree) Delete(key int) error {
 	}
 
 	record.deleted = true
+	t.count--
 
 	return nil
 }
 
-func (t *Tree) deleteEntry(node *Node, key int, p interface{}) error {
-	originNKey := node.Keys[node.NumKeys-1]
-	// 从node删除该条目，并返回该node
-	var err error
-	if node.IsLeaf {
-		node, err = removeEntryFromLeaf(node, key)
-	} else {
-		node, err = removeEntryFromNode(node, p)
-	}
+// deleteEntry 从叶节点删除key，之后叶节点少于最少数量时调整树
+func (t *Tree) deleteEntry(leaf *Node, key int) error {
+	_, err := removeEntryFromLeaf(leaf, key)
 	if err != nil {
 		return err
 	}
+	return t.rebalance(leaf)
+}
 
-	// 若删除条目的node为root，就调节一下root
-	if node == t.Root {
-		t.adjustRoot()
-		return nil
+// maxEntries 节点最多的条目数量。叶节点最多order-1个key，非叶节点最多order个子节点
+func maxEntries(n *Node) int {
+	if n.IsLeaf {
+		return order - 1
 	}
+	return order
+}
 
-	// 当节点大于等于要求的最小数量时就直接返回
-	if node.NumKeys >= cut(order-1) {
-		return nil
+// minEntries 非根节点最少的条目数量，不超过分裂后较小一半的数量
+func minEntries(n *Node) int {
+	if n.IsLeaf {
+		return cut(order - 1)
 	}
+	return cut(order)
+}
 
-	// 若parent只有一个节点，那么也不需要迁移合并了
-	if node.Parent.NumKeys == 1 {
+// rebalance 节点少于最少数量时，与兄弟节点的条目总数放得下就合并，否则从兄弟节点借一个条目。
+// 合并后父节点少了一个子节点，继续向上调整。除最后一个子节点外，父节点的key是子节点key的上界，移动条目后需要更新
+func (t *Tree) rebalance(n *Node) error {
+	if n == t.Root {
+		t.adjustRoot()
+		return nil
+	}
+	if n.NumKeys >= minEntries(n) {
 		return nil
 	}
 
-	// 找到邻居节点。若节点是0节点，那么邻居就是1节点；其他情况，邻居为左节点
-	neighbourIndex := getNeighbourIndex(node)
-	if neighbourIndex >= node.Parent.NumKeys {
+	parent := n.Parent
+	i := getNodeIndex(parent, n)
+	if i >= parent.NumKeys {
 		return ErrNoSuchChild
 	}
-	var neighbour *Node
-	if neighbourIndex == -1 {
-		// TODO 能确保node是零节点的时候，一定存在一节点吗？不能保证
-		neighbour, _ = node.Parent.Pointers[1].(*Node)
-	} else {
-		// 为何此处neighbourIndex超出数量限制呢？除非在父节点根本找不到
-		neighbour, _ = node.Parent.Pointers[neighbourIndex].(*Node)
+	// 没有兄弟节点时只能移除空节点，由父节点继续调整
+	if parent.NumKeys == 1 {
+		if n.NumKeys > 0 {
+			return nil
+		}
+		if n.IsLeaf {
+			if prev := prevLeaf(n); prev != nil {
+				prev.Pointers[order] = n.Pointers[order]
+			}
+		}
+		if _, err := removeEntryFromNode(parent, n); err != nil {
+			return err
+		}
+		return t.rebalance(parent)
 	}
 
-	var kPrimeIndex int
-	if neighbourIndex == -1 {
-		kPrimeIndex = 0
+	// 优先与左边的兄弟节点调整
+	li := i - 1
+	if i == 0 {
+		li = 0
+	}
+	left, right := parent.Pointers[li].(*Node), parent.Pointers[li+1].(*Node)
+	if left.NumKeys+right.NumKeys <= maxEntries(n) {
+		if err := mergeNodes(parent, li, left, right); err != nil {
+			return err
+		}
+		return t.rebalance(parent)
+	}
+	if n == right {
+		moveLastToRight(left, right, parent.Keys[li])
 	} else {
-		kPrimeIndex = neighbourIndex
+		moveFirstToLeft(left, right, parent.Keys[li])
 	}
+	parent.Keys[li] = left.Keys[left.NumKeys-1]
+	return nil
+}
 
-	if neighbour.NumKeys+node.NumKeys < order {
-		// 当邻居节点数量与节点数量总和小于容量时，就合并节点
-		return t.coalesceNodes(node, neighbour, neighbourIndex, originNKey)
-	} else {
-		// 当邻居节点数量与节点数量总和大于容量时，就迁移node第一个节点到邻居节点
-		t.redistributeNodes(node, neighbour, neighbourIndex, kPrimeIndex)
+// mergeNodes 将右节点的条目移到左节点，并从父节点移除右节点。合并后左节点的上界为原右节点的上界
+func mergeNodes(parent *Node, li int, left, right *Node) error {
+	closeLast(left, parent.Keys[li])
+	for j := 0; j < right.NumKeys; j++ {
+		appendEntry(left, right.Keys[j], right.Pointers[j])
 	}
+	if left.IsLeaf {
+		left.Pointers[order] = right.Pointers[order]
+	}
+	parent.Keys[li] = parent.Keys[li+1]
+	_, err := removeEntryFromNode(parent, right)
+	return err
+}
 
-	return nil
+// closeLast 非叶节点的最后一个子节点接收所有更大的key，它的key不一定是上界。
+// 之后还要添加条目时，使用该节点在父节点中的上界
+func closeLast(n *Node, bound int) {
+	if !n.IsLeaf && n.NumKeys > 0 {
+		n.Keys[n.NumKeys-1] = bound
+	}
+}
+
+// moveLastToRight 左节点最后一个条目移到右节点开头，bound为左节点在父节点中的上界
+func moveLastToRight(left, right *Node, bound int) {
+	closeLast(left, bound)
+	for j := right.NumKeys; j > 0; j-- {
+		right.Keys[j] = right.Keys[j-1]
+		right.Pointers[j] = right.Pointers[j-1]
+	}
+	last := left.NumKeys - 1
+	right.Keys[0] = left.Keys[last]
+	right.Pointers[0] = left.Pointers[last]
+	right.NumKeys++
+	if !right.IsLeaf {
+		right.Pointers[0].(*Node).Parent = right
+	}
+	left.NumKeys--
+	left.Pointers[left.NumKeys] = nil
+}
+
+// moveFirstToLeft 右节点第一个条目移到左节点末尾，bound为左节点在父节点中的上界
+func moveFirstToLeft(left, right *Node, bound int) {
+	closeLast(left, bound)
+	appendEntry(left, right.Keys[0], right.Pointers[0])
+	for j := 1; j < right.NumKeys; j++ {
+		right.Keys[j-1] = right.Keys[j]
+		right.Pointers[j-1] = right.Pointers[j]
+	}
+	right.NumKeys--
+	right.Pointers[right.NumKeys] = nil
+}
+
+// appendEntry 在节点末尾添加条目，非叶节点同时修改子节点的parent
+func appendEntry(n *Node, key int, p interface{}) {
+	n.Keys[n.NumKeys] = key
+	n.Pointers[n.NumKeys] = p
+	n.NumKeys++
+	if child, ok := p.(*Node); ok {
+		child.Parent = n
+	}
 }
 
 func removeEntryFromLeaf(n *Node, key int) (*Node, error) {
 	// 找到key对应位置
-	// TODO index out of range 2 with len 2
-	// 我第一个猜想是findLeaf并没有找到leaf。居然没有发现findLeaf找到非leaf的情况
-	// coalesceNodes 传入的节点并非叶节点，里面的方法却当叶节点在用
 	var delPoint int
-	for n.Keys[delPoint] != key {
+	for delPoint < n.NumKeys && n.Keys[delPoint] != key {
 		delPoint++
 	}
 	if delPoint >= n.NumKeys {
 		return nil, ErrNoSuchChild
 	}
 
-	// 删除该key
+	// 删除该key以及pointer
 	for i := delPoint + 1; i < n.NumKeys; i++ {
 		n.Keys[i-1] = n.Keys[i]
-	}
-
-	// 删除pointer
-	for i := delPoint + 1; i < n.NumKeys; i++ {
 		n.Pointers[i-1] = n.Pointers[i]
 	}
 	n.NumKeys--
+	n.Pointers[n.NumKeys] = nil
 
 	return n, nil
 }
 
-func removeEntryFromNode(n *Node, p interface{}) (*Node, error) {
-	var nodeIndex int
-	for ; nodeIndex < n.NumKeys; nodeIndex++ {
-		if reflect.DeepEqual(n.Pointers[nodeIndex], p) {
-			break
-		}
-	}
+func removeEntryFromNode(n *Node, child *Node) (*Node, error) {
+	nodeIndex := getNodeIndex(n, child)
 	if nodeIndex >= n.NumKeys {
 		// no such child
 		return nil, ErrNoSuchChild
 	}
 
-	// 删除对应子节点
+	// 删除对应子节点以及key
 	for j := nodeIndex + 1; j < n.NumKeys; j++ {
 		n.Pointers[j-1] = n.Pointers[j]
-	}
-
-	// 删除对应key。
-	// 那是不是key向前移，替换到i-1的key呢？
-	for i := nodeIndex + 1; i < n.NumKeys; i++ {
-		n.Keys[i-1] = n.Keys[i]
+		n.Keys[j-1] = n.Keys[j]
 	}
 	n.NumKeys--
+	n.Pointers[n.NumKeys] = nil
 
 	return n, nil
 }
 
-// adjustRoot 当从root删除数据，就调节root节点
+// adjustRoot 调节root。没有条目时为空树，非叶节点只有一个子节点时子节点成为root
 func (t *Tree) adjustRoot() {
-	// root还有key就不用调节
-	if t.Root.NumKeys > 0 {
-		return
+	for t.Root != nil && !t.Root.IsLeaf && t.Root.NumKeys == 1 {
+		t.Root = t.Root.Pointers[0].(*Node)
+		t.Root.Parent = nil
 	}
-
-	t.Root = nil
-}
-
-// getNeighbourIndex 找到节点的左节点位置
-func getNeighbourIndex(n *Node) int {
-	var i int
-	for i = 0; i <= n.Parent.NumKeys; i++ {
-		if reflect.DeepEqual(n.Parent.Pointers[i], n) {
-			return i - 1
-		}
+	if t.Root != nil && t.Root.NumKeys == 0 {
+		t.Root = nil
 	}
-	return i
 }
 
-func (t *Tree) coalesceNodes(n, neighbour *Node, neighbourIndex, originalNKey int) error {
-	// 当节点为最左节点时，交换节点和邻居节点。此后，节点为原节点1
-	if neighbourIndex == -1 {
-		n, neighbour = neighbour, n
-		originalNKey = n.Keys[n.NumKeys-1]
-	}
-
-	// TODO 这里居然index也会为-1
-	//originalNKey := n.Keys[n.NumKeys-1]
-
-	var j int
-	i := neighbour.NumKeys
-	for j = 0; j < n.NumKeys; j++ {
-		neighbour.Keys[i] = n.Keys[j]
-		neighbour.Pointers[i] = n.Pointers[j]
-		neighbour.NumKeys++
-		n.NumKeys--
-		i++
-	}
-
-	if n.IsLeaf {
-		neighbour.Pointers[order] = n.Pointers[order]
-	} else {
-		for k := 0; k < neighbour.NumKeys; k++ {
-			neighbour.Pointers[i].(*Node).Parent = neighbour
+// prevLeaf 找到叶节点左边的叶节点
+func prevLeaf(n *Node) *Node {
+	c := n
+	for c.Parent != nil {
+		i := getNodeIndex(c.Parent, c)
+		if i > 0 {
+			p := c.Parent.Pointers[i-1].(*Node)
+			for !p.IsLeaf {
+				p = p.Pointers[p.NumKeys-1].(*Node)
+			}
+			return p
 		}
+		c = c.Parent
 	}
-
-	// 从父节点中删去n节点
-	// TODO 若n节点全被删了的话，那么可能就无法从parent删除了。所以key应该为原来的
-	return t.deleteEntry(n.Parent, originalNKey, n)
-}
-
-// redistributeNodes 移动节点一值到邻居节点
-func (t *Tree) redistributeNodes(n, neighbor *Node, neighbourIndex, neighborIndexInParent int) {
-	if neighbourIndex == -1 {
-		n, neighbor = neighbor, n
-	}
-	if neighbor.NumKeys >= order-1 {
-		return
-	}
-
-	neighbor.Keys[neighbor.NumKeys] = n.Keys[0]
-	neighbor.Pointers[neighbor.NumKeys] = n.Pointers[0]
-	neighbor.Parent.Keys[neighborIndexInParent] = n.Keys[0]
-
-	var i int
-	for i = 0; i < n.NumKeys; i++ {
-		n.Keys[i] = n.Keys[i+1]
-		n.Pointers[i] = n.Pointers[i+1]
-	}
-
-	n.NumKeys--
-	neighbor.NumKeys++
+	return nil
 }
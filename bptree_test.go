@@ -0,0 +1,263 @@
+package IDB
+
+import (
+	"fmt"
+	"math/rand"
+	"strconv"
+	"testing"
+)
+
+func TestRandomInsertDelete(t *testing.T) {
+	defer func(o int) { order = o }(order)
+	for _, o := range []int{3, 4, 5, 7} {
+		order = o
+		tree := NewTree()
+		count := 2000
+		keys := rand.Perm(count)
+		for _, k := range keys {
+			err := tree.Insert(&Record{Key: k, Value: []string{strconv.Itoa(k)}})
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+		checkTree(t, tree)
+
+		// 随机删除一半，剩下的都能找到且按顺序遍历
+		exists := make(map[int]bool, count)
+		for _, k := range keys {
+			exists[k] = true
+		}
+		for i, k := range rand.Perm(count)[:count/2] {
+			err := tree.Delete(k)
+			if err != nil {
+				t.Fatalf("order %d: delete %d: %v", o, k, err)
+			}
+			delete(exists, k)
+			if i%100 == 0 {
+				checkTree(t, tree)
+			}
+		}
+		checkTree(t, tree)
+		for k := 0; k < count; k++ {
+			_, err := tree.Find(k)
+			if exists[k] != (err == nil) {
+				t.Fatalf("order %d: find %d expected exists %v got %v", o, k, exists[k], err)
+			}
+		}
+
+		// 删除之后还能继续插入
+		for k := range exists {
+			err := tree.Delete(k)
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+		if tree.Root != nil {
+			t.Fatalf("order %d: tree should be empty", o)
+		}
+		for _, k := range keys[:100] {
+			err := tree.Insert(&Record{Key: k, Value: []string{strconv.Itoa(k)}})
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+		checkTree(t, tree)
+		if tree.Count() != 100 {
+			t.Fatalf("order %d: expected 100 got %d", o, tree.Count())
+		}
+	}
+}
+
+func TestInterleavedInsertDelete(t *testing.T) {
+	// 删除调整过的节点再分裂时，父节点的key仍然是上界
+	tree := NewTree()
+	ops := []struct {
+		insert bool
+		keys   []int
+	}{
+		{true, []int{3, 7, 6, 10, 11}},
+		{false, []int{11, 6}},
+		{true, []int{4, 5, 9, 0}},
+		{false, []int{4}},
+		{true, []int{6, 2}},
+	}
+	for _, op := range ops {
+		for _, k := range op.keys {
+			var err error
+			if op.insert {
+				err = tree.Insert(&Record{Key: k})
+			} else {
+				err = tree.Delete(k)
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			checkTree(t, tree)
+		}
+	}
+	if _, err := tree.Find(6); err != nil {
+		t.Fatal(err)
+	}
+	if err := tree.Insert(&Record{Key: 6}); err != ErrKeyExists {
+		t.Fatalf("expected %v got %v", ErrKeyExists, err)
+	}
+
+	// 随机交替插入删除，与map比较数量、查找以及范围遍历的结果
+	defer func(o int) { order = o }(order)
+	rnd := rand.New(rand.NewSource(1))
+	for _, o := range []int{3, 4, 5, 7} {
+		order = o
+		tree = NewTree()
+		exists := make(map[int]bool)
+		keyRange := 300
+		for i := 0; i < 20000; i++ {
+			k := rnd.Intn(keyRange)
+			if rnd.Intn(2) == 0 {
+				err := tree.Insert(&Record{Key: k})
+				if exists[k] != (err == ErrKeyExists) || (err != nil && err != ErrKeyExists) {
+					t.Fatalf("order %d: insert %d: exists %v got %v", o, k, exists[k], err)
+				}
+				exists[k] = true
+			} else {
+				err := tree.Delete(k)
+				if exists[k] != (err == nil) {
+					t.Fatalf("order %d: delete %d: exists %v got %v", o, k, exists[k], err)
+				}
+				delete(exists, k)
+			}
+			if i%500 == 0 {
+				checkTree(t, tree)
+			}
+		}
+		checkTree(t, tree)
+
+		if tree.Count() != len(exists) {
+			t.Fatalf("order %d: expected %d got %d", o, len(exists), tree.Count())
+		}
+		for k := 0; k < keyRange; k++ {
+			_, err := tree.Find(k)
+			if exists[k] != (err == nil) {
+				t.Fatalf("order %d: find %d: exists %v got %v", o, k, exists[k], err)
+			}
+		}
+		for i := 0; i < 100; i++ {
+			lo := rnd.Intn(keyRange)
+			hi := lo + rnd.Intn(keyRange/4)
+			var got []int
+			tree.scanRange(lo, hi, func(r *Record) bool {
+				got = append(got, r.Key)
+				return true
+			})
+			var expected []int
+			for k := lo; k <= hi; k++ {
+				if exists[k] {
+					expected = append(expected, k)
+				}
+			}
+			if fmt.Sprint(got) != fmt.Sprint(expected) {
+				t.Fatalf("order %d: scan [%d, %d]: expected %v got %v", o, lo, hi, expected, got)
+			}
+		}
+	}
+}
+
+func TestDeleteRebalance(t *testing.T) {
+	tree := NewTree()
+	for k := 1; k <= 64; k++ {
+		tree.Insert(&Record{Key: k})
+	}
+	height := treeHeight(tree)
+
+	// 按顺序删除时不断合并，树高随之降低
+	for k := 1; k <= 60; k++ {
+		err := tree.Delete(k)
+		if err != nil {
+			t.Fatal(err)
+		}
+		checkTree(t, tree)
+	}
+	if h := treeHeight(tree); h >= height {
+		t.Fatalf("expected lower height than %d got %d", height, h)
+	}
+	err := tree.Delete(1)
+	if err != ErrKeyNotFound {
+		t.Fatalf("expected %v got %v", ErrKeyNotFound, err)
+	}
+}
+
+func treeHeight(tree *Tree) int {
+	h := 0
+	for n := tree.Root; n != nil; h++ {
+		if n.IsLeaf {
+			return h + 1
+		}
+		n = n.Pointers[0].(*Node)
+	}
+	return h
+}
+
+// checkTree 检查节点的key有序、除最后一个子节点外父节点的key是子节点key的上界、非根节点不少于最少数量、叶节点深度相同以及叶节点链表完整
+func checkTree(t *testing.T, tree *Tree) {
+	t.Helper()
+	if tree.Root == nil {
+		return
+	}
+	if tree.Root.Parent != nil {
+		t.Fatal("root has parent")
+	}
+	leafDepth := -1
+	var leaves []*Node
+	var check func(n *Node, depth int) (int, int)
+	check = func(n *Node, depth int) (int, int) {
+		if n != tree.Root && n.NumKeys < minEntries(n) {
+			t.Fatalf("node has %d entries, min %d", n.NumKeys, minEntries(n))
+		}
+		if n.NumKeys > maxEntries(n) {
+			t.Fatalf("node has %d entries, max %d", n.NumKeys, maxEntries(n))
+		}
+		for i := 1; i < n.NumKeys; i++ {
+			if n.Keys[i-1] >= n.Keys[i] {
+				t.Fatalf("unordered keys %v", n.Keys[:n.NumKeys])
+			}
+		}
+		if n.IsLeaf {
+			if leafDepth == -1 {
+				leafDepth = depth
+			}
+			if depth != leafDepth {
+				t.Fatalf("leaf depth %d, expected %d", depth, leafDepth)
+			}
+			leaves = append(leaves, n)
+			return n.Keys[0], n.Keys[n.NumKeys-1]
+		}
+		min, max := 0, 0
+		prevMax := 0
+		for i := 0; i < n.NumKeys; i++ {
+			child := n.Pointers[i].(*Node)
+			if child.Parent != n {
+				t.Fatal("wrong parent")
+			}
+			lo, hi := check(child, depth+1)
+			// 最后一个子节点接收所有更大的key
+			if (i < n.NumKeys-1 && hi > n.Keys[i]) || (i > 0 && lo <= n.Keys[i-1]) || (i > 0 && lo <= prevMax) {
+				t.Fatalf("child keys [%d, %d] out of bound %v", lo, hi, n.Keys[:n.NumKeys])
+			}
+			if i == 0 {
+				min = lo
+			}
+			max, prevMax = hi, hi
+		}
+		return min, max
+	}
+	check(tree.Root, 0)
+
+	for i, l := range leaves {
+		var next *Node
+		if i+1 < len(leaves) {
+			next = leaves[i+1]
+		}
+		if n, _ := l.Pointers[order].(*Node); n != next {
+			t.Fatal("broken leaf link")
+		}
+	}
+}
@@ -0,0 +1,215 @@
+package IDB
+
+// UpdateWhere 更新满足条件的数据，filter为nil时更新所有数据，返回更新的数量，值没有变化的不算在内。
+// 更新期间持有表的写锁，任一record更新失败时已经更新的record会被恢复
+func (s *idbServer) UpdateWhere(tableName string, filter Expr, values map[string]interface{}) (int, error) {
+	t, err := s.getTable(tableName)
+	if err != nil {
+		return 0, err
+	}
+
+	unlock := s.lockForWrite(map[string]*table{tableName: t}, true, false)
+	defer unlock()
+
+	data, err := convValuesToBPlusData(t, values)
+	if err != nil {
+		return 0, err
+	}
+	records, err := t.matchRecords(filter)
+	if err != nil {
+		return 0, err
+	}
+
+	// 更新外键字段时父表数据需要存在
+	view := &fkView{s: s}
+	var updated []*Record
+	for _, r := range records {
+		err = view.checkParents(tableName, t, applyChange(r, data), data)
+		if err == nil {
+			err = t.updateRecord(data, r.Key, nil)
+		}
+		if err == ErrUpdateSame {
+			continue
+		}
+		if err != nil {
+			err = withTableName(err, tableName)
+			if rerr := t.restoreRecords(updated); rerr != nil {
+				return 0, &RevertError{Err: err, RevertErr: rerr}
+			}
+			return 0, err
+		}
+		updated = append(updated, r)
+	}
+	return len(updated), nil
+}
+
+// DeleteWhere 删除满足条件的数据，filter为nil时删除所有数据，返回删除的数量，不包括级联删除的数据。
+// 先检查所有record的外键引用，任一record被RESTRICT引用时不删除任何数据，级联操作失败时恢复已经删除的数据
+func (s *idbServer) DeleteWhere(tableName string, filter Expr) (int, error) {
+	t, err := s.getTable(tableName)
+	if err != nil {
+		return 0, err
+	}
+
+	unlock := s.lockForWrite(map[string]*table{tableName: t}, false, true)
+	defer unlock()
+
+	records, err := t.matchRecords(filter)
+	if err != nil {
+		return 0, err
+	}
+
+	plan := newFKPlan()
+	view := &fkView{s: s}
+	var ids []int
+	for _, r := range records {
+		// 自引用的外键可能已经级联删除
+		if plan.isDeleted(t, r.Key) {
+			continue
+		}
+		if err = view.planDelete(plan, tableName, t, r.Key); err != nil {
+			return 0, err
+		}
+		ids = append(ids, r.Key)
+	}
+
+	err = plan.apply(tableName, t, ids)
+	if err != nil {
+		return 0, err
+	}
+	return len(ids), nil
+}
+
+// UpdateWhereTx 在事务中更新满足条件的数据，每个record记录在事务缓存中，提交时写入。
+// 先检查所有record再写入事务缓存，失败时事务缓存不变
+func (s *idbServer) UpdateWhereTx(tx *Tx, tableName string, filter Expr, values map[string]interface{}) (int, error) {
+	c, err := s.findTableTxCache(tx, tableName)
+	if err != nil {
+		return 0, err
+	}
+	ids, err := s.matchIDsTx(tx, tableName, filter)
+	if err != nil {
+		return 0, err
+	}
+
+	fields := c.t.meta.getFields()
+	view := &fkView{s: s, cache: tx.cache}
+	changes := make(map[int]map[int]string, len(ids))
+	for _, id := range ids {
+		data, err := s.prepareUpdateTx(tx, tableName, c.t, values, id)
+		if err != nil {
+			return 0, withTableName(err, tableName)
+		}
+		// 值没有变化的不算在内
+		if r, ok := view.find(tableName, c.t, id); ok && changesRecord(fields, r, data) {
+			changes[id] = data
+		}
+	}
+
+	for _, id := range ids {
+		if data, ok := changes[id]; ok {
+			if err = updateInTxCache(c, tx.id, id, data); err != nil {
+				return 0, err
+			}
+		}
+	}
+	return len(changes), nil
+}
+
+// DeleteWhereTx 在事务中删除满足条件的数据，级联操作也在事务中执行。
+// 先检查所有record的外键引用，任一record被RESTRICT引用时事务缓存不变
+func (s *idbServer) DeleteWhereTx(tx *Tx, tableName string, filter Expr) (int, error) {
+	c, err := s.findTableTxCache(tx, tableName)
+	if err != nil {
+		return 0, err
+	}
+	ids, err := s.matchIDsTx(tx, tableName, filter)
+	if err != nil {
+		return 0, err
+	}
+
+	plan := newFKPlan()
+	view := &fkView{s: s, cache: tx.cache}
+	var roots []int
+	for _, id := range ids {
+		// 自引用的外键可能已经级联删除
+		if plan.isDeleted(c.t, id) {
+			continue
+		}
+		if err = view.planDelete(plan, tableName, c.t, id); err != nil {
+			return 0, err
+		}
+		roots = append(roots, id)
+	}
+
+	for _, id := range roots {
+		err = deleteInTxCache(c, id)
+		if err != nil && err != ErrKeyNotFound {
+			return 0, err
+		}
+	}
+	return len(roots), plan.applyTx(s, tx)
+}
+
+// matchRecords 满足条件的record的副本，树中的record更新时会被原地修改。调用方需持有writeMu
+func (t *table) matchRecords(filter Expr) ([]*Record, error) {
+	if filter == nil {
+		filter = And()
+	}
+	fields := t.meta.getFields()
+	isTarget, err := bindTarget(filter, fields)
+	if err != nil {
+		return nil, err
+	}
+	var records []*Record
+	t.scan(fields, filter, func(r *Record) bool {
+		if isTarget(r) {
+			records = append(records, applyChange(r, nil))
+		}
+		return true
+	})
+	return records, nil
+}
+
+// restoreRecords 按相反顺序恢复record更新前的值。某个record恢复失败时继续恢复其余record，返回第一个错误
+func (t *table) restoreRecords(records []*Record) error {
+	var first error
+	for i := len(records) - 1; i >= 0; i-- {
+		r := records[i]
+		data := make(map[int]string, len(r.Value))
+		for pos, v := range r.Value {
+			data[pos] = v
+		}
+		err := t.updateRecord(data, r.Key, nil)
+		if err != nil && err != ErrUpdateSame && first == nil {
+			first = err
+		}
+	}
+	return first
+}
+
+// matchIDsTx 事务中满足条件的record id
+func (s *idbServer) matchIDsTx(tx *Tx, tableName string, filter Expr) ([]int, error) {
+	records, err := s.selectWhere(tx, tableName, filter, nil)
+	if err == ErrValueNotFound {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	ids := make([]int, len(records))
+	for i, r := range records {
+		ids[i] = r.Key
+	}
+	return ids, nil
+}
+
+// changesRecord 更新是否会改变record的值
+func changesRecord(fields []*FieldMeta, r *Record, data map[int]string) bool {
+	for _, f := range fields {
+		if v, ok := data[f.pos]; ok && v != fieldValue(f, r) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,139 @@
+package IDB
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestUpdateDeleteWhere(t *testing.T) {
+	server := NewIDBServer()
+	_, err := server.Exec("CREATE TABLE accounts (id INT PRIMARY KEY, name STRING UNIQUE, balance INT)")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = server.Exec("INSERT INTO accounts VALUES (1, 'alice', 100), (2, 'bob', 50), (3, 'carol', 0), (4, 'dave', 0)")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	n, err := server.UpdateWhere("accounts", Lt("balance", 60), map[string]interface{}{"balance": 0})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 值没有变化的不算在内
+	if n != 1 {
+		t.Fatalf("expected %v got %v", 1, n)
+	}
+
+	// 违反唯一约束时已经更新的record被恢复
+	_, err = server.UpdateWhere("accounts", Eq("balance", 0), map[string]interface{}{"name": "zoe"})
+	if !errors.Is(err, ErrDuplicateKey) {
+		t.Fatalf("expected %v got %v", ErrDuplicateKey, err)
+	}
+	r, err := server.SelectByID("accounts", 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.Value[1] != "bob" {
+		t.Fatalf("expected %v got %v", "bob", r.Value[1])
+	}
+	err = server.Insert("accounts", []interface{}{5, "zoe", 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 被RESTRICT引用时不删除任何数据
+	server.CreateTable("transfers", []*FieldMeta{{name: "account", tp: INT}})
+	err = server.AddForeignKey("transfers", "fk_account", "account", "accounts", RESTRICT)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = server.Insert("transfers", []interface{}{4})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = server.DeleteWhere("accounts", Eq("balance", 0))
+	if !errors.Is(err, ErrForeignKeyViolation) {
+		t.Fatalf("expected %v got %v", ErrForeignKeyViolation, err)
+	}
+	count, _ := server.Aggregate("accounts", nil, nil, []Agg{{Func: COUNT}})
+	if count[0].Values[0] != 5 {
+		t.Fatalf("expected %v got %v", 5, count[0].Values[0])
+	}
+
+	n, err = server.DeleteWhere("accounts", And(Eq("balance", 0), Ne("id", 4)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 2 {
+		t.Fatalf("expected %v got %v", 2, n)
+	}
+	n, err = server.DeleteWhere("accounts", Eq("balance", 0))
+	if err == nil || n != 0 {
+		t.Fatalf("expected %v got %v", ErrForeignKeyViolation, err)
+	}
+
+	// 自引用外键级联删除的record不算在内
+	server.CreateTable("employees", []*FieldMeta{{name: "manager_id", tp: INT}})
+	err = server.AddForeignKey("employees", "fk_manager", "manager_id", "employees", CASCADE)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server.Insert("employees", []interface{}{nil})
+	server.Insert("employees", []interface{}{1})
+	server.Insert("employees", []interface{}{2})
+	n, err = server.DeleteWhere("employees", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 1 {
+		t.Fatalf("expected %v got %v", 1, n)
+	}
+}
+
+func TestUpdateDeleteWhereTx(t *testing.T) {
+	server := NewIDBServer()
+	inspector := NewUndoInspector()
+	server.WithOptions(func(option *ServerOptionConfig) {
+		option.inspector = inspector
+	})
+	err := createPeopleTable(server)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tm := NewTxMgr(server, inspector)
+
+	tx := tm.StartTransaction()
+	n, err := server.UpdateWhereTx(tx, "people", Eq("city", "beijing"), map[string]interface{}{"city": "guangzhou"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 2 {
+		t.Fatalf("expected %v got %v", 2, n)
+	}
+	// 事务外看不到修改
+	_, err = server.SelectWhere("people", Eq("city", "guangzhou"))
+	if err != ErrValueNotFound {
+		t.Fatalf("expected %v got %v", ErrValueNotFound, err)
+	}
+	n, err = server.DeleteWhereTx(tx, "people", Eq("city", "guangzhou"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 2 {
+		t.Fatalf("expected %v got %v", 2, n)
+	}
+	n, err = server.UpdateWhereTx(tx, "people", Eq("city", "guangzhou"), map[string]interface{}{"age": 1})
+	if err != nil || n != 0 {
+		t.Fatalf("expected %v got %v %v", 0, n, err)
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = server.SelectWhere("people", Or(Eq("city", "beijing"), Eq("city", "guangzhou")))
+	if err != ErrValueNotFound {
+		t.Fatalf("expected %v got %v", ErrValueNotFound, err)
+	}
+}
@@ -0,0 +1,158 @@
+package IDB
+
+import (
+	"sort"
+	"sync/atomic"
+)
+
+// TableDesc 表的描述信息
+type TableDesc struct {
+	Name     string
+	Fields   []*FieldMeta
+	IDCount  int64
+	RowCount int
+}
+
+// DropTable 删除表，并清理事务管理器中该表的undoLog。被其他表外键引用时不能删除
+func (s *idbServer) DropTable(tableName string) error {
+	_, unlock, err := s.lockCatalog(tableName)
+	if err != nil {
+		return err
+	}
+	if s.referencedByOthers(tableName) {
+		unlock()
+		return ErrTableReferenced
+	}
+	delete(s.DB.tables, tableName)
+	unlock()
+
+	s.withTxMgr(func(tm *TxMgrImpl) {
+		tm.dropUndoLog(tableName)
+	})
+	return nil
+}
+
+// TruncateTable 清空表数据，保留表结构。主键计数重新开始。被其他表外键引用时不能清空
+func (s *idbServer) TruncateTable(tableName string) error {
+	t, unlock, err := s.lockCatalog(tableName)
+	if err != nil {
+		return err
+	}
+	if s.referencedByOthers(tableName) {
+		unlock()
+		return ErrTableReferenced
+	}
+	// 换一张新表，正在读旧表的goroutine不受影响
+	s.DB.tables[tableName] = newTable(t.meta.cloneSchema(), s.createDataTree(tableName))
+	unlock()
+
+	s.withTxMgr(func(tm *TxMgrImpl) {
+		tm.dropUndoLog(tableName)
+	})
+	return nil
+}
+
+// RenameTable 重命名表。新表名已存在时报错
+func (s *idbServer) RenameTable(oldName, newName string) error {
+	t, unlock, err := s.lockCatalog(oldName)
+	if err != nil {
+		return err
+	}
+	if _, ok := s.DB.tables[newName]; ok {
+		unlock()
+		return ErrTableExists
+	}
+	delete(s.DB.tables, oldName)
+	s.DB.tables[newName] = t
+	t.data.WithInspector(s.tableInspector(newName))
+	// 引用该表的外键指向新表名
+	for _, ot := range s.DB.tables {
+		ot.meta.renameRefTable(oldName, newName)
+	}
+	unlock()
+
+	s.withTxMgr(func(tm *TxMgrImpl) {
+		tm.renameUndoLog(oldName, newName)
+	})
+	return nil
+}
+
+// lockCatalog 等待表上正在进行的写入以及事务提交完成后持有DB.mu，提交不会写入已经被替换的表。
+// 先加表的写锁再加DB.mu，与写入时的加锁顺序一致
+func (s *idbServer) lockCatalog(tableName string) (*table, func(), error) {
+	for {
+		t, err := s.getTable(tableName)
+		if err != nil {
+			return nil, nil, err
+		}
+		t.writeMu.Lock()
+		s.DB.mu.Lock()
+		if s.DB.tables[tableName] == t {
+			return t, func() {
+				s.DB.mu.Unlock()
+				t.writeMu.Unlock()
+			}, nil
+		}
+		// 加锁期间表被替换了，重新获取
+		s.DB.mu.Unlock()
+		t.writeMu.Unlock()
+	}
+}
+
+// ListTables 按名称顺序列出所有表
+func (s *idbServer) ListTables() []string {
+	s.DB.mu.RLock()
+	defer s.DB.mu.RUnlock()
+
+	names := make([]string, 0, len(s.DB.tables))
+	for name := range s.DB.tables {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// DescribeTable 返回表结构、主键计数以及行数
+func (s *idbServer) DescribeTable(tableName string) (*TableDesc, error) {
+	t, err := s.getTable(tableName)
+	if err != nil {
+		return nil, err
+	}
+
+	// 复制一份字段，避免调用方改动表结构
+	metaFields := t.meta.getFields()
+	fields := make([]*FieldMeta, len(metaFields))
+	for i, f := range metaFields {
+		fc := *f
+		fields[i] = &fc
+	}
+
+	return &TableDesc{
+		Name:     tableName,
+		Fields:   fields,
+		IDCount:  atomic.LoadInt64(&(t.meta.idCount)),
+		RowCount: t.data.Count(),
+	}, nil
+}
+
+// registerTxMgr 还没有事务管理器时使用tm，返回server的事务管理器
+func (s *idbServer) registerTxMgr(tm *TxMgrImpl) *TxMgrImpl {
+	s.txMgrMu.Lock()
+	defer s.txMgrMu.Unlock()
+
+	if s.txMgr == nil {
+		s.txMgr = tm
+	}
+	return s.txMgr
+}
+
+// withTxMgr 已经创建了事务管理器时调用fn
+func (s *idbServer) withTxMgr(fn func(tm *TxMgrImpl)) {
+	s.txMgrMu.Lock()
+	tm := s.txMgr
+	s.txMgrMu.Unlock()
+
+	if tm != nil {
+		fn(tm)
+	}
+}
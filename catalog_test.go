@@ -0,0 +1,281 @@
+package IDB
+
+import (
+	"reflect"
+	"sync"
+	"testing"
+)
+
+func TestCreateTableTwice(t *testing.T) {
+	server := NewIDBServer()
+	fms := []*FieldMeta{
+		{
+			name:         "name",
+			isPrimaryKey: false,
+			tp:           STRING,
+		},
+	}
+
+	tableName := "test"
+	err := server.CreateTable(tableName, fms)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = server.CreateTable(tableName, fms)
+	if err != ErrTableExists {
+		t.Fatalf("expected %v got %v", ErrTableExists, err)
+	}
+}
+
+func TestCatalogBasicPath(t *testing.T) {
+	server := NewIDBServer()
+	fms := []*FieldMeta{
+		{
+			name:         "name",
+			isPrimaryKey: false,
+			tp:           STRING,
+		},
+	}
+
+	server.CreateTable("b", fms)
+	server.CreateTable("a", fms)
+	if !reflect.DeepEqual(server.ListTables(), []string{"a", "b"}) {
+		t.Fatalf("unexpected %v", server.ListTables())
+	}
+
+	for i := 0; i < 10; i++ {
+		err := server.Insert("a", []interface{}{"hello"})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	err := server.DeleteByID("a", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	desc, err := server.DescribeTable("a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if desc.IDCount != 10 || desc.RowCount != 9 || len(desc.Fields) != 1 || desc.Fields[0].name != "name" {
+		t.Fatalf("unexpected %+v", desc)
+	}
+
+	// 重命名到已存在的表报错
+	err = server.RenameTable("a", "b")
+	if err != ErrTableExists {
+		t.Fatalf("expected %v got %v", ErrTableExists, err)
+	}
+	err = server.RenameTable("a", "c")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = server.SelectByID("a", 2)
+	if err != ErrTableNotExist {
+		t.Fatalf("expected %v got %v", ErrTableNotExist, err)
+	}
+	record, err := server.SelectByID("c", 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(record.Value) != 1 || record.Value[0] != "hello" {
+		t.Fatalf("unexpected %v", record)
+	}
+
+	// 清空表之后，数据没了但表结构还在
+	err = server.TruncateTable("c")
+	if err != nil {
+		t.Fatal(err)
+	}
+	desc, err = server.DescribeTable("c")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if desc.IDCount != 0 || desc.RowCount != 0 || len(desc.Fields) != 1 {
+		t.Fatalf("unexpected %+v", desc)
+	}
+	err = server.Insert("c", []interface{}{"world"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	record, err = server.SelectByID("c", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if record.Value[0] != "world" {
+		t.Fatalf("unexpected %v", record)
+	}
+
+	err = server.DropTable("c")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = server.DropTable("c")
+	if err != ErrTableNotExist {
+		t.Fatalf("expected %v got %v", ErrTableNotExist, err)
+	}
+	if !reflect.DeepEqual(server.ListTables(), []string{"b"}) {
+		t.Fatalf("unexpected %v", server.ListTables())
+	}
+}
+
+func TestDropTableCleanUndoLog(t *testing.T) {
+	server := NewIDBServer()
+	inspector := NewUndoInspector()
+	server.WithOptions(func(option *ServerOptionConfig) {
+		option.inspector = inspector
+	})
+	fms := []*FieldMeta{
+		{
+			name:         "name",
+			isPrimaryKey: false,
+			tp:           STRING,
+		},
+	}
+	tableName := "test"
+	server.CreateTable(tableName, fms)
+	err := server.Insert(tableName, []interface{}{"hello"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tm := NewTxMgr(server, inspector).(*TxMgrImpl)
+	// server只有一个事务管理器，之后创建的都是同一个
+	if NewTxMgr(server, nil) != tm || server.TxMgr() != tm {
+		t.Fatal("expected same tx mgr")
+	}
+	tx1 := tm.StartTransaction()
+	tx2 := tm.StartTransaction()
+	err = server.UpdateByIDTx(tx2, tableName, map[string]interface{}{"name": "world"}, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = tx2.Commit()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tm.undoLogs[tableName] == nil {
+		t.Fatal("tx1仍活跃，应该存在undoLog")
+	}
+
+	err = server.DropTable(tableName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := tm.undoLogs[tableName]; ok {
+		t.Fatal("删除表之后undoLog应该被清理")
+	}
+	tx1.Rollback()
+}
+
+func TestCommitAfterTableChanged(t *testing.T) {
+	server := NewIDBServer()
+	fms := []*FieldMeta{
+		{
+			name:         "name",
+			isPrimaryKey: false,
+			tp:           STRING,
+		},
+	}
+	tableName := "test"
+	server.CreateTable(tableName, fms)
+	tm := server.TxMgr()
+
+	// 清空表之后提交，不能写入被替换的旧表
+	tx := tm.StartTransaction()
+	err := server.InsertTx(tx, tableName, []interface{}{"hello"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = server.TruncateTable(tableName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = tx.Commit()
+	if err != ErrTableChanged {
+		t.Fatalf("expected %v got %v", ErrTableChanged, err)
+	}
+	desc, err := server.DescribeTable(tableName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if desc.RowCount != 0 {
+		t.Fatalf("expected %v got %v", 0, desc.RowCount)
+	}
+
+	// 重命名之后原表名已不存在
+	tx = tm.StartTransaction()
+	err = server.InsertTx(tx, tableName, []interface{}{"hello"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = server.RenameTable(tableName, "renamed")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = tx.Commit()
+	if err != ErrTableChanged {
+		t.Fatalf("expected %v got %v", ErrTableChanged, err)
+	}
+
+	// 删除表之后提交
+	tx = tm.StartTransaction()
+	err = server.InsertTx(tx, "renamed", []interface{}{"hello"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = server.DropTable("renamed")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = tx.Commit()
+	if err != ErrTableChanged {
+		t.Fatalf("expected %v got %v", ErrTableChanged, err)
+	}
+}
+
+func TestConcurrentDDLAndDML(t *testing.T) {
+	server := NewIDBServer()
+	fms := []*FieldMeta{
+		{
+			name:         "name",
+			isPrimaryKey: false,
+			tp:           STRING,
+		},
+	}
+	tableName := "test"
+	server.CreateTable(tableName, fms)
+
+	count := 1000
+	wg := &sync.WaitGroup{}
+	wg.Add(count * 2)
+	for i := 0; i < count; i++ {
+		go func() {
+			defer wg.Done()
+			err := server.Insert(tableName, []interface{}{"hello"})
+			if err != nil && err != ErrTableNotExist {
+				t.Error(err)
+			}
+			_, err = server.SelectByID(tableName, 1)
+			if err != nil && err != ErrTableNotExist && err != ErrKeyNotFound {
+				t.Error(err)
+			}
+		}()
+		i := i
+		go func() {
+			defer wg.Done()
+			switch i % 4 {
+			case 0:
+				server.TruncateTable(tableName)
+			case 1:
+				server.DropTable(tableName)
+			case 2:
+				server.CreateTable(tableName, fms)
+			default:
+				server.ListTables()
+			}
+		}()
+	}
+	wg.Wait()
+}
@@ -0,0 +1,167 @@
+package IDB
+
+import (
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+)
+
+var (
+	ErrGeneratedColumn = errors.New("storage: generated column can not be written")
+)
+
+// DefaultFunc 插入时未指定字段值，调用该方法获取默认值
+type DefaultFunc func() interface{}
+
+// GeneratedFunc 写入时根据其他字段计算生成列的值。row中空值为nil，INT为int，STRING为string
+type GeneratedFunc func(row map[string]interface{}) interface{}
+
+var (
+	// DefaultNow 当前时间，用于STRING字段
+	DefaultNow DefaultFunc = func() interface{} {
+		return time.Now().Format(time.RFC3339Nano)
+	}
+	// DefaultUnixTime 当前时间戳，用于INT字段
+	DefaultUnixTime DefaultFunc = func() interface{} {
+		return int(time.Now().Unix())
+	}
+	// DefaultUUID 随机uuid，用于STRING字段
+	DefaultUUID DefaultFunc = func() interface{} {
+		b := make([]byte, 16)
+		rand.Read(b)
+		b[6] = (b[6] & 0x0f) | 0x40
+		b[8] = (b[8] & 0x3f) | 0x80
+		return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:])
+	}
+)
+
+// checkColumns 检查字段默认值以及生成列定义
+func checkColumns(fields []*FieldMeta) error {
+	for _, f := range fields {
+		if err := checkStoredValue(f, f.defaultValue); err != nil {
+			return err
+		}
+		if f.generated != nil && (f.isPrimaryKey || f.defaultFunc != nil || f.defaultValue != "") {
+			return ErrGeneratedColumn
+		}
+	}
+	return nil
+}
+
+// defaultOf 字段的默认值。优先使用defaultFunc
+func defaultOf(f *FieldMeta) (string, error) {
+	if f.defaultFunc != nil {
+		return convertValueToString(f.tp, f.defaultFunc())
+	}
+	return f.defaultValue, nil
+}
+
+// typedValue 将存储的string转换为字段类型的值，空值为nil
+func typedValue(f *FieldMeta, v string) interface{} {
+	if v == "" {
+		return nil
+	}
+	if f.tp == INT {
+		if i, err := strconv.Atoi(v); err == nil {
+			return i
+		}
+	}
+	return v
+}
+
+// rowOf 将record按字段名转换为typed map
+func rowOf(fields []*FieldMeta, r *Record) map[string]interface{} {
+	row := make(map[string]interface{}, len(fields))
+	for _, f := range fields {
+		row[f.name] = typedValue(f, fieldValue(f, r))
+	}
+	return row
+}
+
+// computeGenerated 计算生成列写入r，返回生成列的值
+func (t *table) computeGenerated(r *Record) (map[int]string, error) {
+	fields := t.meta.getFields()
+	var gen map[int]string
+	var row map[string]interface{}
+	for _, f := range fields {
+		if f.generated == nil {
+			continue
+		}
+		// 后面的生成列可以使用前面生成列的值
+		if row == nil {
+			row = rowOf(fields, r)
+		}
+		v, err := convertValueToString(f.tp, f.generated(row))
+		if err != nil {
+			return nil, err
+		}
+		r.Value = setSlot(r.Value, f.pos, v)
+		row[f.name] = typedValue(f, v)
+		if gen == nil {
+			gen = make(map[int]string)
+		}
+		gen[f.pos] = v
+	}
+	return gen, nil
+}
+
+// prepareRecord 写入前计算生成列并检查CHECK约束，r会被修改
+func (t *table) prepareRecord(r *Record) (map[int]string, error) {
+	gen, err := t.computeGenerated(r)
+	if err != nil {
+		return nil, err
+	}
+	return gen, t.checkRow(r)
+}
+
+// convMapToStorageData 按字段名转换数据，未指定的字段使用默认值
+func convMapToStorageData(fields []*FieldMeta, values map[string]interface{}) ([]string, error) {
+	for name := range values {
+		if _, err := findField(fields, name); err != nil {
+			return nil, err
+		}
+	}
+
+	innerData := make([]string, recordWidth(fields))
+	for _, f := range fields {
+		d, ok := values[f.name]
+		if f.generated != nil {
+			if ok && d != nil {
+				return nil, ErrGeneratedColumn
+			}
+			continue
+		}
+
+		var v string
+		var err error
+		if ok {
+			v, err = convertValueToString(f.tp, d)
+		} else {
+			v, err = defaultOf(f)
+		}
+		if err != nil {
+			return nil, err
+		}
+		if v == "" && f.isRequired() {
+			return nil, ErrFieldRequired
+		}
+		innerData[f.pos] = v
+	}
+	return innerData, nil
+}
+
+// InsertMap 按字段名插入数据，未指定的字段使用默认值，生成列自动计算
+func (s *idbServer) InsertMap(tableName string, values map[string]interface{}) error {
+	return s.insert(tableName, func(fields []*FieldMeta) ([]string, error) {
+		return convMapToStorageData(fields, values)
+	})
+}
+
+// InsertMapTx 事务中按字段名插入数据
+func (s *idbServer) InsertMapTx(tx *Tx, tableName string, values map[string]interface{}) error {
+	return s.insertTx(tx, tableName, func(fields []*FieldMeta) ([]string, error) {
+		return convMapToStorageData(fields, values)
+	})
+}
@@ -0,0 +1,216 @@
+package IDB
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestInsertMapWithDefault(t *testing.T) {
+	server := NewIDBServer()
+	fms := []*FieldMeta{
+		{
+			name:        "uuid",
+			tp:          STRING,
+			defaultFunc: DefaultUUID,
+		},
+		{
+			name:     "name",
+			tp:       STRING,
+			required: true,
+		},
+		{
+			name:         "status",
+			tp:           STRING,
+			defaultValue: "active",
+		},
+		{
+			name:        "created_at",
+			tp:          INT,
+			defaultFunc: DefaultUnixTime,
+		},
+	}
+	tableName := "users"
+	err := server.CreateTable(tableName, fms)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = server.InsertMap(tableName, map[string]interface{}{"name": "hello"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 显式指定空值不使用默认值
+	err = server.InsertMap(tableName, map[string]interface{}{"name": "world", "status": nil})
+	if err != nil {
+		t.Fatal(err)
+	}
+	r1, err := server.SelectByID(tableName, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r2, err := server.SelectByID(tableName, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(r1.Value[0]) != 36 || r1.Value[0] == r2.Value[0] {
+		t.Fatalf("unexpected uuid %v %v", r1.Value[0], r2.Value[0])
+	}
+	if r1.Value[1] != "hello" || r1.Value[2] != "active" || r1.Value[3] == "" {
+		t.Fatalf("unexpected %v", r1.Value)
+	}
+	if r2.Value[2] != "" {
+		t.Fatalf("unexpected %v", r2.Value)
+	}
+
+	err = server.InsertMap(tableName, map[string]interface{}{"status": "active"})
+	if err != ErrFieldRequired {
+		t.Fatalf("expected %v got %v", ErrFieldRequired, err)
+	}
+	err = server.InsertMap(tableName, map[string]interface{}{"name": "hello", "age": 1})
+	if err != ErrFieldNotExist {
+		t.Fatalf("expected %v got %v", ErrFieldNotExist, err)
+	}
+
+	// 默认值类型需要与字段一致
+	err = server.CreateTable("bad", []*FieldMeta{
+		{name: "age", tp: INT, defaultValue: "unknown"},
+	})
+	if err != ErrMismatchFieldType {
+		t.Fatalf("expected %v got %v", ErrMismatchFieldType, err)
+	}
+}
+
+func TestGeneratedColumnAndCheck(t *testing.T) {
+	server := NewIDBServer()
+	inspector := NewUndoInspector()
+	server.WithOptions(func(option *ServerOptionConfig) {
+		option.inspector = inspector
+	})
+	fms := []*FieldMeta{
+		{
+			name: "price",
+			tp:   INT,
+		},
+		{
+			name: "qty",
+			tp:   INT,
+		},
+		{
+			name: "total",
+			tp:   INT,
+			generated: func(row map[string]interface{}) interface{} {
+				price, _ := row["price"].(int)
+				qty, _ := row["qty"].(int)
+				return price * qty
+			},
+		},
+	}
+	tableName := "order_lines"
+	server.CreateTable(tableName, fms)
+	err := server.AddCheck(tableName, "ck_qty", []string{"qty"}, func(row map[string]interface{}) bool {
+		qty, ok := row["qty"].(int)
+		return !ok || qty > 0
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = server.Insert(tableName, []interface{}{10, 2, nil})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = server.Insert(tableName, []interface{}{10, 2, 30})
+	if err != ErrGeneratedColumn {
+		t.Fatalf("expected %v got %v", ErrGeneratedColumn, err)
+	}
+	err = server.InsertMap(tableName, map[string]interface{}{"price": 5, "qty": 0})
+	var ce *ConstraintError
+	if !errors.As(err, &ce) || !errors.Is(err, ErrCheckViolation) {
+		t.Fatalf("expected %v got %v", ErrCheckViolation, err)
+	}
+	if ce.Table != tableName || ce.Constraint != "ck_qty" {
+		t.Fatalf("unexpected %+v", ce)
+	}
+
+	// 更新时重新计算生成列
+	err = server.UpdateByID(tableName, map[string]interface{}{"qty": 3}, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	record, err := server.SelectByID(tableName, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if record.Value[2] != "30" {
+		t.Fatalf("unexpected %v", record.Value)
+	}
+	err = server.UpdateByID(tableName, map[string]interface{}{"total": 1}, 1)
+	if err != ErrGeneratedColumn {
+		t.Fatalf("expected %v got %v", ErrGeneratedColumn, err)
+	}
+	err = server.UpdateByID(tableName, map[string]interface{}{"qty": -1}, 1)
+	if !errors.Is(err, ErrCheckViolation) {
+		t.Fatalf("expected %v got %v", ErrCheckViolation, err)
+	}
+
+	// 事务中同样计算生成列并检查约束
+	tm := NewTxMgr(server, inspector)
+	tx := tm.StartTransaction()
+	err = server.InsertMapTx(tx, tableName, map[string]interface{}{"price": 7, "qty": 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = server.UpdateByIDTx(tx, tableName, map[string]interface{}{"qty": 2}, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = server.UpdateByIDTx(tx, tableName, map[string]interface{}{"price": 20}, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = server.UpdateByIDTx(tx, tableName, map[string]interface{}{"qty": 0}, 1)
+	if !errors.Is(err, ErrCheckViolation) {
+		t.Fatalf("expected %v got %v", ErrCheckViolation, err)
+	}
+	record, err = server.SelectByIDTx(tx, tableName, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if record.Value[2] != "14" {
+		t.Fatalf("unexpected %v", record.Value)
+	}
+	record, err = server.SelectByIDTx(tx, tableName, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if record.Value[2] != "60" {
+		t.Fatalf("unexpected %v", record.Value)
+	}
+	err = tx.Commit()
+	if err != nil {
+		t.Fatal(err)
+	}
+	record, err = server.SelectByID(tableName, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if record.Value[2] != "60" {
+		t.Fatalf("unexpected %v", record.Value)
+	}
+
+	// 已有数据违反约束时无法添加
+	err = server.AddCheck(tableName, "ck_total", []string{"total"}, func(row map[string]interface{}) bool {
+		return row["total"].(int) < 50
+	})
+	if !errors.Is(err, ErrCheckViolation) {
+		t.Fatalf("expected %v got %v", ErrCheckViolation, err)
+	}
+	err = server.DropCheck(tableName, "ck_qty")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = server.UpdateByID(tableName, map[string]interface{}{"qty": 0}, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
@@ -14,3 +14,12 @@ func copyMap(m map[int]bool) map[int]bool {
 	}
 	return r
 }
+
+// setSlot 设置values对应位置的值，长度不够就扩充
+func setSlot(values []string, slot int, v string) []string {
+	for len(values) <= slot {
+		values = append(values, "")
+	}
+	values[slot] = v
+	return values
+}
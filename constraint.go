@@ -0,0 +1,209 @@
+package IDB
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+)
+
+var (
+	ErrConstraintViolation = errors.New("storage: constraint violation")
+	ErrEmptyConstraint     = errors.New("storage: constraint has no field")
+	ErrCheckViolation      = errors.New("storage: check constraint violation")
+	ErrCheckExists         = errors.New("storage: check constraint exists")
+	ErrCheckNotExist       = errors.New("storage: check constraint not exist")
+)
+
+const primaryKeyConstraint = "PRIMARY"
+
+// ConstraintError 违反约束时返回，errors.Is可以同时匹配ErrConstraintViolation以及具体的错误
+type ConstraintError struct {
+	Table      string
+	Constraint string
+	// 冲突的值，多个字段以逗号分隔
+	Key string
+	Err error
+}
+
+func (e *ConstraintError) Error() string {
+	return fmt.Sprintf("%v: table %s constraint %s key (%s)", e.Err, e.Table, e.Constraint, e.Key)
+}
+
+func (e *ConstraintError) Unwrap() error {
+	return e.Err
+}
+
+func (e *ConstraintError) Is(target error) bool {
+	return target == ErrConstraintViolation
+}
+
+// CheckFunc CHECK约束。row中空值为nil，INT为int，STRING为string，返回false表示违反约束
+type CheckFunc func(row map[string]interface{}) bool
+
+type check struct {
+	name string
+	// 约束使用的字段，删除其中的字段时约束一起删除
+	fields []string
+	fn     CheckFunc
+}
+
+// uses 约束是否使用该字段
+func (c *check) uses(fieldName string) bool {
+	for _, name := range c.fields {
+		if name == fieldName {
+			return true
+		}
+	}
+	return false
+}
+
+// getChecks 获取表的CHECK约束
+func (m *tableMeta) getChecks() []*check {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.checks
+}
+
+// checkRow 检查record是否满足所有CHECK约束
+func (t *table) checkRow(r *Record) error {
+	checks := t.meta.getChecks()
+	if len(checks) == 0 {
+		return nil
+	}
+	row := rowOf(t.meta.getFields(), r)
+	for _, c := range checks {
+		if !c.fn(row) {
+			return &ConstraintError{
+				Constraint: c.name,
+				Key:        strconv.Itoa(r.Key),
+				Err:        ErrCheckViolation,
+			}
+		}
+	}
+	return nil
+}
+
+// AddCheck 添加CHECK约束，在插入以及更新时检查。fields为fn使用的字段，已有数据需要满足约束
+func (s *idbServer) AddCheck(tableName string, name string, fields []string, fn CheckFunc) error {
+	t, err := s.getTable(tableName)
+	if err != nil {
+		return err
+	}
+	if len(fields) == 0 {
+		return ErrEmptyConstraint
+	}
+
+	t.writeMu.Lock()
+	defer t.writeMu.Unlock()
+
+	for _, c := range t.meta.getChecks() {
+		if c.name == name {
+			return ErrCheckExists
+		}
+	}
+	fms := t.meta.getFields()
+	for _, f := range fields {
+		if _, err = findField(fms, f); err != nil {
+			return err
+		}
+	}
+	nc := &check{name: name, fields: append([]string(nil), fields...), fn: fn}
+	t.data.scanLeaves(func(r *Record) bool {
+		if !fn(rowOf(fms, r)) {
+			err = &ConstraintError{
+				Table:      tableName,
+				Constraint: name,
+				Key:        strconv.Itoa(r.Key),
+				Err:        ErrCheckViolation,
+			}
+			return false
+		}
+		return true
+	})
+	if err != nil {
+		return err
+	}
+
+	t.meta.mu.Lock()
+	defer t.meta.mu.Unlock()
+	checks := make([]*check, len(t.meta.checks), len(t.meta.checks)+1)
+	copy(checks, t.meta.checks)
+	t.meta.checks = append(checks, nc)
+	return nil
+}
+
+// DropCheck 删除CHECK约束
+func (s *idbServer) DropCheck(tableName string, name string) error {
+	t, err := s.getTable(tableName)
+	if err != nil {
+		return err
+	}
+
+	t.writeMu.Lock()
+	defer t.writeMu.Unlock()
+	t.meta.mu.Lock()
+	defer t.meta.mu.Unlock()
+
+	checks := make([]*check, 0, len(t.meta.checks))
+	for _, c := range t.meta.checks {
+		if c.name != name {
+			checks = append(checks, c)
+		}
+	}
+	if len(checks) == len(t.meta.checks) {
+		return ErrCheckNotExist
+	}
+	t.meta.checks = checks
+	return nil
+}
+
+// uniqueError 唯一索引冲突
+func uniqueError(idx *index, r *Record) error {
+	return &ConstraintError{
+		Constraint: idx.name,
+		Key:        idx.displayKey(r),
+		Err:        ErrDuplicateKey,
+	}
+}
+
+// pkError 主键冲突
+func (t *table) pkError(r *Record) error {
+	e := &ConstraintError{
+		Constraint: primaryKeyConstraint,
+		Err:        ErrDuplicatePrimaryKey,
+	}
+	if pk := t.meta.primaryKey(); pk != nil {
+		e.Key = fieldValue(pk, r)
+	}
+	return e
+}
+
+// withTableName 表内部不知道表名，在入口处补上
+func withTableName(err error, tableName string) error {
+	var ce *ConstraintError
+	if errors.As(err, &ce) && ce.Table == "" {
+		ce.Table = tableName
+	}
+	var we *WriteConflictError
+	if errors.As(err, &we) && we.Table == "" {
+		we.Table = tableName
+	}
+	return err
+}
+
+// AddUniqueConstraint 在一个或多个字段上添加唯一约束。任一字段为空的record不参与检查
+func (s *idbServer) AddUniqueConstraint(tableName string, name string, fieldNames ...string) error {
+	t, err := s.getTable(tableName)
+	if err != nil {
+		return err
+	}
+	if len(fieldNames) == 0 {
+		return ErrEmptyConstraint
+	}
+	return withTableName(t.addIndex(name, fieldNames, true), tableName)
+}
+
+// DropConstraint 删除唯一约束
+func (s *idbServer) DropConstraint(tableName string, name string) error {
+	return s.DropIndex(tableName, name)
+}
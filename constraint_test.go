@@ -0,0 +1,165 @@
+package IDB
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestUniqueConstraint(t *testing.T) {
+	server := NewIDBServer()
+	fms := []*FieldMeta{
+		{
+			name:   "email",
+			tp:     STRING,
+			unique: true,
+		},
+		{
+			name: "first_name",
+			tp:   STRING,
+		},
+		{
+			name: "last_name",
+			tp:   STRING,
+		},
+	}
+	tableName := "users"
+	server.CreateTable(tableName, fms)
+
+	err := server.Insert(tableName, []interface{}{"a@x.com", "san", "zhang"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = server.Insert(tableName, []interface{}{"a@x.com", "si", "li"})
+	var ce *ConstraintError
+	if !errors.As(err, &ce) {
+		t.Fatalf("expected ConstraintError got %v", err)
+	}
+	if ce.Table != tableName || ce.Constraint != "email" || ce.Key != "a@x.com" {
+		t.Fatalf("unexpected %+v", ce)
+	}
+	if !errors.Is(err, ErrConstraintViolation) || !errors.Is(err, ErrDuplicateKey) {
+		t.Fatalf("unexpected %v", err)
+	}
+
+	// 多字段唯一约束
+	err = server.AddUniqueConstraint(tableName, "uk_name", "first_name", "last_name")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = server.AddUniqueConstraint(tableName, "uk_name", "first_name")
+	if err != ErrIndexExists {
+		t.Fatalf("expected %v got %v", ErrIndexExists, err)
+	}
+	err = server.AddUniqueConstraint(tableName, "uk_none")
+	if err != ErrEmptyConstraint {
+		t.Fatalf("expected %v got %v", ErrEmptyConstraint, err)
+	}
+	err = server.Insert(tableName, []interface{}{"b@x.com", "san", "li"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = server.Insert(tableName, []interface{}{"c@x.com", "san", "zhang"})
+	if !errors.As(err, &ce) {
+		t.Fatalf("expected ConstraintError got %v", err)
+	}
+	if ce.Constraint != "uk_name" || ce.Key != "san, zhang" {
+		t.Fatalf("unexpected %+v", ce)
+	}
+	// 插入失败也会占用自增id，b@x.com的id为3
+	err = server.UpdateByID(tableName, map[string]interface{}{"last_name": "zhang"}, 3)
+	if !errors.Is(err, ErrConstraintViolation) {
+		t.Fatalf("expected %v got %v", ErrConstraintViolation, err)
+	}
+
+	err = server.DropConstraint(tableName, "uk_name")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = server.UpdateByID(tableName, map[string]interface{}{"last_name": "zhang"}, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 已有数据冲突时无法添加约束
+	err = server.AddUniqueConstraint(tableName, "uk_name", "first_name", "last_name")
+	if !errors.Is(err, ErrConstraintViolation) {
+		t.Fatalf("expected %v got %v", ErrConstraintViolation, err)
+	}
+
+	// 新增的唯一字段默认值冲突
+	err = server.AddColumn(tableName, &FieldMeta{name: "phone", tp: STRING, unique: true}, "110")
+	if !errors.Is(err, ErrConstraintViolation) {
+		t.Fatalf("expected %v got %v", ErrConstraintViolation, err)
+	}
+	err = server.AddColumn(tableName, &FieldMeta{name: "phone", tp: STRING, unique: true}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = server.UpdateByID(tableName, map[string]interface{}{"phone": "110"}, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = server.UpdateByID(tableName, map[string]interface{}{"phone": "110"}, 3)
+	if !errors.Is(err, ErrConstraintViolation) {
+		t.Fatalf("expected %v got %v", ErrConstraintViolation, err)
+	}
+}
+
+func TestConstraintViolationRollbackTx(t *testing.T) {
+	server := NewIDBServer()
+	inspector := NewUndoInspector()
+	server.WithOptions(func(option *ServerOptionConfig) {
+		option.inspector = inspector
+	})
+	server.CreateTable("users", []*FieldMeta{
+		{name: "email", tp: STRING, unique: true},
+	})
+	server.CreateTable("logs", []*FieldMeta{
+		{name: "msg", tp: STRING},
+	})
+	err := server.Insert("users", []interface{}{"a@x.com"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tm := NewTxMgr(server, inspector)
+	tx := tm.StartTransaction()
+	err = server.InsertTx(tx, "logs", []interface{}{"register"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = server.DeleteByIDTx(tx, "users", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = server.InsertTx(tx, "users", []interface{}{"b@x.com"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 其他人先插入了相同的值
+	err = server.Insert("users", []interface{}{"b@x.com"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = tx.Commit()
+	var ce *ConstraintError
+	if !errors.As(err, &ce) {
+		t.Fatalf("expected ConstraintError got %v", err)
+	}
+	if ce.Table != "users" || ce.Constraint != "email" || ce.Key != "b@x.com" {
+		t.Fatalf("unexpected %+v", ce)
+	}
+
+	// 整个事务都没有生效
+	_, err = server.SelectByID("logs", 1)
+	if err != ErrKeyNotFound {
+		t.Fatalf("expected %v got %v", ErrKeyNotFound, err)
+	}
+	record, err := server.SelectByID("users", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if record.Value[0] != "a@x.com" {
+		t.Fatalf("unexpected %v", record)
+	}
+}
@@ -0,0 +1,561 @@
+package IDB
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+var (
+	ErrInvalidExpr = errors.New("storage: invalid expression")
+)
+
+// FieldError 查询条件中字段相关的错误，errors.Is可以匹配具体的错误
+type FieldError struct {
+	Field string
+	Err   error
+}
+
+func (e *FieldError) Error() string {
+	return fmt.Sprintf("%v: %s", e.Err, e.Field)
+}
+
+func (e *FieldError) Unwrap() error {
+	return e.Err
+}
+
+// Expr 查询条件，通过Eq、And等方法构造。
+// 比较按字段类型进行，INT按数值比较，STRING按字典序比较。空值与任何值比较的结果都是未知，取反后仍然未知，只能通过IsNull匹配
+type Expr interface {
+	// bind 根据表字段检查条件并生成求值方法
+	bind(fields []*FieldMeta) (evaluator, error)
+	// String 以SQL形式输出条件，用于执行计划
+	String() string
+}
+
+// truth 条件的三值逻辑结果，空值参与比较时为未知
+type truth int
+
+const (
+	truthUnknown truth = iota
+	truthFalse
+	truthTrue
+)
+
+func truthOf(b bool) truth {
+	if b {
+		return truthTrue
+	}
+	return truthFalse
+}
+
+// evaluator 对record求条件的值
+type evaluator func(r *Record) truth
+
+// bindTarget 生成判断方法，条件为真时才满足，为假或者未知都不满足
+func bindTarget(expr Expr, fields []*FieldMeta) (IsTarget, error) {
+	eval, err := expr.bind(fields)
+	if err != nil {
+		return nil, err
+	}
+	return func(r *Record) bool {
+		return eval(r) == truthTrue
+	}, nil
+}
+
+type cmpOp int
+
+const (
+	opEq cmpOp = iota
+	opNe
+	opLt
+	opLe
+	opGt
+	opGe
+)
+
+var cmpOpSymbols = []string{"=", "!=", "<", "<=", ">", ">="}
+
+func (op cmpOp) String() string {
+	return cmpOpSymbols[op]
+}
+
+func (op cmpOp) match(c int) bool {
+	switch op {
+	case opEq:
+		return c == 0
+	case opNe:
+		return c != 0
+	case opLt:
+		return c < 0
+	case opLe:
+		return c <= 0
+	case opGt:
+		return c > 0
+	case opGe:
+		return c >= 0
+	}
+	return false
+}
+
+type cmpExpr struct {
+	op    cmpOp
+	field string
+	value interface{}
+}
+
+// Eq 等于。value为nil时等同于IsNull
+func Eq(field string, value interface{}) Expr {
+	return &cmpExpr{op: opEq, field: field, value: value}
+}
+
+// Ne 不等于。value为nil时匹配非空值
+func Ne(field string, value interface{}) Expr {
+	return &cmpExpr{op: opNe, field: field, value: value}
+}
+
+// Lt 小于
+func Lt(field string, value interface{}) Expr {
+	return &cmpExpr{op: opLt, field: field, value: value}
+}
+
+// Le 小于等于
+func Le(field string, value interface{}) Expr {
+	return &cmpExpr{op: opLe, field: field, value: value}
+}
+
+// Gt 大于
+func Gt(field string, value interface{}) Expr {
+	return &cmpExpr{op: opGt, field: field, value: value}
+}
+
+// Ge 大于等于
+func Ge(field string, value interface{}) Expr {
+	return &cmpExpr{op: opGe, field: field, value: value}
+}
+
+func (e *cmpExpr) bind(fields []*FieldMeta) (evaluator, error) {
+	f, err := exprField(fields, e.field)
+	if err != nil {
+		return nil, err
+	}
+	if e.value == nil {
+		switch e.op {
+		case opEq:
+			return nullTarget(f, true), nil
+		case opNe:
+			return nullTarget(f, false), nil
+		}
+		return nil, &FieldError{Field: e.field, Err: ErrInvalidExpr}
+	}
+
+	v, err := exprValue(f, e.value)
+	if err != nil {
+		return nil, err
+	}
+	op := e.op
+	return func(r *Record) truth {
+		c, ok := compareField(f, r, v)
+		if !ok {
+			return truthUnknown
+		}
+		return truthOf(op.match(c))
+	}, nil
+}
+
+func (e *cmpExpr) String() string {
+	if e.value == nil {
+		switch e.op {
+		case opEq:
+			return e.field + " IS NULL"
+		case opNe:
+			return e.field + " IS NOT NULL"
+		}
+	}
+	return fmt.Sprintf("%s %v %s", e.field, e.op, formatValue(e.value))
+}
+
+type inExpr struct {
+	field  string
+	values []interface{}
+}
+
+// In 等于values中任意一个值，nil会被忽略
+func In(field string, values ...interface{}) Expr {
+	return &inExpr{field: field, values: values}
+}
+
+func (e *inExpr) bind(fields []*FieldMeta) (evaluator, error) {
+	f, err := exprField(fields, e.field)
+	if err != nil {
+		return nil, err
+	}
+	set := make(map[string]bool, len(e.values))
+	for _, value := range e.values {
+		if value == nil {
+			continue
+		}
+		v, err := exprValue(f, value)
+		if err != nil {
+			return nil, err
+		}
+		set[storedValue(v)] = true
+	}
+	return func(r *Record) truth {
+		v := fieldValue(f, r)
+		if v == "" {
+			return truthUnknown
+		}
+		if f.tp == INT {
+			// 统一格式，避免"01"与"1"不相等
+			n, err := strconv.Atoi(v)
+			if err != nil {
+				return truthUnknown
+			}
+			v = strconv.Itoa(n)
+		}
+		return truthOf(set[v])
+	}, nil
+}
+
+func (e *inExpr) String() string {
+	values := make([]string, len(e.values))
+	for i, v := range e.values {
+		values[i] = formatValue(v)
+	}
+	return fmt.Sprintf("%s IN (%s)", e.field, strings.Join(values, ", "))
+}
+
+type betweenExpr struct {
+	field  string
+	lo, hi interface{}
+}
+
+// Between 在[lo, hi]之间
+func Between(field string, lo, hi interface{}) Expr {
+	return &betweenExpr{field: field, lo: lo, hi: hi}
+}
+
+func (e *betweenExpr) bind(fields []*FieldMeta) (evaluator, error) {
+	if e.lo == nil || e.hi == nil {
+		return nil, &FieldError{Field: e.field, Err: ErrInvalidExpr}
+	}
+	return And(Ge(e.field, e.lo), Le(e.field, e.hi)).bind(fields)
+}
+
+func (e *betweenExpr) String() string {
+	return fmt.Sprintf("%s BETWEEN %s AND %s", e.field, formatValue(e.lo), formatValue(e.hi))
+}
+
+type likeExpr struct {
+	field   string
+	pattern string
+}
+
+// Like 匹配STRING字段，%匹配任意个字符，_匹配一个字符
+func Like(field string, pattern string) Expr {
+	return &likeExpr{field: field, pattern: pattern}
+}
+
+// Prefix 匹配以prefix开头的STRING字段
+func Prefix(field string, prefix string) Expr {
+	return &likeExpr{field: field, pattern: escapeLike(prefix) + "%"}
+}
+
+func (e *likeExpr) bind(fields []*FieldMeta) (evaluator, error) {
+	f, err := exprField(fields, e.field)
+	if err != nil {
+		return nil, err
+	}
+	if f.tp != STRING {
+		return nil, &FieldError{Field: e.field, Err: ErrMismatchFieldType}
+	}
+	pattern := []rune(e.pattern)
+	return func(r *Record) truth {
+		v := fieldValue(f, r)
+		if v == "" {
+			return truthUnknown
+		}
+		return truthOf(likeMatch([]rune(v), pattern))
+	}, nil
+}
+
+func (e *likeExpr) String() string {
+	return fmt.Sprintf("%s LIKE %s", e.field, formatValue(e.pattern))
+}
+
+// escapeLike 转义%以及_
+func escapeLike(s string) string {
+	r := strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+	return r.Replace(s)
+}
+
+// likeMatch 匹配LIKE模式，\用于转义
+func likeMatch(s, p []rune) bool {
+	for len(p) > 0 {
+		switch p[0] {
+		case '%':
+			for len(p) > 0 && p[0] == '%' {
+				p = p[1:]
+			}
+			if len(p) == 0 {
+				return true
+			}
+			for i := 0; i <= len(s); i++ {
+				if likeMatch(s[i:], p) {
+					return true
+				}
+			}
+			return false
+		case '_':
+			if len(s) == 0 {
+				return false
+			}
+		default:
+			c := p[0]
+			if c == '\\' && len(p) > 1 {
+				p = p[1:]
+				c = p[0]
+			}
+			if len(s) == 0 || s[0] != c {
+				return false
+			}
+		}
+		s, p = s[1:], p[1:]
+	}
+	return len(s) == 0
+}
+
+type nullExpr struct {
+	field string
+}
+
+// IsNull 字段为空
+func IsNull(field string) Expr {
+	return &nullExpr{field: field}
+}
+
+func (e *nullExpr) bind(fields []*FieldMeta) (evaluator, error) {
+	f, err := exprField(fields, e.field)
+	if err != nil {
+		return nil, err
+	}
+	return nullTarget(f, true), nil
+}
+
+func (e *nullExpr) String() string {
+	return e.field + " IS NULL"
+}
+
+// nullTarget 是否为空的判断结果总是确定的
+func nullTarget(f *FieldMeta, isNull bool) evaluator {
+	return func(r *Record) truth {
+		return truthOf((fieldValue(f, r) == "") == isNull)
+	}
+}
+
+type andExpr struct {
+	exprs []Expr
+}
+
+// And 满足所有条件，没有条件时匹配所有record
+func And(exprs ...Expr) Expr {
+	return &andExpr{exprs: exprs}
+}
+
+// bind 任一条件为假时为假，否则任一条件未知时为未知
+func (e *andExpr) bind(fields []*FieldMeta) (evaluator, error) {
+	evals, err := bindAll(fields, e.exprs)
+	if err != nil {
+		return nil, err
+	}
+	return func(r *Record) truth {
+		result := truthTrue
+		for _, eval := range evals {
+			switch eval(r) {
+			case truthFalse:
+				return truthFalse
+			case truthUnknown:
+				result = truthUnknown
+			}
+		}
+		return result
+	}, nil
+}
+
+func (e *andExpr) String() string {
+	return joinExprs(e.exprs, " AND ", "TRUE")
+}
+
+type orExpr struct {
+	exprs []Expr
+}
+
+// Or 满足任意条件，没有条件时不匹配任何record
+func Or(exprs ...Expr) Expr {
+	return &orExpr{exprs: exprs}
+}
+
+// bind 任一条件为真时为真，否则任一条件未知时为未知
+func (e *orExpr) bind(fields []*FieldMeta) (evaluator, error) {
+	evals, err := bindAll(fields, e.exprs)
+	if err != nil {
+		return nil, err
+	}
+	return func(r *Record) truth {
+		result := truthFalse
+		for _, eval := range evals {
+			switch eval(r) {
+			case truthTrue:
+				return truthTrue
+			case truthUnknown:
+				result = truthUnknown
+			}
+		}
+		return result
+	}, nil
+}
+
+func (e *orExpr) String() string {
+	return joinExprs(e.exprs, " OR ", "FALSE")
+}
+
+type notExpr struct {
+	expr Expr
+}
+
+// Not 不满足条件。条件的结果未知时取反后仍然未知，例如Not(Eq(field, v))不匹配字段为空的record
+func Not(expr Expr) Expr {
+	return &notExpr{expr: expr}
+}
+
+func (e *notExpr) bind(fields []*FieldMeta) (evaluator, error) {
+	if e.expr == nil {
+		return nil, ErrInvalidExpr
+	}
+	eval, err := e.expr.bind(fields)
+	if err != nil {
+		return nil, err
+	}
+	return func(r *Record) truth {
+		switch eval(r) {
+		case truthTrue:
+			return truthFalse
+		case truthFalse:
+			return truthTrue
+		}
+		return truthUnknown
+	}, nil
+}
+
+func (e *notExpr) String() string {
+	return fmt.Sprintf("NOT (%v)", e.expr)
+}
+
+// joinExprs 多个条件时加上括号，没有条件时返回empty
+func joinExprs(exprs []Expr, sep string, empty string) string {
+	switch len(exprs) {
+	case 0:
+		return empty
+	case 1:
+		return fmt.Sprint(exprs[0])
+	}
+	parts := make([]string, len(exprs))
+	for i, e := range exprs {
+		parts[i] = fmt.Sprint(e)
+	}
+	return "(" + strings.Join(parts, sep) + ")"
+}
+
+// formatValue 以SQL字面量形式输出值
+func formatValue(v interface{}) string {
+	switch v := v.(type) {
+	case nil:
+		return "NULL"
+	case string:
+		return "'" + strings.ReplaceAll(v, "'", "''") + "'"
+	}
+	return fmt.Sprint(v)
+}
+
+func bindAll(fields []*FieldMeta, exprs []Expr) ([]evaluator, error) {
+	evals := make([]evaluator, len(exprs))
+	for i, expr := range exprs {
+		if expr == nil {
+			return nil, ErrInvalidExpr
+		}
+		eval, err := expr.bind(fields)
+		if err != nil {
+			return nil, err
+		}
+		evals[i] = eval
+	}
+	return evals, nil
+}
+
+// exprField 找到条件中的字段
+func exprField(fields []*FieldMeta, name string) (*FieldMeta, error) {
+	f, err := findField(fields, name)
+	if err != nil {
+		return nil, &FieldError{Field: name, Err: err}
+	}
+	return f, nil
+}
+
+// exprValue 将条件中的值转换为字段类型，INT为int，STRING为string。INT字段也接受数字字符串
+func exprValue(f *FieldMeta, value interface{}) (interface{}, error) {
+	switch f.tp {
+	case INT:
+		switch v := value.(type) {
+		case int:
+			return v, nil
+		case int32:
+			return int(v), nil
+		case int64:
+			return int(v), nil
+		case string:
+			if n, err := strconv.Atoi(v); err == nil {
+				return n, nil
+			}
+		}
+	case STRING:
+		if v, ok := value.(string); ok {
+			return v, nil
+		}
+	default:
+		return nil, &FieldError{Field: f.name, Err: ErrUnsupportedFieldType}
+	}
+	return nil, &FieldError{Field: f.name, Err: ErrMismatchFieldType}
+}
+
+// storedValue 条件值在record中存储的形式
+func storedValue(v interface{}) string {
+	if n, ok := v.(int); ok {
+		return strconv.Itoa(n)
+	}
+	return v.(string)
+}
+
+// compareField 比较字段值与v，字段为空或无法解析时返回false
+func compareField(f *FieldMeta, r *Record, v interface{}) (int, bool) {
+	s := fieldValue(f, r)
+	if s == "" {
+		return 0, false
+	}
+	if f.tp == INT {
+		n, err := strconv.Atoi(s)
+		if err != nil {
+			return 0, false
+		}
+		m := v.(int)
+		switch {
+		case n < m:
+			return -1, true
+		case n > m:
+			return 1, true
+		}
+		return 0, true
+	}
+	return strings.Compare(s, v.(string)), true
+}
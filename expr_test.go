@@ -0,0 +1,162 @@
+package IDB
+
+import (
+	"errors"
+	"testing"
+)
+
+func createPeopleTable(server *idbServer) error {
+	err := server.CreateTable("people", []*FieldMeta{
+		{name: "name", tp: STRING},
+		{name: "age", tp: INT},
+		{name: "city", tp: STRING},
+	})
+	if err != nil {
+		return err
+	}
+	rows := [][]interface{}{
+		{"alice", 3, "beijing"},
+		{"bob", 25, "shanghai"},
+		{"carol", 9, "beijing"},
+		{"dave", nil, "shenzhen"},
+		{"eve_1", 100, nil},
+	}
+	for _, row := range rows {
+		if err := server.Insert("people", row); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func selectNames(server *idbServer, expr Expr) ([]string, error) {
+	records, err := server.SelectWhere("people", expr)
+	if err == ErrValueNotFound {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	names := make([]string, len(records))
+	for i, r := range records {
+		names[i] = r.Value[0]
+	}
+	return names, nil
+}
+
+func TestSelectWhere(t *testing.T) {
+	server := NewIDBServer()
+	err := createPeopleTable(server)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		expr   Expr
+		expect []string
+	}{
+		{Eq("age", 3), []string{"alice"}},
+		// INT按数值比较，字典序下"25" < "3"
+		{Gt("age", 3), []string{"bob", "carol", "eve_1"}},
+		{Le("age", 9), []string{"alice", "carol"}},
+		{Lt("name", "bob"), []string{"alice"}},
+		{Ge("name", "dave"), []string{"dave", "eve_1"}},
+		{Ne("city", "beijing"), []string{"bob", "dave"}},
+		{In("age", 3, 100, nil), []string{"alice", "eve_1"}},
+		{Between("age", 9, 25), []string{"bob", "carol"}},
+		{Like("name", "%o%"), []string{"bob", "carol"}},
+		{Like("name", "_ve%"), []string{"eve_1"}},
+		{Like("city", "sh%i"), []string{"bob"}},
+		{Prefix("name", "eve_"), []string{"eve_1"}},
+		{Prefix("name", "a_"), nil},
+		{IsNull("age"), []string{"dave"}},
+		{Eq("city", nil), []string{"eve_1"}},
+		{Ne("age", nil), []string{"alice", "bob", "carol", "eve_1"}},
+		{And(Eq("city", "beijing"), Gt("age", 5)), []string{"carol"}},
+		{Or(Eq("name", "bob"), IsNull("city")), []string{"bob", "eve_1"}},
+		// 空值比较的结果未知，取反后仍然未知
+		{Not(Gt("age", 5)), []string{"alice"}},
+		{Not(Eq("city", "beijing")), []string{"bob", "dave"}},
+		{Not(IsNull("age")), []string{"alice", "bob", "carol", "eve_1"}},
+		{Not(Or(Eq("age", 3), IsNull("city"))), []string{"bob", "carol"}},
+		{Or(Gt("age", 50), Eq("name", "dave")), []string{"dave", "eve_1"}},
+		{And(), []string{"alice", "bob", "carol", "dave", "eve_1"}},
+		{Or(), nil},
+		{nil, []string{"alice", "bob", "carol", "dave", "eve_1"}},
+	}
+	for i, test := range tests {
+		names, err := selectNames(server, test.expr)
+		if err != nil {
+			t.Fatalf("%d: %v", i, err)
+		}
+		if len(names) != len(test.expect) {
+			t.Fatalf("%d: expected %v got %v", i, test.expect, names)
+		}
+		for j := range names {
+			if names[j] != test.expect[j] {
+				t.Fatalf("%d: expected %v got %v", i, test.expect, names)
+			}
+		}
+	}
+
+	// 字段不存在以及类型不一致
+	_, err = server.SelectWhere("people", And(Eq("city", "beijing"), Gt("height", 1)))
+	var fe *FieldError
+	if !errors.As(err, &fe) || !errors.Is(err, ErrFieldNotExist) || fe.Field != "height" {
+		t.Fatalf("expected %v got %v", ErrFieldNotExist, err)
+	}
+	_, err = server.SelectWhere("people", Gt("age", "old"))
+	if !errors.Is(err, ErrMismatchFieldType) {
+		t.Fatalf("expected %v got %v", ErrMismatchFieldType, err)
+	}
+	_, err = server.SelectWhere("people", Like("age", "1%"))
+	if !errors.Is(err, ErrMismatchFieldType) {
+		t.Fatalf("expected %v got %v", ErrMismatchFieldType, err)
+	}
+	_, err = server.SelectWhere("people", Lt("age", nil))
+	if !errors.Is(err, ErrInvalidExpr) {
+		t.Fatalf("expected %v got %v", ErrInvalidExpr, err)
+	}
+}
+
+func TestSelectByFieldsTyped(t *testing.T) {
+	server := NewIDBServer()
+	err := createPeopleTable(server)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// INT字段使用int查询
+	records, err := server.SelectByFields("people", map[string]interface{}{"age": 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 1 || records[0].Value[0] != "alice" {
+		t.Fatalf("unexpected %v", records)
+	}
+
+	// 走索引时同样按类型转换
+	err = server.CreateIndex("people", "age", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	records, err = server.SelectByFields("people", map[string]interface{}{"age": 25, "city": "shanghai"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 1 || records[0].Value[0] != "bob" {
+		t.Fatalf("unexpected %v", records)
+	}
+	records, err = server.SelectWhere("people", And(Eq("age", "9"), Or(Eq("city", "beijing"), IsNull("city"))))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 1 || records[0].Value[0] != "carol" {
+		t.Fatalf("unexpected %v", records)
+	}
+
+	_, err = server.SelectByFields("people", map[string]interface{}{"height": 3})
+	if !errors.Is(err, ErrFieldNotExist) {
+		t.Fatalf("expected %v got %v", ErrFieldNotExist, err)
+	}
+}
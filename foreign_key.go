@@ -0,0 +1,598 @@
+package IDB
+
+import (
+	"errors"
+	"sort"
+	"strconv"
+)
+
+var (
+	ErrForeignKeyViolation = errors.New("storage: foreign key violation")
+	ErrForeignKeyExists    = errors.New("storage: foreign key exists")
+	ErrForeignKeyNotExist  = errors.New("storage: foreign key not exist")
+	ErrInvalidForeignKey   = errors.New("storage: invalid foreign key")
+	ErrTableReferenced     = errors.New("storage: table is referenced by foreign key")
+)
+
+type fkAction int
+
+const (
+	RESTRICT fkAction = iota
+	CASCADE
+	SET_NULL
+)
+
+// foreignKey 子表字段引用父表主键。父表没有定义主键时引用自增id
+type foreignKey struct {
+	name     string
+	field    *FieldMeta
+	refTable string
+	onDelete fkAction
+}
+
+// fkRef 引用某张表的外键以及所在的子表
+type fkRef struct {
+	tableName string
+	t         *table
+	fk        *foreignKey
+}
+
+// getForeignKeys 获取表的外键
+func (m *tableMeta) getForeignKeys() []*foreignKey {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.foreignKeys
+}
+
+// refKeyType 引用该表的外键字段需要的类型
+func (t *table) refKeyType() fieldType {
+	if pk := t.meta.primaryKey(); pk != nil {
+		return pk.tp
+	}
+	return INT
+}
+
+// refKeyOf record被外键引用的值
+func (t *table) refKeyOf(r *Record) string {
+	if pk := t.meta.primaryKey(); pk != nil {
+		return fieldValue(pk, r)
+	}
+	return strconv.Itoa(r.Key)
+}
+
+// AddForeignKey 在子表字段上添加外键，引用父表主键。已有数据需要满足外键
+func (s *idbServer) AddForeignKey(tableName string, name string, fieldName string, refTable string, onDelete fkAction) error {
+	t, err := s.getTable(tableName)
+	if err != nil {
+		return err
+	}
+	p, err := s.getTable(refTable)
+	if err != nil {
+		return err
+	}
+
+	unlock := lockTables(map[string]*table{tableName: t, refTable: p})
+	defer unlock()
+
+	f, err := findField(t.meta.getFields(), fieldName)
+	if err != nil {
+		return err
+	}
+	if f.tp != p.refKeyType() || onDelete < RESTRICT || onDelete > SET_NULL {
+		return ErrInvalidForeignKey
+	}
+	// 必填字段无法置空
+	if onDelete == SET_NULL && (f.isRequired() || f.isPrimaryKey) {
+		return ErrInvalidForeignKey
+	}
+	for _, fk := range t.meta.getForeignKeys() {
+		if fk.name == name {
+			return ErrForeignKeyExists
+		}
+	}
+	fk := &foreignKey{
+		name:     name,
+		field:    f,
+		refTable: refTable,
+		onDelete: onDelete,
+	}
+
+	// 先收集再检查，自引用时不在遍历中读同一棵树
+	var records []*Record
+	t.data.scanLeaves(func(r *Record) bool {
+		records = append(records, r)
+		return true
+	})
+	v := &fkView{s: s}
+	for _, r := range records {
+		if err = v.checkParent(tableName, t, fk, r); err != nil {
+			return err
+		}
+	}
+
+	// 删除父表数据时需要通过索引找到子表数据
+	var idx *index
+	if !hasIndexOn(t.meta.getIndexes(), f.pos) {
+		idx, err = t.buildIndex(name, []*FieldMeta{f}, false)
+		if err != nil {
+			return err
+		}
+	}
+
+	t.meta.mu.Lock()
+	defer t.meta.mu.Unlock()
+	if idx != nil {
+		t.meta.publishIndex(idx)
+	}
+	fks := make([]*foreignKey, len(t.meta.foreignKeys), len(t.meta.foreignKeys)+1)
+	copy(fks, t.meta.foreignKeys)
+	t.meta.foreignKeys = append(fks, fk)
+	return nil
+}
+
+// DropForeignKey 删除外键，外键字段上的索引保留
+func (s *idbServer) DropForeignKey(tableName string, name string) error {
+	t, err := s.getTable(tableName)
+	if err != nil {
+		return err
+	}
+
+	t.writeMu.Lock()
+	defer t.writeMu.Unlock()
+	t.meta.mu.Lock()
+	defer t.meta.mu.Unlock()
+
+	fks := make([]*foreignKey, 0, len(t.meta.foreignKeys))
+	for _, fk := range t.meta.foreignKeys {
+		if fk.name != name {
+			fks = append(fks, fk)
+		}
+	}
+	if len(fks) == len(t.meta.foreignKeys) {
+		return ErrForeignKeyNotExist
+	}
+	t.meta.foreignKeys = fks
+	return nil
+}
+
+func hasIndexOn(indexes []*index, slot int) bool {
+	for _, idx := range indexes {
+		if idx.covers(slot) {
+			return true
+		}
+	}
+	return false
+}
+
+// referencing 找到引用该表的外键
+func (s *idbServer) referencing(tableName string) []*fkRef {
+	s.DB.mu.RLock()
+	defer s.DB.mu.RUnlock()
+	return s.referencingLocked(tableName)
+}
+
+// referencingLocked 调用方需持有DB.mu
+func (s *idbServer) referencingLocked(tableName string) []*fkRef {
+	var refs []*fkRef
+	for name, t := range s.DB.tables {
+		for _, fk := range t.meta.getForeignKeys() {
+			if fk.refTable == tableName {
+				refs = append(refs, &fkRef{tableName: name, t: t, fk: fk})
+			}
+		}
+	}
+	sort.Slice(refs, func(i, j int) bool {
+		if refs[i].tableName != refs[j].tableName {
+			return refs[i].tableName < refs[j].tableName
+		}
+		return refs[i].fk.name < refs[j].fk.name
+	})
+	return refs
+}
+
+// referencedByOthers 是否有其他表引用该表，调用方需持有DB.mu
+func (s *idbServer) referencedByOthers(tableName string) bool {
+	for _, ref := range s.referencingLocked(tableName) {
+		if ref.tableName != tableName {
+			return true
+		}
+	}
+	return false
+}
+
+// renameRefTable 父表重命名后修改外键引用
+func (m *tableMeta) renameRefTable(oldName, newName string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var fks []*foreignKey
+	for i, fk := range m.foreignKeys {
+		if fk.refTable != oldName {
+			continue
+		}
+		if fks == nil {
+			fks = make([]*foreignKey, len(m.foreignKeys))
+			copy(fks, m.foreignKeys)
+		}
+		nfk := *fk
+		nfk.refTable = newName
+		fks[i] = &nfk
+	}
+	if fks != nil {
+		m.foreignKeys = fks
+	}
+}
+
+// lockForWrite 给表以及外键检查需要的表加写锁。parents为父表，children为递归的子表
+// 加锁后外键关系发生变化则重新加锁
+func (s *idbServer) lockForWrite(tables map[string]*table, parents, children bool) func() {
+	for {
+		related := s.fkRelated(tables, parents, children)
+		unlock := lockTables(related)
+		if sameTables(related, s.fkRelated(tables, parents, children)) {
+			return unlock
+		}
+		unlock()
+	}
+}
+
+func (s *idbServer) fkRelated(tables map[string]*table, parents, children bool) map[string]*table {
+	related := make(map[string]*table, len(tables))
+	var addChildren func(name string, t *table)
+	addChildren = func(name string, t *table) {
+		if _, ok := related[name]; ok {
+			return
+		}
+		related[name] = t
+		if !children {
+			return
+		}
+		for _, ref := range s.referencing(name) {
+			addChildren(ref.tableName, ref.t)
+		}
+	}
+	for name, t := range tables {
+		addChildren(name, t)
+	}
+
+	if !parents {
+		return related
+	}
+	for _, t := range tables {
+		for _, fk := range t.meta.getForeignKeys() {
+			if _, ok := related[fk.refTable]; ok {
+				continue
+			}
+			if p, err := s.getTable(fk.refTable); err == nil {
+				related[fk.refTable] = p
+			}
+		}
+	}
+	return related
+}
+
+func sameTables(a, b map[string]*table) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for name, t := range a {
+		if b[name] != t {
+			return false
+		}
+	}
+	return true
+}
+
+// fkView 外键检查看到的数据。事务中需要看到事务自己的写入，cache为nil时直接读表
+type fkView struct {
+	s     *idbServer
+	cache map[string]*txCache
+}
+
+func (v *fkView) opsOf(tableName string) map[int]*OpRecord {
+	if c, ok := v.cache[tableName]; ok {
+		return c.cache
+	}
+	return nil
+}
+
+// find 找到视图中的record
+func (v *fkView) find(tableName string, t *table, id int) (*Record, bool) {
+	if rc := v.opsOf(tableName)[id]; rc != nil {
+		switch rc.op {
+		case DELETE:
+			return nil, false
+		case INSERT:
+			return rc.opChange.(*InsertOpChange).record, true
+		case UPDATE:
+			base, err := t.data.Find(id)
+			if err != nil {
+				return nil, false
+			}
+			return applyChange(base, rc.opChange.(*UpdateOpChange).change), true
+		}
+	}
+	r, err := t.data.Find(id)
+	return r, err == nil
+}
+
+// parentExists 父表中是否存在被引用的值
+func (v *fkView) parentExists(tableName string, p *table, key string) bool {
+	if p.pkIndex == nil {
+		id, err := strconv.Atoi(key)
+		if err != nil {
+			return false
+		}
+		_, ok := v.find(tableName, p, id)
+		return ok
+	}
+
+	for _, id := range p.pkIndex.lookup(key) {
+		if _, ok := v.find(tableName, p, id); ok {
+			return true
+		}
+	}
+	for _, rc := range v.opsOf(tableName) {
+		if rc.op == INSERT && p.pkIndex.keyOf(rc.opChange.(*InsertOpChange).record) == key {
+			return true
+		}
+	}
+	return false
+}
+
+// checkParents 检查record引用的父表数据存在。slots不为nil时只检查其中的字段
+func (v *fkView) checkParents(tableName string, t *table, r *Record, slots map[int]string) error {
+	for _, fk := range t.meta.getForeignKeys() {
+		if slots != nil {
+			if _, ok := slots[fk.field.pos]; !ok {
+				continue
+			}
+		}
+		if err := v.checkParent(tableName, t, fk, r); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (v *fkView) checkParent(tableName string, t *table, fk *foreignKey, r *Record) error {
+	key := fieldValue(fk.field, r)
+	if key == "" {
+		return nil
+	}
+	// 自引用时可以引用自己
+	if fk.refTable == tableName && t.refKeyOf(r) == key {
+		return nil
+	}
+	p, err := v.s.getTable(fk.refTable)
+	if err == nil && v.parentExists(fk.refTable, p, key) {
+		return nil
+	}
+	return &ConstraintError{
+		Table:      tableName,
+		Constraint: fk.name,
+		Key:        key,
+		Err:        ErrForeignKeyViolation,
+	}
+}
+
+// children 找到视图中引用该值的子表record id
+func (v *fkView) children(ref *fkRef, key string) []int {
+	f := ref.fk.field
+	var candidates []int
+	found := false
+	for _, idx := range ref.t.meta.getIndexes() {
+		if idx.covers(f.pos) {
+			candidates = idx.lookup(key)
+			found = true
+			break
+		}
+	}
+	if !found {
+		ref.t.data.scanLeaves(func(r *Record) bool {
+			if fieldValue(f, r) == key {
+				candidates = append(candidates, r.Key)
+			}
+			return true
+		})
+	}
+	for id := range v.opsOf(ref.tableName) {
+		candidates = append(candidates, id)
+	}
+
+	seen := make(map[int]bool, len(candidates))
+	var ids []int
+	for _, id := range candidates {
+		if seen[id] {
+			continue
+		}
+		seen[id] = true
+		if r, ok := v.find(ref.tableName, ref.t, id); ok && fieldValue(f, r) == key {
+			ids = append(ids, id)
+		}
+	}
+	sort.Ints(ids)
+	return ids
+}
+
+type fkTarget struct {
+	tableName string
+	t         *table
+	id        int
+}
+
+type fkSetNull struct {
+	fkTarget
+	field *FieldMeta
+}
+
+// fkPlan 删除父表record时级联的操作
+type fkPlan struct {
+	deletes  []*fkTarget
+	setNulls []*fkSetNull
+	deleted  map[*table]map[int]bool
+}
+
+func newFKPlan() *fkPlan {
+	return &fkPlan{deleted: make(map[*table]map[int]bool)}
+}
+
+func (p *fkPlan) isDeleted(t *table, id int) bool {
+	return p.deleted[t][id]
+}
+
+func (p *fkPlan) markDeleted(t *table, id int) {
+	if p.deleted[t] == nil {
+		p.deleted[t] = make(map[int]bool)
+	}
+	p.deleted[t][id] = true
+}
+
+// planDelete 递归找到删除record需要级联的操作。存在RESTRICT引用时报错，不做任何修改
+func (v *fkView) planDelete(plan *fkPlan, tableName string, t *table, id int) error {
+	plan.markDeleted(t, id)
+	r, ok := v.find(tableName, t, id)
+	if !ok {
+		return nil
+	}
+
+	key := t.refKeyOf(r)
+	for _, ref := range v.s.referencing(tableName) {
+		for _, cid := range v.children(ref, key) {
+			if plan.isDeleted(ref.t, cid) {
+				continue
+			}
+			switch ref.fk.onDelete {
+			case RESTRICT:
+				return &ConstraintError{
+					Table:      ref.tableName,
+					Constraint: ref.fk.name,
+					Key:        key,
+					Err:        ErrForeignKeyViolation,
+				}
+
+			case CASCADE:
+				plan.deletes = append(plan.deletes, &fkTarget{tableName: ref.tableName, t: ref.t, id: cid})
+				if err := v.planDelete(plan, ref.tableName, ref.t, cid); err != nil {
+					return err
+				}
+
+			case SET_NULL:
+				plan.setNulls = append(plan.setNulls, &fkSetNull{
+					fkTarget: fkTarget{tableName: ref.tableName, t: ref.t, id: cid},
+					field:    ref.fk.field,
+				})
+			}
+		}
+	}
+	return nil
+}
+
+// apply 删除t中ids对应的record，并直接修改表数据执行级联操作，调用方需持有相关表的写锁。
+// 任一操作失败时撤销已经执行的操作，不会只删除父表数据，子表的错误带上子表名
+func (p *fkPlan) apply(tableName string, t *table, ids []int) error {
+	var applied []*appliedOp
+	deleteRecord := func(tableName string, t *table, id int) error {
+		before, _ := beforeImage(t, id)
+		if err := t.deleteRecord(id, false); err != nil {
+			return withRevert(withTableName(err, tableName), applied)
+		}
+		applied = append(applied, &appliedOp{t: t, op: DELETE, key: id, before: before})
+		return nil
+	}
+
+	for _, id := range ids {
+		if err := deleteRecord(tableName, t, id); err != nil {
+			return err
+		}
+	}
+	for _, sn := range p.setNulls {
+		if p.isDeleted(sn.t, sn.id) {
+			continue
+		}
+		before, ok := beforeImage(sn.t, sn.id)
+		if !ok {
+			continue
+		}
+		err := sn.t.updateRecord(map[int]string{sn.field.pos: ""}, sn.id, nil)
+		if err == ErrUpdateSame {
+			continue
+		}
+		if err != nil {
+			return withRevert(withTableName(err, sn.tableName), applied)
+		}
+		applied = append(applied, &appliedOp{t: sn.t, op: UPDATE, key: sn.id, before: before})
+	}
+	for _, d := range p.deletes {
+		if _, ok := beforeImage(d.t, d.id); !ok {
+			continue
+		}
+		if err := deleteRecord(d.tableName, d.t, d.id); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// applyTx 在事务中执行级联操作
+func (p *fkPlan) applyTx(s *idbServer, tx *Tx) error {
+	for _, sn := range p.setNulls {
+		if p.isDeleted(sn.t, sn.id) {
+			continue
+		}
+		err := s.UpdateByIDTx(tx, sn.tableName, map[string]interface{}{sn.field.name: nil}, sn.id)
+		if err != nil && err != ErrKeyNotFound {
+			return err
+		}
+	}
+	for _, d := range p.deletes {
+		c, err := s.findTableTxCache(tx, d.tableName)
+		if err != nil {
+			return err
+		}
+		err = deleteInTxCache(c, d.id)
+		if err != nil && err != ErrKeyNotFound {
+			return err
+		}
+	}
+	return nil
+}
+
+// checkTx 提交前检查事务写入后外键仍然满足，其他事务可能已经删除了父表数据或插入了子表数据
+func (v *fkView) checkTx() error {
+	for name, c := range v.cache {
+		for id, rc := range c.cache {
+			var err error
+			switch rc.op {
+			case INSERT:
+				err = v.checkParents(name, c.t, rc.opChange.(*InsertOpChange).record, nil)
+
+			case UPDATE:
+				if r, ok := v.find(name, c.t, id); ok {
+					err = v.checkParents(name, c.t, r, rc.opChange.(*UpdateOpChange).change)
+				}
+
+			case DELETE:
+				r, ferr := c.t.data.Find(id)
+				if ferr != nil {
+					continue
+				}
+				key := c.t.refKeyOf(r)
+				for _, ref := range v.s.referencing(name) {
+					if len(v.children(ref, key)) > 0 {
+						return &ConstraintError{
+							Table:      ref.tableName,
+							Constraint: ref.fk.name,
+							Key:        key,
+							Err:        ErrForeignKeyViolation,
+						}
+					}
+				}
+			}
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
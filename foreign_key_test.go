@@ -0,0 +1,345 @@
+package IDB
+
+import (
+	"errors"
+	"testing"
+)
+
+func createOrderTables(server *idbServer, onDelete fkAction) error {
+	server.CreateTable("orders", []*FieldMeta{
+		{name: "order_no", isPrimaryKey: true, tp: STRING},
+	})
+	server.CreateTable("order_lines", []*FieldMeta{
+		{name: "order_no", tp: STRING},
+		{name: "sku", tp: STRING},
+	})
+	return server.AddForeignKey("order_lines", "fk_order", "order_no", "orders", onDelete)
+}
+
+func TestForeignKey(t *testing.T) {
+	server := NewIDBServer()
+	err := createOrderTables(server, RESTRICT)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = server.AddForeignKey("order_lines", "fk_order", "order_no", "orders", RESTRICT)
+	if err != ErrForeignKeyExists {
+		t.Fatalf("expected %v got %v", ErrForeignKeyExists, err)
+	}
+	err = server.AddForeignKey("order_lines", "fk_sku", "sku", "orders", SET_NULL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = server.DropForeignKey("order_lines", "fk_sku")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = server.Insert("order_lines", []interface{}{"SO-001", "apple"})
+	var ce *ConstraintError
+	if !errors.As(err, &ce) || !errors.Is(err, ErrForeignKeyViolation) {
+		t.Fatalf("expected %v got %v", ErrForeignKeyViolation, err)
+	}
+	if ce.Table != "order_lines" || ce.Constraint != "fk_order" || ce.Key != "SO-001" {
+		t.Fatalf("unexpected %+v", ce)
+	}
+	// 空值不检查
+	err = server.Insert("order_lines", []interface{}{nil, "apple"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = server.Insert("orders", []interface{}{"SO-001"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = server.Insert("order_lines", []interface{}{"SO-001", "apple"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = server.UpdateByID("order_lines", map[string]interface{}{"order_no": "SO-002"}, 3)
+	if !errors.Is(err, ErrForeignKeyViolation) {
+		t.Fatalf("expected %v got %v", ErrForeignKeyViolation, err)
+	}
+	err = server.UpdateByID("order_lines", map[string]interface{}{"order_no": "SO-001"}, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// RESTRICT时存在子表数据不能删除父表数据
+	record, err := server.SelectByPK("orders", "SO-001")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = server.DeleteByID("orders", record.Key)
+	if !errors.As(err, &ce) || !errors.Is(err, ErrForeignKeyViolation) {
+		t.Fatalf("expected %v got %v", ErrForeignKeyViolation, err)
+	}
+	if ce.Table != "order_lines" || ce.Constraint != "fk_order" || ce.Key != "SO-001" {
+		t.Fatalf("unexpected %+v", ce)
+	}
+	_, err = server.SelectByPK("orders", "SO-001")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 类型不一致以及必填字段置空
+	err = server.AddForeignKey("order_lines", "fk_bad", "order_no", "order_lines", RESTRICT)
+	if err != ErrInvalidForeignKey {
+		t.Fatalf("expected %v got %v", ErrInvalidForeignKey, err)
+	}
+	err = server.AlterColumnType("order_lines", "order_no", INT)
+	if err != ErrInvalidForeignKey {
+		t.Fatalf("expected %v got %v", ErrInvalidForeignKey, err)
+	}
+
+	// 被引用的表不能删除或清空，重命名后外键跟随
+	err = server.DropTable("orders")
+	if err != ErrTableReferenced {
+		t.Fatalf("expected %v got %v", ErrTableReferenced, err)
+	}
+	err = server.TruncateTable("orders")
+	if err != ErrTableReferenced {
+		t.Fatalf("expected %v got %v", ErrTableReferenced, err)
+	}
+	err = server.RenameTable("orders", "sales_orders")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = server.Insert("order_lines", []interface{}{"SO-002", "pear"})
+	if !errors.Is(err, ErrForeignKeyViolation) {
+		t.Fatalf("expected %v got %v", ErrForeignKeyViolation, err)
+	}
+	err = server.Insert("sales_orders", []interface{}{"SO-002"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = server.Insert("order_lines", []interface{}{"SO-002", "pear"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 已有数据不满足时不能添加外键
+	server.CreateTable("refunds", []*FieldMeta{
+		{name: "order_no", tp: STRING},
+	})
+	err = server.Insert("refunds", []interface{}{"SO-009"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = server.AddForeignKey("refunds", "fk_refund", "order_no", "sales_orders", RESTRICT)
+	if !errors.Is(err, ErrForeignKeyViolation) {
+		t.Fatalf("expected %v got %v", ErrForeignKeyViolation, err)
+	}
+}
+
+func TestForeignKeyOnDelete(t *testing.T) {
+	server := NewIDBServer()
+	err := createOrderTables(server, CASCADE)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 没有主键的表通过自增id引用
+	server.CreateTable("line_notes", []*FieldMeta{
+		{name: "line_id", tp: INT},
+		{name: "note", tp: STRING},
+	})
+	err = server.AddForeignKey("line_notes", "fk_line", "line_id", "order_lines", SET_NULL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	server.Insert("orders", []interface{}{"SO-001"})
+	server.Insert("orders", []interface{}{"SO-002"})
+	server.Insert("order_lines", []interface{}{"SO-001", "apple"})
+	server.Insert("order_lines", []interface{}{"SO-001", "pear"})
+	server.Insert("order_lines", []interface{}{"SO-002", "grape"})
+	server.Insert("line_notes", []interface{}{1, "fresh"})
+	server.Insert("line_notes", []interface{}{3, "sweet"})
+
+	// 级联删除订单行，订单行备注置空
+	err = server.DeleteByID("orders", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	records, err := server.SelectByFields("order_lines", map[string]interface{}{"order_no": "SO-001"})
+	if err != ErrValueNotFound {
+		t.Fatalf("expected %v got %v %v", ErrValueNotFound, records, err)
+	}
+	_, err = server.SelectByID("order_lines", 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	record, err := server.SelectByID("line_notes", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if record.Value[0] != "" || record.Value[1] != "fresh" {
+		t.Fatalf("unexpected %v", record)
+	}
+	record, err = server.SelectByID("line_notes", 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if record.Value[0] != "3" {
+		t.Fatalf("unexpected %v", record)
+	}
+
+	// 自引用外键级联
+	server.CreateTable("employees", []*FieldMeta{
+		{name: "manager_id", tp: INT},
+	})
+	err = server.AddForeignKey("employees", "fk_manager", "manager_id", "employees", CASCADE)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server.Insert("employees", []interface{}{nil})
+	server.Insert("employees", []interface{}{1})
+	server.Insert("employees", []interface{}{2})
+	err = server.DeleteByID("employees", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if server.DB.tables["employees"].data.Count() != 0 {
+		t.Fatal("employees should be deleted by cascade")
+	}
+}
+
+func TestForeignKeyOnDeleteFailure(t *testing.T) {
+	server := NewIDBServer()
+	err := createOrderTables(server, CASCADE)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server.CreateTable("line_notes", []*FieldMeta{
+		{name: "line_id", tp: INT},
+		{name: "note", tp: STRING},
+	})
+	err = server.AddForeignKey("line_notes", "fk_line", "line_id", "order_lines", SET_NULL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 置空时违反CHECK约束
+	err = server.AddCheck("line_notes", "ck_line", []string{"line_id"}, func(row map[string]interface{}) bool {
+		return row["line_id"] != nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	server.Insert("orders", []interface{}{"SO-001"})
+	server.Insert("order_lines", []interface{}{"SO-001", "apple"})
+	server.Insert("line_notes", []interface{}{1, "fresh"})
+
+	// 级联操作失败时父表以及子表数据都不变
+	deletes := []func() error{
+		func() error {
+			return server.DeleteByID("orders", 1)
+		},
+		func() error {
+			_, err := server.DeleteWhere("orders", nil)
+			return err
+		},
+	}
+	for i, del := range deletes {
+		err = del()
+		var ce *ConstraintError
+		if !errors.As(err, &ce) || !errors.Is(err, ErrCheckViolation) {
+			t.Fatalf("%d: expected %v got %v", i, ErrCheckViolation, err)
+		}
+		if ce.Table != "line_notes" || ce.Constraint != "ck_line" {
+			t.Fatalf("%d: unexpected %+v", i, ce)
+		}
+		for _, name := range []string{"orders", "order_lines", "line_notes"} {
+			if _, err = server.SelectByID(name, 1); err != nil {
+				t.Fatalf("%d: %s: %v", i, name, err)
+			}
+		}
+		record, _ := server.SelectByID("line_notes", 1)
+		if record.Value[0] != "1" {
+			t.Fatalf("%d: unexpected %v", i, record)
+		}
+	}
+}
+
+func TestForeignKeyInTx(t *testing.T) {
+	server := NewIDBServer()
+	inspector := NewUndoInspector()
+	server.WithOptions(func(option *ServerOptionConfig) {
+		option.inspector = inspector
+	})
+	err := createOrderTables(server, CASCADE)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tm := NewTxMgr(server, inspector)
+
+	// 同一事务中插入父表以及子表数据
+	tx := tm.StartTransaction()
+	err = server.InsertTx(tx, "order_lines", []interface{}{"SO-001", "apple"})
+	if !errors.Is(err, ErrForeignKeyViolation) {
+		t.Fatalf("expected %v got %v", ErrForeignKeyViolation, err)
+	}
+	err = server.InsertTx(tx, "orders", []interface{}{"SO-001"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = server.InsertTx(tx, "order_lines", []interface{}{"SO-001", "apple"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = tx.Commit()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 事务中删除父表数据，级联删除事务中插入的子表数据
+	tx = tm.StartTransaction()
+	err = server.InsertTx(tx, "order_lines", []interface{}{"SO-001", "pear"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = server.DeleteByIDTx(tx, "orders", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(tx.cache["order_lines"].cache) != 1 {
+		t.Fatalf("unexpected %v", tx.cache["order_lines"].cache)
+	}
+	err = server.InsertTx(tx, "order_lines", []interface{}{"SO-001", "grape"})
+	if !errors.Is(err, ErrForeignKeyViolation) {
+		t.Fatalf("expected %v got %v", ErrForeignKeyViolation, err)
+	}
+	err = tx.Commit()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if server.DB.tables["orders"].data.Count() != 0 || server.DB.tables["order_lines"].data.Count() != 0 {
+		t.Fatal("orders and order lines should be deleted")
+	}
+
+	// 提交前父表数据被其他人删除
+	err = server.Insert("orders", []interface{}{"SO-002"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	record, err := server.SelectByPK("orders", "SO-002")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tx = tm.StartTransaction()
+	err = server.InsertTx(tx, "order_lines", []interface{}{"SO-002", "apple"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = server.DeleteByID("orders", record.Key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = tx.Commit()
+	if !errors.Is(err, ErrForeignKeyViolation) {
+		t.Fatalf("expected %v got %v", ErrForeignKeyViolation, err)
+	}
+	if server.DB.tables["order_lines"].data.Count() != 0 {
+		t.Fatal("order line should not be inserted")
+	}
+}
@@ -0,0 +1,290 @@
+package IDB
+
+import (
+	"errors"
+	"strings"
+	"sync"
+)
+
+var (
+	ErrIndexExists    = errors.New("storage: index exists")
+	ErrIndexNotExist  = errors.New("storage: index not exist")
+	ErrDuplicateKey   = errors.New("storage: duplicate key in unique index")
+	indexKeySeparator = "\x00"
+)
+
+// index 字段值到record id的哈希索引
+type index struct {
+	name string
+	// 索引字段在record.Value中的位置
+	slots []int
+	// 旧record没有索引字段时使用的默认值
+	defaults []string
+	// 索引字段的类型，INT字段的key使用规范的整数形式
+	types   []fieldType
+	unique  bool
+	mu      *sync.RWMutex
+	entries map[string]map[int]bool
+}
+
+func newIndex(name string, fields []*FieldMeta, unique bool) *index {
+	slots := make([]int, len(fields))
+	defaults := make([]string, len(fields))
+	types := make([]fieldType, len(fields))
+	for i, f := range fields {
+		slots[i] = f.pos
+		defaults[i] = f.defaultValue
+		types[i] = f.tp
+	}
+	return &index{
+		name:     name,
+		slots:    slots,
+		defaults: defaults,
+		types:    types,
+		unique:   unique,
+		mu:       &sync.RWMutex{},
+		entries:  make(map[string]map[int]bool),
+	}
+}
+
+// emptyCopy 复制索引定义，不复制数据
+func (idx *index) emptyCopy() *index {
+	return &index{
+		name:     idx.name,
+		slots:    idx.slots,
+		defaults: idx.defaults,
+		types:    idx.types,
+		unique:   idx.unique,
+		mu:       &sync.RWMutex{},
+		entries:  make(map[string]map[int]bool),
+	}
+}
+
+// keyOf 获取record在索引中的key
+func (idx *index) keyOf(r *Record) string {
+	if len(idx.slots) == 1 {
+		return idx.valueAt(r, 0)
+	}
+	parts := make([]string, len(idx.slots))
+	for i := range idx.slots {
+		parts[i] = idx.valueAt(r, i)
+	}
+	return strings.Join(parts, indexKeySeparator)
+}
+
+// displayKey 用于错误信息的key
+func (idx *index) displayKey(r *Record) string {
+	parts := make([]string, len(idx.slots))
+	for i := range idx.slots {
+		parts[i] = idx.valueAt(r, i)
+	}
+	return strings.Join(parts, ", ")
+}
+
+// valueAt 索引字段的值。STRING字段修改为INT后，旧数据可能不是规范的整数形式，如"007"
+func (idx *index) valueAt(r *Record, i int) string {
+	v := idx.defaults[i]
+	if idx.slots[i] < len(r.Value) {
+		v = r.Value[idx.slots[i]]
+	}
+	return normalizeStoredValue(idx.types[i], v)
+}
+
+// hasNull 索引字段存在空值时不参与唯一性检查
+func (idx *index) hasNull(r *Record) bool {
+	for i := range idx.slots {
+		if idx.valueAt(r, i) == "" {
+			return true
+		}
+	}
+	return false
+}
+
+// covers 索引是否只包含该字段
+func (idx *index) covers(slot int) bool {
+	return len(idx.slots) == 1 && idx.slots[0] == slot
+}
+
+// contains 索引是否包含该字段
+func (idx *index) contains(slot int) bool {
+	for _, s := range idx.slots {
+		if s == slot {
+			return true
+		}
+	}
+	return false
+}
+
+// fieldsOf 按索引字段的顺序找到字段
+func (idx *index) fieldsOf(fields []*FieldMeta) []*FieldMeta {
+	fms := make([]*FieldMeta, len(idx.slots))
+	for i, slot := range idx.slots {
+		for _, f := range fields {
+			if f.pos == slot {
+				fms[i] = f
+			}
+		}
+	}
+	return fms
+}
+
+// lookup 找到索引值对应的所有record id
+func (idx *index) lookup(key string) []int {
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+
+	ids := make([]int, 0, len(idx.entries[key]))
+	for id := range idx.entries[key] {
+		ids = append(ids, id)
+	}
+	return ids
+}
+
+// conflicts 唯一索引中是否已有其他record使用该key
+func (idx *index) conflicts(r *Record) bool {
+	if !idx.unique || idx.hasNull(r) {
+		return false
+	}
+	for _, id := range idx.lookup(idx.keyOf(r)) {
+		if id != r.Key {
+			return true
+		}
+	}
+	return false
+}
+
+func (idx *index) add(key string, id int) {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+
+	if idx.entries[key] == nil {
+		idx.entries[key] = make(map[int]bool)
+	}
+	idx.entries[key][id] = true
+}
+
+func (idx *index) remove(key string, id int) {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+
+	delete(idx.entries[key], id)
+	if len(idx.entries[key]) == 0 {
+		delete(idx.entries, key)
+	}
+}
+
+// getIndexes 获取表的二级索引
+func (m *tableMeta) getIndexes() []*index {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.indexes
+}
+
+// allIndexes 主键索引以及二级索引
+func (t *table) allIndexes() []*index {
+	indexes := t.meta.getIndexes()
+	if t.pkIndex == nil {
+		return indexes
+	}
+	all := make([]*index, 0, len(indexes)+1)
+	all = append(all, t.pkIndex)
+	return append(all, indexes...)
+}
+
+// CreateIndex 在字段上创建索引。已有数据会被加入索引，创建期间阻塞写但不阻塞读
+func (s *idbServer) CreateIndex(tableName string, fieldName string, unique bool) error {
+	t, err := s.getTable(tableName)
+	if err != nil {
+		return err
+	}
+	return withTableName(t.addIndex(fieldName, []string{fieldName}, unique), tableName)
+}
+
+// addIndex 根据已有数据建立索引并发布
+func (t *table) addIndex(name string, fieldNames []string, unique bool) error {
+	t.writeMu.Lock()
+	defer t.writeMu.Unlock()
+
+	fields := t.meta.getFields()
+	indexFields := make([]*FieldMeta, len(fieldNames))
+	for i, fieldName := range fieldNames {
+		f, err := findField(fields, fieldName)
+		if err != nil {
+			return err
+		}
+		indexFields[i] = f
+	}
+	if name == primaryKeyConstraint {
+		return ErrIndexExists
+	}
+	for _, idx := range t.meta.getIndexes() {
+		if idx.name == name {
+			return ErrIndexExists
+		}
+	}
+
+	idx, err := t.buildIndex(name, indexFields, unique)
+	if err != nil {
+		return err
+	}
+
+	t.meta.mu.Lock()
+	defer t.meta.mu.Unlock()
+	t.meta.publishIndex(idx)
+	return nil
+}
+
+// buildIndex 用已有数据建立索引，调用方需持有writeMu
+func (t *table) buildIndex(name string, fields []*FieldMeta, unique bool) (*index, error) {
+	// 索引还未发布，可以直接写entries
+	var err error
+	idx := newIndex(name, fields, unique)
+	t.data.scanLeaves(func(r *Record) bool {
+		key := idx.keyOf(r)
+		if unique && !idx.hasNull(r) && len(idx.entries[key]) > 0 {
+			err = uniqueError(idx, r)
+			return false
+		}
+		if idx.entries[key] == nil {
+			idx.entries[key] = make(map[int]bool)
+		}
+		idx.entries[key][r.Key] = true
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+	return idx, nil
+}
+
+// publishIndex 发布索引，调用方需持有meta.mu写锁
+func (m *tableMeta) publishIndex(idx *index) {
+	indexes := make([]*index, len(m.indexes), len(m.indexes)+1)
+	copy(indexes, m.indexes)
+	m.indexes = append(indexes, idx)
+}
+
+// DropIndex 根据名称删除索引，字段上的索引名称即为字段名
+func (s *idbServer) DropIndex(tableName string, name string) error {
+	t, err := s.getTable(tableName)
+	if err != nil {
+		return err
+	}
+
+	t.writeMu.Lock()
+	defer t.writeMu.Unlock()
+	t.meta.mu.Lock()
+	defer t.meta.mu.Unlock()
+
+	indexes := make([]*index, 0, len(t.meta.indexes))
+	for _, idx := range t.meta.indexes {
+		if idx.name != name {
+			indexes = append(indexes, idx)
+		}
+	}
+	if len(indexes) == len(t.meta.indexes) {
+		return ErrIndexNotExist
+	}
+	t.meta.indexes = indexes
+	return nil
+}
@@ -0,0 +1,277 @@
+package IDB
+
+import (
+	"errors"
+	"strconv"
+	"testing"
+)
+
+func TestCreateIndexOnExistingTable(t *testing.T) {
+	server := NewIDBServer()
+	fms := []*FieldMeta{
+		{
+			name: "name",
+			tp:   STRING,
+		},
+		{
+			name: "city",
+			tp:   STRING,
+		},
+	}
+	tableName := "test"
+	server.CreateTable(tableName, fms)
+	for i := 0; i < 100; i++ {
+		err := server.Insert(tableName, []interface{}{"name" + strconv.Itoa(i), "city" + strconv.Itoa(i%10)})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	err := server.CreateIndex(tableName, "city", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = server.CreateIndex(tableName, "city", false)
+	if err != ErrIndexExists {
+		t.Fatalf("expected %v got %v", ErrIndexExists, err)
+	}
+	err = server.CreateIndex(tableName, "name", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = server.Insert(tableName, []interface{}{"name0", "city0"})
+	if !errors.Is(err, ErrDuplicateKey) {
+		t.Fatalf("expected %v got %v", ErrDuplicateKey, err)
+	}
+	err = server.DropIndex(tableName, "name")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = server.Insert(tableName, []interface{}{"name0", "city0"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 已有重复数据无法建唯一索引
+	err = server.CreateIndex(tableName, "name", true)
+	if !errors.Is(err, ErrDuplicateKey) {
+		t.Fatalf("expected %v got %v", ErrDuplicateKey, err)
+	}
+
+	records, err := server.SelectByFields(tableName, map[string]interface{}{"city": "city3"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 10 {
+		t.Fatalf("expected 10 got %d", len(records))
+	}
+	for i := 1; i < len(records); i++ {
+		if records[i-1].Key >= records[i].Key {
+			t.Fatal("records should be ordered by key")
+		}
+	}
+	records, err = server.SelectByFields(tableName, map[string]interface{}{"city": "city3", "name": "name13"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 1 || records[0].Value[0] != "name13" {
+		t.Fatalf("unexpected %v", records)
+	}
+}
+
+func TestIndexMaintenance(t *testing.T) {
+	server := NewIDBServer()
+	inspector := NewUndoInspector()
+	server.WithOptions(func(option *ServerOptionConfig) {
+		option.inspector = inspector
+	})
+	fms := []*FieldMeta{
+		{
+			name: "name",
+			tp:   STRING,
+		},
+	}
+	tableName := "test"
+	server.CreateTable(tableName, fms)
+	err := server.CreateIndex(tableName, "name", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	idx := server.DB.tables[tableName].meta.indexes[0]
+
+	err = server.Insert(tableName, []interface{}{"hello"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = server.Insert(tableName, []interface{}{"world"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = server.UpdateByID(tableName, map[string]interface{}{"name": "python"}, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(idx.lookup("hello")) != 0 || len(idx.lookup("python")) != 1 {
+		t.Fatal("update should maintain index")
+	}
+	err = server.DeleteByID(tableName, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(idx.lookup("world")) != 0 {
+		t.Fatal("delete should maintain index")
+	}
+
+	// 事务提交同样维护索引
+	tm := NewTxMgr(server, inspector)
+	tx := tm.StartTransaction()
+	err = server.InsertTx(tx, tableName, []interface{}{"golang"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = server.UpdateByIDTx(tx, tableName, map[string]interface{}{"name": "java"}, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = tx.Commit()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(idx.lookup("python")) != 0 || len(idx.lookup("java")) != 1 || len(idx.lookup("golang")) != 1 {
+		t.Fatal("commit should maintain index")
+	}
+	records, err := server.SelectByFields(tableName, map[string]interface{}{"name": "python"})
+	if err != ErrValueNotFound {
+		t.Fatalf("expected %v got %v %v", ErrValueNotFound, records, err)
+	}
+
+	tx = tm.StartTransaction()
+	err = server.DeleteByIDTx(tx, tableName, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = tx.Commit()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(idx.lookup("java")) != 0 {
+		t.Fatal("commit should maintain index")
+	}
+
+	// 删除字段同时删除索引；清空表保留索引定义
+	err = server.AddColumn(tableName, &FieldMeta{name: "city", tp: STRING}, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = server.CreateIndex(tableName, "city", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = server.DropColumn(tableName, "city")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = server.TruncateTable(tableName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	indexes := server.DB.tables[tableName].meta.indexes
+	if len(indexes) != 1 || indexes[0].name != "name" || len(indexes[0].entries) != 0 {
+		t.Fatalf("unexpected %v", indexes)
+	}
+}
+
+func TestUniqueIndex(t *testing.T) {
+	server := NewIDBServer()
+	inspector := NewUndoInspector()
+	server.WithOptions(func(option *ServerOptionConfig) {
+		option.inspector = inspector
+	})
+	fms := []*FieldMeta{
+		{
+			name: "email",
+			tp:   STRING,
+		},
+	}
+	tableName := "users"
+	server.CreateTable(tableName, fms)
+	err := server.CreateIndex(tableName, "email", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = server.Insert(tableName, []interface{}{"a@x.com"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = server.Insert(tableName, []interface{}{"b@x.com"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 空值不参与唯一性检查
+	err = server.Insert(tableName, []interface{}{nil})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = server.Insert(tableName, []interface{}{nil})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = server.Insert(tableName, []interface{}{"a@x.com"})
+	if !errors.Is(err, ErrDuplicateKey) {
+		t.Fatalf("expected %v got %v", ErrDuplicateKey, err)
+	}
+	err = server.UpdateByID(tableName, map[string]interface{}{"email": "a@x.com"}, 2)
+	if !errors.Is(err, ErrDuplicateKey) {
+		t.Fatalf("expected %v got %v", ErrDuplicateKey, err)
+	}
+	err = server.UpdateByID(tableName, map[string]interface{}{"email": "a@x.com"}, 1)
+	if err != ErrUpdateSame {
+		t.Fatalf("expected %v got %v", ErrUpdateSame, err)
+	}
+
+	// 事务中交换两个值不冲突
+	tm := NewTxMgr(server, inspector)
+	tx := tm.StartTransaction()
+	err = server.UpdateByIDTx(tx, tableName, map[string]interface{}{"email": "b@x.com"}, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = server.DeleteByIDTx(tx, tableName, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = server.InsertTx(tx, tableName, []interface{}{"a@x.com"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = tx.Commit()
+	if err != nil {
+		t.Fatal(err)
+	}
+	records, err := server.SelectByFields(tableName, map[string]interface{}{"email": "b@x.com"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 1 || records[0].Key != 1 {
+		t.Fatalf("unexpected %v", records)
+	}
+
+	// 事务中插入重复值，提交时不生效
+	tx = tm.StartTransaction()
+	err = server.InsertTx(tx, tableName, []interface{}{"c@x.com"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = server.InsertTx(tx, tableName, []interface{}{"c@x.com"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = tx.Commit()
+	if !errors.Is(err, ErrDuplicateKey) {
+		t.Fatalf("expected %v got %v", ErrDuplicateKey, err)
+	}
+	_, err = server.SelectByFields(tableName, map[string]interface{}{"email": "c@x.com"})
+	if err != ErrValueNotFound {
+		t.Fatalf("expected %v got %v", ErrValueNotFound, err)
+	}
+}
@@ -18,18 +18,35 @@ type UndoInspector struct {
 
 type sharedData struct {
 	upData  map[int]*txUpdateData
-	delData map[int]*Record
+	delData map[undoKey]*Record
+}
+
+// undoKey 不同表的record可能有相同的id，数据按表名和id区分
+type undoKey struct {
+	tableName string
+	id        int
 }
 
 type txUpdateData struct {
-	txRecords map[int]*txUpdateRecord
+	txRecords map[undoKey]*txUpdateRecord
 }
 
 type txUpdateRecord struct {
 	record *Record
 }
 
+// HandleDataBeforeUpdate 不属于任何表的树使用，表名为空
 func (ui *UndoInspector) HandleDataBeforeUpdate(oldRecord *Record, newRecordMeta *RecordMeta) {
+	ui.handleDataBeforeUpdate("", oldRecord, newRecordMeta)
+}
+
+// HandleDataBeforeDelete 不属于任何表的树使用，表名为空
+func (ui *UndoInspector) HandleDataBeforeDelete(record *Record) {
+	ui.handleDataBeforeDelete("", record)
+}
+
+// handleDataBeforeUpdate 只记录事务提交时的更新，newRecordMeta为nil的更新不属于事务
+func (ui *UndoInspector) handleDataBeforeUpdate(tableName string, oldRecord *Record, newRecordMeta *RecordMeta) {
 	if newRecordMeta == nil {
 		return
 	}
@@ -39,10 +56,10 @@ func (ui *UndoInspector) HandleDataBeforeUpdate(oldRecord *Record, newRecordMeta
 	values := make([]string, len(oldRecord.Value))
 	copy(values, oldRecord.Value)
 	if ui.data.upData[newRecordMeta.LastTxID] == nil {
-		ui.data.upData[newRecordMeta.LastTxID] = &txUpdateData{txRecords: make(map[int]*txUpdateRecord)}
+		ui.data.upData[newRecordMeta.LastTxID] = &txUpdateData{txRecords: make(map[undoKey]*txUpdateRecord)}
 	}
 	txUpData := ui.data.upData[newRecordMeta.LastTxID]
-	txUpData.txRecords[oldRecord.Key] = &txUpdateRecord{
+	txUpData.txRecords[undoKey{tableName: tableName, id: oldRecord.Key}] = &txUpdateRecord{
 		record: &Record{
 			Key:   oldRecord.Key,
 			Value: values,
@@ -50,19 +67,19 @@ func (ui *UndoInspector) HandleDataBeforeUpdate(oldRecord *Record, newRecordMeta
 	}
 }
 
-func (ui *UndoInspector) HandleDataBeforeDelete(record *Record) {
+func (ui *UndoInspector) handleDataBeforeDelete(tableName string, record *Record) {
 	ui.delMu.Lock()
 	defer ui.delMu.Unlock()
 
 	values := make([]string, len(record.Value))
 	copy(values, record.Value)
-	ui.data.delData[record.Key] = &Record{
+	ui.data.delData[undoKey{tableName: tableName, id: record.Key}] = &Record{
 		Key:   record.Key,
 		Value: values,
 	}
 }
 
-func (ui *UndoInspector) GetRecordBeforeUpdate(txID, recordID int) (*Record, error) {
+func (ui *UndoInspector) GetRecordBeforeUpdate(tableName string, txID, recordID int) (*Record, error) {
 	ui.upMu.Lock()
 	defer ui.upMu.Unlock()
 
@@ -71,34 +88,55 @@ func (ui *UndoInspector) GetRecordBeforeUpdate(txID, recordID int) (*Record, err
 		return nil, ErrNoSuchRecordInUndoInspector
 	}
 
-	record := txData.txRecords[recordID]
+	key := undoKey{tableName: tableName, id: recordID}
+	record := txData.txRecords[key]
 	if record == nil {
 		return nil, ErrNoSuchRecordInUndoInspector
 	}
-	delete(txData.txRecords, recordID)
+	delete(txData.txRecords, key)
 	if len(txData.txRecords) == 0 {
 		delete(ui.data.upData, txID)
 	}
 	return record.record, nil
 }
 
-func (ui *UndoInspector) GetRecordBeforeDelete(recordID int) (*Record, error) {
+func (ui *UndoInspector) GetRecordBeforeDelete(tableName string, recordID int) (*Record, error) {
 	ui.delMu.Lock()
 	defer ui.delMu.Unlock()
 
-	record := ui.data.delData[recordID]
+	key := undoKey{tableName: tableName, id: recordID}
+	record := ui.data.delData[key]
 	if record == nil {
 		return nil, ErrNoSuchRecordInUndoInspector
 	}
-	delete(ui.data.delData, recordID)
+	delete(ui.data.delData, key)
 	return record, nil
 }
 
+// forTable 表的树使用的inspector，记录的数据带上表名
+func (ui *UndoInspector) forTable(tableName string) Inspector {
+	return &tableInspector{ui: ui, tableName: tableName}
+}
+
+// tableInspector 把一个表的修改前数据记录到UndoInspector中
+type tableInspector struct {
+	ui        *UndoInspector
+	tableName string
+}
+
+func (ti *tableInspector) HandleDataBeforeUpdate(oldRecord *Record, newRecordMeta *RecordMeta) {
+	ti.ui.handleDataBeforeUpdate(ti.tableName, oldRecord, newRecordMeta)
+}
+
+func (ti *tableInspector) HandleDataBeforeDelete(record *Record) {
+	ti.ui.handleDataBeforeDelete(ti.tableName, record)
+}
+
 func NewUndoInspector() *UndoInspector {
 	return &UndoInspector{
 		data: &sharedData{
 			upData:  make(map[int]*txUpdateData),
-			delData: make(map[int]*Record),
+			delData: make(map[undoKey]*Record),
 		},
 		delMu: &sync.Mutex{},
 		upMu:  &sync.Mutex{},
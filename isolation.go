@@ -0,0 +1,62 @@
+package IDB
+
+import "time"
+
+// IsolationLevel 事务隔离级别
+type IsolationLevel int
+
+const (
+	// READ_COMMITTED 每条语句开始时使用新的readView，提交时不检查写冲突
+	READ_COMMITTED IsolationLevel = iota + 1
+	// REPEATABLE_READ 整个事务使用开始时的readView，提交时检查写冲突，先提交者胜出
+	REPEATABLE_READ
+	// SNAPSHOT 与REPEATABLE_READ相同
+	SNAPSHOT
+	// SERIALIZABLE 在SNAPSHOT的基础上跟踪并发事务之间的读写反依赖，可能无法序列化时中止事务
+	SERIALIZABLE
+)
+
+// TxOptions 事务选项
+type TxOptions struct {
+	// 隔离级别，仅用于idbServer.Begin以及RunInTx，为0时使用REPEATABLE_READ
+	Isolation IsolationLevel
+	// 只读事务不能修改数据。SERIALIZABLE只读事务开始时没有并发的读写事务时快照是安全的，不需要跟踪读集合
+	ReadOnly bool
+	// 行锁的最长等待时间，为0时一直等待
+	LockTimeout time.Duration
+	// 重试策略，仅用于idbServer.RunInTx
+	Retry RetryPolicy
+}
+
+// newStatement 语句开始，READ_COMMITTED事务刷新readView
+func (tx *Tx) newStatement() {
+	if tx.level == READ_COMMITTED {
+		tx.rv = tx.mgr.currentReadView()
+	}
+}
+
+// checksWriteConflict 提交时是否检查写冲突
+func (tx *Tx) checksWriteConflict() bool {
+	return tx.level != READ_COMMITTED
+}
+
+// recordRead 记录SERIALIZABLE事务读过的record，不存在的record也要记录
+func (tx *Tx) recordRead(tableName string, id int) {
+	if tx.sx != nil {
+		tx.sx.recordRead(tableName, id)
+	}
+}
+
+// recordScan 记录SERIALIZABLE事务遍历过的表
+func (tx *Tx) recordScan(tableName string) {
+	if tx.sx != nil {
+		tx.sx.recordScan(tableName)
+	}
+}
+
+// recordRange 记录SERIALIZABLE事务遍历过的id范围
+func (tx *Tx) recordRange(tableName string, lo, hi int) {
+	if tx.sx != nil {
+		tx.sx.recordRange(tableName, lo, hi)
+	}
+}
@@ -0,0 +1,439 @@
+package IDB
+
+import (
+	"errors"
+	"strconv"
+	"sync"
+	"testing"
+)
+
+var isolationLevels = []IsolationLevel{READ_COMMITTED, REPEATABLE_READ, SNAPSHOT, SERIALIZABLE}
+
+// createAccounts 两个账户，余额都为100
+func createAccounts(t *testing.T) (*idbServer, TxMgr) {
+	server := NewIDBServer()
+	inspector := NewUndoInspector()
+	server.WithOptions(func(option *ServerOptionConfig) {
+		option.inspector = inspector
+	})
+	_, err := server.Exec("CREATE TABLE accounts (id INT PRIMARY KEY, balance INT)")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = server.Exec("INSERT INTO accounts VALUES (1, 100), (2, 100)")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return server, NewTxMgr(server, inspector)
+}
+
+func balanceTx(t *testing.T, server *idbServer, tx *Tx, id int) string {
+	r, err := server.SelectByIDTx(tx, "accounts", id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return r.Value[1]
+}
+
+func sumBalanceTx(t *testing.T, server *idbServer, tx *Tx) (interface{}, interface{}) {
+	rows, err := server.AggregateTx(tx, "accounts", nil, nil, []Agg{{Func: COUNT}, {Func: SUM, Field: "balance"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return rows[0].Values[0], rows[0].Values[1]
+}
+
+func TestDirtyRead(t *testing.T) {
+	for _, level := range isolationLevels {
+		server, tm := createAccounts(t)
+		w := tm.StartTransaction()
+		r := tm.StartTransactionWithOptions(level)
+		err := server.UpdateByIDTx(w, "accounts", map[string]interface{}{"balance": 0}, 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// 任何隔离级别都看不到未提交的修改
+		if b := balanceTx(t, server, r, 1); b != "100" {
+			t.Fatalf("level %v: expected %v got %v", level, "100", b)
+		}
+	}
+}
+
+func TestNonRepeatableRead(t *testing.T) {
+	expected := map[IsolationLevel]string{
+		READ_COMMITTED:  "50",
+		REPEATABLE_READ: "100",
+		SNAPSHOT:        "100",
+		SERIALIZABLE:    "100",
+	}
+	for _, level := range isolationLevels {
+		server, tm := createAccounts(t)
+		w := tm.StartTransaction()
+		r := tm.StartTransactionWithOptions(level)
+		if b := balanceTx(t, server, r, 1); b != "100" {
+			t.Fatalf("level %v: expected %v got %v", level, "100", b)
+		}
+		err := server.UpdateByIDTx(w, "accounts", map[string]interface{}{"balance": 50}, 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = w.Commit()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if b := balanceTx(t, server, r, 1); b != expected[level] {
+			t.Fatalf("level %v: expected %v got %v", level, expected[level], b)
+		}
+	}
+}
+
+func TestPhantom(t *testing.T) {
+	expected := map[IsolationLevel]int{
+		READ_COMMITTED:  3,
+		REPEATABLE_READ: 2,
+		SNAPSHOT:        2,
+		SERIALIZABLE:    2,
+	}
+	for _, level := range isolationLevels {
+		server, tm := createAccounts(t)
+		w := tm.StartTransaction()
+		r := tm.StartTransactionWithOptions(level)
+		if count, _ := sumBalanceTx(t, server, r); count != 2 {
+			t.Fatalf("level %v: expected %v got %v", level, 2, count)
+		}
+		err := server.InsertTx(w, "accounts", []interface{}{3, 100})
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = w.Commit()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if count, _ := sumBalanceTx(t, server, r); count != expected[level] {
+			t.Fatalf("level %v: expected %v got %v", level, expected[level], count)
+		}
+	}
+}
+
+func TestNewerTxInvisible(t *testing.T) {
+	// 读事务开始之后才开始的事务提交的插入、更新以及删除
+	for _, level := range isolationLevels {
+		server, tm := createAccounts(t)
+		r := tm.StartTransactionWithOptions(level)
+		w := tm.StartTransaction()
+		err := server.UpdateByIDTx(w, "accounts", map[string]interface{}{"balance": 0}, 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = server.DeleteByIDTx(w, "accounts", 2)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = server.InsertTx(w, "accounts", []interface{}{3, 30})
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = w.Commit()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		count, sum := sumBalanceTx(t, server, r)
+		if level == READ_COMMITTED {
+			if count != 2 || sum != 30 {
+				t.Fatalf("level %v: unexpected %v %v", level, count, sum)
+			}
+			continue
+		}
+		if count != 2 || sum != 200 {
+			t.Fatalf("level %v: unexpected %v %v", level, count, sum)
+		}
+		if b := balanceTx(t, server, r, 1); b != "100" {
+			t.Fatalf("level %v: expected %v got %v", level, "100", b)
+		}
+		if b := balanceTx(t, server, r, 2); b != "100" {
+			t.Fatalf("level %v: expected %v got %v", level, "100", b)
+		}
+		_, err = server.SelectByIDTx(r, "accounts", 3)
+		if err != ErrKeyNotFound {
+			t.Fatalf("level %v: expected %v got %v", level, ErrKeyNotFound, err)
+		}
+	}
+}
+
+func TestSnapshotDuringCommit(t *testing.T) {
+	server, tm := createAccounts(t)
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		// 在两个账户之间转账，总额不变
+		for i := 0; i < 200; i++ {
+			tx := tm.StartTransaction()
+			from, to := i%2+1, (i+1)%2+1
+			b1, _ := strconv.Atoi(balanceTx(t, server, tx, from))
+			b2, _ := strconv.Atoi(balanceTx(t, server, tx, to))
+			server.UpdateByIDTx(tx, "accounts", map[string]interface{}{"balance": b1 - 1}, from)
+			server.UpdateByIDTx(tx, "accounts", map[string]interface{}{"balance": b2 + 1}, to)
+			tx.Commit()
+		}
+		close(done)
+	}()
+
+	// 提交过程中开始的事务也不会看到只提交了一部分的数据
+	for {
+		select {
+		case <-done:
+			wg.Wait()
+			return
+		default:
+		}
+		r := tm.StartTransactionWithOptions(REPEATABLE_READ)
+		if _, sum := sumBalanceTx(t, server, r); sum != 200 {
+			t.Fatalf("expected %v got %v", 200, sum)
+		}
+		b1, _ := strconv.Atoi(balanceTx(t, server, r, 1))
+		b2, _ := strconv.Atoi(balanceTx(t, server, r, 2))
+		if b1+b2 != 200 {
+			t.Fatalf("expected %v got %v", 200, b1+b2)
+		}
+		r.Commit()
+	}
+}
+
+func TestLostUpdate(t *testing.T) {
+	for _, level := range isolationLevels {
+		server, tm := createAccounts(t)
+		tx1 := tm.StartTransactionWithOptions(level)
+		tx2 := tm.StartTransactionWithOptions(level)
+		// 两个事务都读到100后各自存入
+		balanceTx(t, server, tx1, 1)
+		balanceTx(t, server, tx2, 1)
+		err := server.UpdateByIDTx(tx1, "accounts", map[string]interface{}{"balance": 110}, 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = server.UpdateByIDTx(tx2, "accounts", map[string]interface{}{"balance": 120}, 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = tx1.Commit()
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = tx2.Commit()
+
+		r, _ := server.SelectByID("accounts", 1)
+		if level == READ_COMMITTED {
+			// tx1的更新丢失
+			if err != nil || r.Value[1] != "120" {
+				t.Fatalf("level %v: unexpected %v %v", level, err, r.Value[1])
+			}
+			continue
+		}
+		if !errors.Is(err, ErrWriteConflict) || r.Value[1] != "110" {
+			t.Fatalf("level %v: unexpected %v %v", level, err, r.Value[1])
+		}
+	}
+}
+
+func TestUpdateDeletedRecord(t *testing.T) {
+	// 更新的record被并发的事务删除，任何隔离级别下提交都失败，其他更新被撤销
+	for _, level := range isolationLevels {
+		server, tm := createAccounts(t)
+		tx := tm.StartTransactionWithOptions(level)
+		for _, id := range []int{1, 2} {
+			err := server.UpdateByIDTx(tx, "accounts", map[string]interface{}{"balance": 0}, id)
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+		err := server.DeleteByID("accounts", 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = tx.Commit()
+		var we *WriteConflictError
+		if !errors.As(err, &we) || we.Table != "accounts" || we.ID != 1 {
+			t.Fatalf("level %v: unexpected %v", level, err)
+		}
+		if b := balanceTx(t, server, tm.StartTransaction(), 2); b != "100" {
+			t.Fatalf("level %v: expected %v got %v", level, "100", b)
+		}
+	}
+}
+
+func TestWriteSkew(t *testing.T) {
+	// 两个账户余额之和不小于150时才能从其中一个账户取出150，point为false时通过遍历读取
+	for _, point := range []bool{true, false} {
+		for _, level := range isolationLevels {
+			server, tm := createAccounts(t)
+			tx1 := tm.StartTransactionWithOptions(level)
+			tx2 := tm.StartTransactionWithOptions(level)
+			for i, tx := range []*Tx{tx1, tx2} {
+				if point {
+					balanceTx(t, server, tx, 1)
+					balanceTx(t, server, tx, 2)
+				} else if _, sum := sumBalanceTx(t, server, tx); sum != 200 {
+					t.Fatalf("level %v: expected %v got %v", level, 200, sum)
+				}
+				err := server.UpdateByIDTx(tx, "accounts", map[string]interface{}{"balance": -50}, i+1)
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+			err := tx1.Commit()
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = tx2.Commit()
+			if level == SERIALIZABLE {
+				if err != ErrSerializationFailure {
+					t.Fatalf("level %v: expected %v got %v", level, ErrSerializationFailure, err)
+				}
+				continue
+			}
+			if err != nil {
+				t.Fatalf("level %v: %v", level, err)
+			}
+		}
+	}
+
+	// 只读事务和没有读过被修改数据的事务可以提交
+	server, tm := createAccounts(t)
+	tx1 := tm.StartTransactionWithOptions(SERIALIZABLE)
+	tx2 := tm.StartTransactionWithOptions(SERIALIZABLE)
+	tx3 := tm.StartTransactionWithOptions(SERIALIZABLE)
+	balanceTx(t, server, tx2, 1)
+	balanceTx(t, server, tx3, 2)
+	err := server.UpdateByIDTx(tx1, "accounts", map[string]interface{}{"balance": 0}, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = server.UpdateByIDTx(tx3, "accounts", map[string]interface{}{"balance": 0}, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tx := range []*Tx{tx1, tx2, tx3} {
+		if err = tx.Commit(); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestSerializableFailedCommit(t *testing.T) {
+	// tx1执行操作时失败，不能作为已经提交的事务导致tx2无法序列化
+	server := NewIDBServer()
+	err := createPeopleTable(server)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tm := server.TxMgr()
+	tx1 := tm.StartTransactionWithOptions(SERIALIZABLE)
+	tx2 := tm.StartTransactionWithOptions(SERIALIZABLE)
+	for i, tx := range []*Tx{tx1, tx2} {
+		_, err = server.SelectByIDTx(tx, "people", i+1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = server.UpdateByIDTx(tx, "people", map[string]interface{}{"age": 0}, 2-i)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	// 检查时发现不了的冲突，插入时才失败
+	tx1.cache["people"].cache[3] = &OpRecord{
+		op:       INSERT,
+		opChange: &InsertOpChange{record: &Record{Key: 3, Value: []string{"x", "1", "y"}, Meta: &RecordMeta{LastTxID: tx1.id}}},
+	}
+	err = tx1.Commit()
+	if err != ErrKeyExists {
+		t.Fatalf("expected %v got %v", ErrKeyExists, err)
+	}
+
+	err = tx2.Commit()
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSerializableReadOnlyAnomaly(t *testing.T) {
+	// 1为支票账户，2为储蓄账户。tx2取款时两个账户之和不够就多扣1作为罚金，tx1向储蓄账户存款，tx3只读两个账户
+	for _, seesDeposit := range []bool{false, true} {
+		server, tm := createAccounts(t)
+		tx2 := tm.StartTransactionWithOptions(SERIALIZABLE)
+		tx1 := tm.StartTransactionWithOptions(SERIALIZABLE)
+		balanceTx(t, server, tx2, 1)
+		balanceTx(t, server, tx2, 2)
+		balanceTx(t, server, tx1, 2)
+		var tx3 *Tx
+		if !seesDeposit {
+			// tx3在tx1提交前开始，看不到tx1的存款
+			tx3 = tm.StartTransactionWithOptions(SERIALIZABLE, TxOptions{ReadOnly: true})
+		}
+		err := server.UpdateByIDTx(tx1, "accounts", map[string]interface{}{"balance": 120}, 2)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = tx1.Commit()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if seesDeposit {
+			// tx3在tx1提交后开始，看到存款却看不到取款，tx2取款时不能再按没有存款处理
+			tx3 = tm.StartTransactionWithOptions(SERIALIZABLE, TxOptions{ReadOnly: true})
+		}
+		balanceTx(t, server, tx3, 1)
+		balanceTx(t, server, tx3, 2)
+		err = tx3.Commit()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		err = server.UpdateByIDTx(tx2, "accounts", map[string]interface{}{"balance": -101}, 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = tx2.Commit()
+		if seesDeposit && err != ErrSerializationFailure {
+			t.Fatalf("expected %v got %v", ErrSerializationFailure, err)
+		}
+		if !seesDeposit && err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestReadOnlyTx(t *testing.T) {
+	server, tm := createAccounts(t)
+	ro := tm.StartTransactionWithOptions(SERIALIZABLE, TxOptions{ReadOnly: true})
+	err := server.UpdateByIDTx(ro, "accounts", map[string]interface{}{"balance": 0}, 1)
+	if err != ErrReadOnlyTx {
+		t.Fatalf("expected %v got %v", ErrReadOnlyTx, err)
+	}
+	err = server.InsertTx(ro, "accounts", []interface{}{3, 100})
+	if err != ErrReadOnlyTx {
+		t.Fatalf("expected %v got %v", ErrReadOnlyTx, err)
+	}
+	// 没有并发的读写事务时快照是安全的，不需要跟踪
+	if ro.sx != nil {
+		t.Fatal("expected safe snapshot")
+	}
+	rw := tm.StartTransactionWithOptions(SERIALIZABLE)
+	ro2 := tm.StartTransactionWithOptions(SERIALIZABLE, TxOptions{ReadOnly: true})
+	if ro2.sx == nil {
+		t.Fatal("expected tracked read only tx")
+	}
+	for _, tx := range []*Tx{ro, rw, ro2} {
+		sumBalanceTx(t, server, tx)
+		if err = tx.Commit(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// 所有事务结束后不再跟踪
+	ssi := tm.(*TxMgrImpl).ssi
+	if len(ssi.sxacts) != 0 {
+		t.Fatalf("expected %v got %v", 0, len(ssi.sxacts))
+	}
+}
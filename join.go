@@ -0,0 +1,259 @@
+package IDB
+
+import (
+	"errors"
+	"strconv"
+)
+
+var (
+	ErrInvalidJoin = errors.New("storage: invalid join")
+)
+
+type JoinType int
+
+const (
+	INNER_JOIN JoinType = iota
+	// LEFT_JOIN 左表没有匹配的数据时Right为nil
+	LEFT_JOIN
+)
+
+// JoinQuery 两表等值连接，Left表的连接字段等于Right表的连接字段
+type JoinQuery struct {
+	Type  JoinType
+	Left  JoinTable
+	Right JoinTable
+}
+
+// JoinTable 参与连接的表
+type JoinTable struct {
+	Table string
+	// 连接字段，为空时使用record id
+	Field string
+	// 下推到该表的过滤条件
+	Filter Expr
+	// 返回的字段，为空时返回所有字段
+	Fields []string
+}
+
+// JoinRow 连接结果中的一行
+type JoinRow struct {
+	Left  *Record
+	Right *Record
+}
+
+// joinSide 绑定表结构之后的JoinTable
+type joinSide struct {
+	name     string
+	t        *table
+	fields   []*FieldMeta
+	key      *FieldMeta
+	filter   Expr
+	isTarget IsTarget
+	project  []*FieldMeta
+}
+
+func (s *idbServer) bindJoinTable(jt JoinTable) (*joinSide, error) {
+	t, err := s.getTable(jt.Table)
+	if err != nil {
+		return nil, err
+	}
+	side := &joinSide{
+		name:   jt.Table,
+		t:      t,
+		fields: t.meta.getFields(),
+		filter: jt.Filter,
+	}
+	if jt.Field != "" {
+		side.key, err = exprField(side.fields, jt.Field)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if side.filter == nil {
+		side.filter = And()
+	}
+	side.isTarget, err = bindTarget(side.filter, side.fields)
+	if err != nil {
+		return nil, err
+	}
+	side.project, err = projection(side.fields, jt.Fields)
+	if err != nil {
+		return nil, err
+	}
+	if len(side.project) == 0 {
+		side.project = side.fields
+	}
+	return side, nil
+}
+
+func (side *joinSide) keyType() fieldType {
+	if side.key == nil {
+		return INT
+	}
+	return side.key.tp
+}
+
+// keyOf 连接字段的值，为空时不参与连接
+func (side *joinSide) keyOf(r *Record) string {
+	if side.key == nil {
+		return strconv.Itoa(r.Key)
+	}
+	return fieldValue(side.key, r)
+}
+
+// byID 连接字段是否就是b+树的key
+func (side *joinSide) byID() bool {
+	return side.key == nil || (side.key.isPrimaryKey && side.key.tp == INT)
+}
+
+// scanJoinSide 遍历满足过滤条件的record，tx不为nil时遍历事务可见的record
+func (s *idbServer) scanJoinSide(tx *Tx, side *joinSide, fn func(r *Record) bool) error {
+	if tx != nil {
+		return s.scanTx(tx, side.t, side.name, func(r *Record) bool {
+			return !side.isTarget(r) || fn(r)
+		})
+	}
+
+	// 先收集再处理，fn中可能再查找同一个表，不能在遍历时持有读锁
+	var rs []*Record
+	side.t.scan(side.fields, side.filter, func(r *Record) bool {
+		if side.isTarget(r) {
+			rs = append(rs, r)
+		}
+		return true
+	})
+	for _, r := range rs {
+		if !fn(r) {
+			break
+		}
+	}
+	return nil
+}
+
+// Join 连接两个表。右表的连接字段是INT主键或者id时逐行通过b+树查找，是STRING主键时通过主键索引查找，否则使用哈希连接
+func (s *idbServer) Join(q *JoinQuery) ([]*JoinRow, error) {
+	return s.join(nil, q)
+}
+
+// JoinTx 在事务快照上连接两个表，包括事务中未提交的修改
+func (s *idbServer) JoinTx(tx *Tx, q *JoinQuery) ([]*JoinRow, error) {
+	tx.newStatement()
+	return s.join(tx, q)
+}
+
+func (s *idbServer) join(tx *Tx, q *JoinQuery) ([]*JoinRow, error) {
+	if q.Type != INNER_JOIN && q.Type != LEFT_JOIN {
+		return nil, ErrInvalidJoin
+	}
+	left, err := s.bindJoinTable(q.Left)
+	if err != nil {
+		return nil, err
+	}
+	right, err := s.bindJoinTable(q.Right)
+	if err != nil {
+		return nil, err
+	}
+	if left.keyType() != right.keyType() {
+		return nil, &FieldError{Field: q.Right.Field, Err: ErrMismatchFieldType}
+	}
+
+	var lookup func(key string) ([]*Record, error)
+	switch {
+	case right.byID():
+		lookup = s.idLookup(tx, right)
+	case right.key.isPrimaryKey && tx == nil:
+		lookup = pkLookup(right)
+	default:
+		lookup, err = s.hashLookup(tx, right)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	var rows []*JoinRow
+	var lookupErr error
+	err = s.scanJoinSide(tx, left, func(l *Record) bool {
+		var matches []*Record
+		if key := left.keyOf(l); key != "" {
+			matches, lookupErr = lookup(key)
+			if lookupErr != nil {
+				return false
+			}
+		}
+		lr := projectRecord(left.project, l)
+		for _, r := range matches {
+			rows = append(rows, &JoinRow{Left: lr, Right: projectRecord(right.project, r)})
+		}
+		if len(matches) == 0 && q.Type == LEFT_JOIN {
+			rows = append(rows, &JoinRow{Left: lr})
+		}
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+	if lookupErr != nil {
+		return nil, lookupErr
+	}
+	return rows, nil
+}
+
+// idLookup 嵌套循环连接，通过id在b+树中查找
+func (s *idbServer) idLookup(tx *Tx, side *joinSide) func(key string) ([]*Record, error) {
+	return func(key string) ([]*Record, error) {
+		id, err := strconv.Atoi(key)
+		if err != nil {
+			return nil, nil
+		}
+		var r *Record
+		if tx == nil {
+			r, err = side.t.data.Find(id)
+		} else {
+			r, err = s.selectByIDTx(tx, side.t, side.name, id)
+		}
+		if err == ErrKeyNotFound {
+			return nil, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		if !side.isTarget(r) {
+			return nil, nil
+		}
+		return []*Record{r}, nil
+	}
+}
+
+// pkLookup 嵌套循环连接，通过STRING主键索引查找。事务中主键索引不包含未提交的修改，不能使用
+func pkLookup(side *joinSide) func(key string) ([]*Record, error) {
+	return func(key string) ([]*Record, error) {
+		var rs []*Record
+		for _, id := range side.t.pkIndex.lookup(key) {
+			r, err := side.t.data.Find(id)
+			if err != nil {
+				continue
+			}
+			if side.isTarget(r) {
+				rs = append(rs, r)
+			}
+		}
+		return rs, nil
+	}
+}
+
+// hashLookup 哈希连接，用满足过滤条件的record建立哈希表
+func (s *idbServer) hashLookup(tx *Tx, side *joinSide) (func(key string) ([]*Record, error), error) {
+	buckets := make(map[string][]*Record)
+	err := s.scanJoinSide(tx, side, func(r *Record) bool {
+		if key := side.keyOf(r); key != "" {
+			buckets[key] = append(buckets[key], r)
+		}
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+	return func(key string) ([]*Record, error) {
+		return buckets[key], nil
+	}, nil
+}
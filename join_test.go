@@ -0,0 +1,179 @@
+package IDB
+
+import (
+	"errors"
+	"testing"
+)
+
+func createJoinTables(server *idbServer) error {
+	err := createOrderTables(server, RESTRICT)
+	if err != nil {
+		return err
+	}
+	server.CreateTable("customers", []*FieldMeta{
+		{name: "customer_id", isPrimaryKey: true, tp: INT},
+		{name: "name", tp: STRING},
+	})
+	server.AddColumn("orders", &FieldMeta{name: "customer_id", tp: INT}, nil)
+
+	server.Insert("customers", []interface{}{1, "alice"})
+	server.Insert("customers", []interface{}{2, "bob"})
+	server.Insert("orders", []interface{}{"SO-001", 1})
+	server.Insert("orders", []interface{}{"SO-002", 2})
+	server.Insert("orders", []interface{}{"SO-003", 9})
+	server.Insert("order_lines", []interface{}{"SO-001", "apple"})
+	server.Insert("order_lines", []interface{}{"SO-001", "pear"})
+	return server.Insert("order_lines", []interface{}{"SO-002", "grape"})
+}
+
+func joinValues(rows []*JoinRow, leftSlot, rightSlot int) [][2]string {
+	values := make([][2]string, len(rows))
+	for i, row := range rows {
+		values[i][0] = row.Left.Value[leftSlot]
+		if row.Right != nil {
+			values[i][1] = row.Right.Value[rightSlot]
+		}
+	}
+	return values
+}
+
+func TestJoin(t *testing.T) {
+	server := NewIDBServer()
+	err := createJoinTables(server)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 右表连接字段为INT主键
+	rows, err := server.Join(&JoinQuery{
+		Type:  LEFT_JOIN,
+		Left:  JoinTable{Table: "orders", Field: "customer_id"},
+		Right: JoinTable{Table: "customers", Field: "customer_id", Fields: []string{"name"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	values := joinValues(rows, 0, 0)
+	expect := [][2]string{{"SO-001", "alice"}, {"SO-002", "bob"}, {"SO-003", ""}}
+	if len(values) != len(expect) {
+		t.Fatalf("expected %v got %v", expect, values)
+	}
+	for i := range expect {
+		if values[i] != expect[i] {
+			t.Fatalf("expected %v got %v", expect, values)
+		}
+	}
+
+	// 右表连接字段为STRING主键，过滤条件下推
+	rows, err = server.Join(&JoinQuery{
+		Type:  INNER_JOIN,
+		Left:  JoinTable{Table: "order_lines", Field: "order_no", Filter: Ne("sku", "pear"), Fields: []string{"sku"}},
+		Right: JoinTable{Table: "orders", Field: "order_no", Filter: Eq("customer_id", 2)},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	values = joinValues(rows, 0, 0)
+	if len(values) != 1 || values[0] != [2]string{"grape", "SO-002"} {
+		t.Fatalf("unexpected %v", values)
+	}
+
+	// 右表连接字段没有主键，使用哈希连接
+	rows, err = server.Join(&JoinQuery{
+		Type:  LEFT_JOIN,
+		Left:  JoinTable{Table: "orders", Field: "order_no"},
+		Right: JoinTable{Table: "order_lines", Field: "order_no"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	values = joinValues(rows, 0, 1)
+	expect = [][2]string{{"SO-001", "apple"}, {"SO-001", "pear"}, {"SO-002", "grape"}, {"SO-003", ""}}
+	if len(values) != len(expect) {
+		t.Fatalf("expected %v got %v", expect, values)
+	}
+	for i := range expect {
+		if values[i] != expect[i] {
+			t.Fatalf("expected %v got %v", expect, values)
+		}
+	}
+
+	_, err = server.Join(&JoinQuery{
+		Left:  JoinTable{Table: "orders", Field: "order_no"},
+		Right: JoinTable{Table: "customers", Field: "customer_id"},
+	})
+	if !errors.Is(err, ErrMismatchFieldType) {
+		t.Fatalf("expected %v got %v", ErrMismatchFieldType, err)
+	}
+	_, err = server.Join(&JoinQuery{
+		Left:  JoinTable{Table: "orders", Field: "customer"},
+		Right: JoinTable{Table: "customers", Field: "customer_id"},
+	})
+	if !errors.Is(err, ErrFieldNotExist) {
+		t.Fatalf("expected %v got %v", ErrFieldNotExist, err)
+	}
+}
+
+func TestJoinTx(t *testing.T) {
+	server := NewIDBServer()
+	inspector := NewUndoInspector()
+	server.WithOptions(func(option *ServerOptionConfig) {
+		option.inspector = inspector
+	})
+	err := createJoinTables(server)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tm := NewTxMgr(server, inspector)
+
+	// 事务中的修改参与连接，包括STRING主键
+	tx := tm.StartTransaction()
+	err = server.InsertTx(tx, "orders", []interface{}{"SO-004", 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = server.InsertTx(tx, "order_lines", []interface{}{"SO-004", "melon"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = server.UpdateByIDTx(tx, "customers", map[string]interface{}{"name": "alice2"}, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rows, err := server.JoinTx(tx, &JoinQuery{
+		Left:  JoinTable{Table: "order_lines", Field: "order_no", Filter: Eq("sku", "melon")},
+		Right: JoinTable{Table: "orders", Field: "order_no"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rows) != 1 || rows[0].Right.Value[0] != "SO-004" {
+		t.Fatalf("unexpected %v", rows)
+	}
+	rows, err = server.JoinTx(tx, &JoinQuery{
+		Left:  JoinTable{Table: "orders", Field: "customer_id", Filter: Eq("customer_id", 1)},
+		Right: JoinTable{Table: "customers", Field: "customer_id"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	values := joinValues(rows, 0, 1)
+	if len(values) != 2 || values[0] != [2]string{"SO-001", "alice2"} || values[1] != [2]string{"SO-004", "alice2"} {
+		t.Fatalf("unexpected %v", values)
+	}
+
+	// 事务外看不到未提交的修改
+	rows, err = server.Join(&JoinQuery{
+		Left:  JoinTable{Table: "orders", Field: "customer_id", Filter: Eq("customer_id", 1)},
+		Right: JoinTable{Table: "customers", Field: "customer_id"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	values = joinValues(rows, 0, 1)
+	if len(values) != 1 || values[0] != [2]string{"SO-001", "alice"} {
+		t.Fatalf("unexpected %v", values)
+	}
+	tx.Rollback()
+}
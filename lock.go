@@ -0,0 +1,414 @@
+package IDB
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"sync"
+	"sync/atomic"
+)
+
+var (
+	ErrDeadlock    = errors.New("transaction: deadlock detected")
+	ErrLockTimeout = errors.New("transaction: lock wait timeout")
+	ErrInvalidLock = errors.New("transaction: invalid lock mode")
+)
+
+// LockMode 行锁模式
+type LockMode int
+
+const (
+	SHARED_LOCK LockMode = iota + 1
+	EXCLUSIVE_LOCK
+)
+
+// compatible 两个事务是否可以同时持有
+func (m LockMode) compatible(other LockMode) bool {
+	return m == SHARED_LOCK && other == SHARED_LOCK
+}
+
+type rowKey struct {
+	table string
+	id    int
+}
+
+// rowLock 一行的持有者以及按请求顺序排队的等待者
+type rowLock struct {
+	holders map[int]LockMode
+	queue   []*lockRequest
+}
+
+type lockRequest struct {
+	txID int
+	key  rowKey
+	mode LockMode
+	// 获得锁时为nil，被选为死锁的牺牲者时为ErrDeadlock
+	done chan error
+}
+
+// lockManager 行锁管理。显式加的行锁持有到事务提交或者回滚，事务提交以及不在事务中按id写入前
+// 也要获得修改的行的排他锁，加锁的行不会被其他写入修改。
+// 等待者按请求顺序获得锁，每次排队时检查等待图，出现环时中止环中最晚开始的事务
+type lockManager struct {
+	mu   sync.Mutex
+	rows map[rowKey]*rowLock
+	// 事务持有锁的行
+	held map[int]map[rowKey]bool
+	// 事务正在等待的请求，一个事务同时只会等待一个请求
+	waiting map[int]*lockRequest
+}
+
+func newLockManager() *lockManager {
+	return &lockManager{
+		rows:    make(map[rowKey]*rowLock),
+		held:    make(map[int]map[rowKey]bool),
+		waiting: make(map[int]*lockRequest),
+	}
+}
+
+// acquire 获取行锁，已经持有共享锁时升级为排他锁。ctx结束时放弃等待
+func (lm *lockManager) acquire(ctx context.Context, txID int, key rowKey, mode LockMode) error {
+	lm.mu.Lock()
+	rl, ok := lm.rows[key]
+	if !ok {
+		rl = &rowLock{holders: make(map[int]LockMode)}
+		lm.rows[key] = rl
+	}
+	held, upgrade := rl.holders[txID]
+	if upgrade && (held == EXCLUSIVE_LOCK || held == mode) {
+		lm.mu.Unlock()
+		return nil
+	}
+	// 升级时不用排在其他等待者后面，否则一定会死锁
+	if (upgrade || len(rl.queue) == 0) && rl.grantable(txID, mode) {
+		lm.grant(rl, txID, key, mode)
+		lm.mu.Unlock()
+		return nil
+	}
+
+	req := &lockRequest{txID: txID, key: key, mode: mode, done: make(chan error, 1)}
+	if upgrade {
+		rl.queue = append([]*lockRequest{req}, rl.queue...)
+	} else {
+		rl.queue = append(rl.queue, req)
+	}
+	lm.waiting[txID] = req
+	// 没有持有其他锁时没有事务在等待它，不会形成环。不在事务中的写入排队时不用遍历等待图
+	if len(lm.held[txID]) > 0 {
+		if cycle := lm.findCycle(txID); cycle != nil {
+			lm.abort(lm.waiting[victim(cycle)], ErrDeadlock)
+		}
+	}
+	lm.mu.Unlock()
+
+	select {
+	case err := <-req.done:
+		return err
+	case <-ctx.Done():
+	}
+
+	lm.mu.Lock()
+	defer lm.mu.Unlock()
+	select {
+	case err := <-req.done:
+		// 放弃等待前已经有结果
+		return err
+	default:
+	}
+	lm.abort(req, nil)
+	if ctx.Err() == context.DeadlineExceeded {
+		return ErrLockTimeout
+	}
+	return ctx.Err()
+}
+
+// grantable 除了txID之外的持有者都与mode兼容
+func (rl *rowLock) grantable(txID int, mode LockMode) bool {
+	for holder, m := range rl.holders {
+		if holder != txID && !mode.compatible(m) {
+			return false
+		}
+	}
+	return true
+}
+
+func (lm *lockManager) grant(rl *rowLock, txID int, key rowKey, mode LockMode) {
+	rl.holders[txID] = mode
+	if lm.held[txID] == nil {
+		lm.held[txID] = make(map[rowKey]bool)
+	}
+	lm.held[txID][key] = true
+}
+
+// abort 从队列中移除请求，err不为nil时通知等待者
+func (lm *lockManager) abort(req *lockRequest, err error) {
+	rl := lm.rows[req.key]
+	for i, r := range rl.queue {
+		if r == req {
+			rl.queue = append(rl.queue[:i], rl.queue[i+1:]...)
+			break
+		}
+	}
+	delete(lm.waiting, req.txID)
+	if err != nil {
+		req.done <- err
+	}
+	lm.wake(req.key)
+}
+
+// wake 按顺序让队列头部可以获得锁的等待者获得锁
+func (lm *lockManager) wake(key rowKey) {
+	rl := lm.rows[key]
+	for len(rl.queue) > 0 {
+		req := rl.queue[0]
+		if !rl.grantable(req.txID, req.mode) {
+			break
+		}
+		rl.queue = rl.queue[1:]
+		delete(lm.waiting, req.txID)
+		lm.grant(rl, req.txID, key, req.mode)
+		req.done <- nil
+	}
+	if len(rl.holders) == 0 && len(rl.queue) == 0 {
+		delete(lm.rows, key)
+	}
+}
+
+// releaseAll 释放事务持有的所有行锁
+func (lm *lockManager) releaseAll(txID int) {
+	lm.mu.Lock()
+	defer lm.mu.Unlock()
+
+	if req, ok := lm.waiting[txID]; ok {
+		lm.abort(req, nil)
+	}
+	for key := range lm.held[txID] {
+		delete(lm.rows[key].holders, txID)
+		lm.wake(key)
+	}
+	delete(lm.held, txID)
+}
+
+// heldLocks 事务持有的行锁以及模式
+func (lm *lockManager) heldLocks(txID int) map[rowKey]LockMode {
+	lm.mu.Lock()
+	defer lm.mu.Unlock()
+
+	locks := make(map[rowKey]LockMode, len(lm.held[txID]))
+	for key := range lm.held[txID] {
+		locks[key] = lm.rows[key].holders[txID]
+	}
+	return locks
+}
+
+// releaseExcept 释放keep之外的行锁，keep中为共享锁的排他锁降级为共享锁
+func (lm *lockManager) releaseExcept(txID int, keep map[rowKey]LockMode) {
+	lm.mu.Lock()
+	defer lm.mu.Unlock()
+
+	for key := range lm.held[txID] {
+		mode, ok := keep[key]
+		if ok && lm.rows[key].holders[txID] == mode {
+			continue
+		}
+		if ok {
+			lm.rows[key].holders[txID] = mode
+		} else {
+			delete(lm.rows[key].holders, txID)
+			delete(lm.held[txID], key)
+		}
+		lm.wake(key)
+	}
+}
+
+// waitsFor 等待者在等待的事务：不兼容的持有者以及排在前面的不兼容的等待者
+func (lm *lockManager) waitsFor(req *lockRequest) []int {
+	rl := lm.rows[req.key]
+	var txIDs []int
+	for holder, m := range rl.holders {
+		if holder != req.txID && !req.mode.compatible(m) {
+			txIDs = append(txIDs, holder)
+		}
+	}
+	for _, r := range rl.queue {
+		if r == req {
+			break
+		}
+		if r.txID != req.txID && !req.mode.compatible(r.mode) {
+			txIDs = append(txIDs, r.txID)
+		}
+	}
+	return txIDs
+}
+
+// findCycle 等待图中从txID出发回到txID的环，没有时返回nil
+func (lm *lockManager) findCycle(txID int) []int {
+	var path []int
+	visited := make(map[int]bool)
+	var dfs func(id int) bool
+	dfs = func(id int) bool {
+		req, ok := lm.waiting[id]
+		if !ok {
+			return false
+		}
+		path = append(path, id)
+		for _, next := range lm.waitsFor(req) {
+			if next == txID {
+				return true
+			}
+			if !visited[next] {
+				visited[next] = true
+				if dfs(next) {
+					return true
+				}
+			}
+		}
+		path = path[:len(path)-1]
+		return false
+	}
+	if dfs(txID) {
+		return path
+	}
+	return nil
+}
+
+// victim 环中最晚开始的事务
+func victim(cycle []int) int {
+	v := cycle[0]
+	for _, id := range cycle[1:] {
+		if id > v {
+			v = id
+		}
+	}
+	return v
+}
+
+// LockRowTx 在事务中给一行加锁，等待超过事务的LockTimeout时返回ErrLockTimeout。
+// 返回ErrDeadlock时事务已经释放所有行锁，提交时返回ErrDeadlock
+func (s *idbServer) LockRowTx(tx *Tx, tableName string, id int, mode LockMode) error {
+	return s.LockRowTxContext(context.Background(), tx, tableName, id, mode)
+}
+
+// LockRowTxContext 同LockRowTx，ctx结束时放弃等待
+func (s *idbServer) LockRowTxContext(ctx context.Context, tx *Tx, tableName string, id int, mode LockMode) error {
+	if mode != SHARED_LOCK && mode != EXCLUSIVE_LOCK {
+		return ErrInvalidLock
+	}
+	if tx.abortErr != nil {
+		return tx.abortErr
+	}
+	if _, err := s.getTable(tableName); err != nil {
+		return err
+	}
+	return tx.lockRow(ctx, rowKey{table: tableName, id: id}, mode)
+}
+
+// lockRow 获取行锁，等待时间不超过事务的LockTimeout。死锁时释放事务的所有行锁，之后提交返回ErrDeadlock
+func (tx *Tx) lockRow(ctx context.Context, key rowKey, mode LockMode) error {
+	if tx.lockTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, tx.lockTimeout)
+		defer cancel()
+	}
+	err := tx.locks.acquire(ctx, tx.id, key, mode)
+	if err == ErrDeadlock {
+		tx.abortErr = err
+		tx.locks.releaseAll(tx.id)
+	}
+	return err
+}
+
+// lockWriteSet 提交前按表名和id的顺序给事务修改的行加排他锁，其他事务加锁的行等它们结束后才能写入
+func (tx *Tx) lockWriteSet() error {
+	names := make([]string, 0, len(tx.cache))
+	for name := range tx.cache {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		ids := make([]int, 0, len(tx.cache[name].cache))
+		for id := range tx.cache[name].cache {
+			ids = append(ids, id)
+		}
+		sort.Ints(ids)
+		for _, id := range ids {
+			if err := tx.lockRow(context.Background(), rowKey{table: name, id: id}, EXCLUSIVE_LOCK); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// lockRowForWrite 不在事务中按id写入前获得该行的排他锁，返回释放锁的函数。
+// 使用一个不会开始的事务id加锁，它不是活跃事务，不影响readView
+func (s *idbServer) lockRowForWrite(tableName string, id int) (func(), error) {
+	tm, ok := s.TxMgr().(*TxMgrImpl)
+	if !ok {
+		return func() {}, nil
+	}
+	txID := int(atomic.AddInt64(&tm.txIDCounter, 1))
+	err := tm.locks.acquire(context.Background(), txID, rowKey{table: tableName, id: id}, EXCLUSIVE_LOCK)
+	if err != nil {
+		tm.locks.releaseAll(txID)
+		return nil, err
+	}
+	return func() {
+		tm.locks.releaseAll(txID)
+	}, nil
+}
+
+// SelectByIDForUpdateTx 给一行加排他锁后查询。READ_COMMITTED事务读取加锁后最新提交的数据，
+// 其他隔离级别在该行于事务开始后被其他事务修改时返回WriteConflictError
+func (s *idbServer) SelectByIDForUpdateTx(tx *Tx, tableName string, id int) (*Record, error) {
+	return s.SelectByIDForUpdateTxContext(context.Background(), tx, tableName, id)
+}
+
+// SelectByIDForUpdateTxContext 同SelectByIDForUpdateTx，ctx结束时放弃等待
+func (s *idbServer) SelectByIDForUpdateTxContext(ctx context.Context, tx *Tx, tableName string, id int) (*Record, error) {
+	t, err := s.lockRowsTx(ctx, tx, tableName, []int{id})
+	if err != nil {
+		return nil, err
+	}
+	record, err := s.selectByIDTx(tx, t, tableName, id)
+	if err != nil {
+		return nil, err
+	}
+	return t.meta.materialize(record), nil
+}
+
+// lockRowsTx 给多行加排他锁，之后READ_COMMITTED事务刷新readView，其他隔离级别检查是否有写冲突
+func (s *idbServer) lockRowsTx(ctx context.Context, tx *Tx, tableName string, ids []int) (*table, error) {
+	for _, id := range ids {
+		if err := s.LockRowTxContext(ctx, tx, tableName, id, EXCLUSIVE_LOCK); err != nil {
+			return nil, err
+		}
+	}
+	t, err := s.getTable(tableName)
+	if err != nil {
+		return nil, err
+	}
+	tx.newStatement()
+	if tx.level == READ_COMMITTED {
+		return t, nil
+	}
+	for _, id := range ids {
+		if s.changedSinceSnapshot(tx, t, tableName, id) {
+			return nil, &WriteConflictError{Table: tableName, ID: id}
+		}
+	}
+	return t, nil
+}
+
+// changedSinceSnapshot 最新提交的数据在事务开始后被其他事务修改或者删除
+func (s *idbServer) changedSinceSnapshot(tx *Tx, t *table, tableName string, id int) bool {
+	if c, ok := tx.cache[tableName]; ok && c.cache[id] != nil {
+		return false
+	}
+	r, err := t.data.Find(id)
+	if err == nil {
+		return !tx.rv.visible(r.Meta.LastTxID)
+	}
+	_, err = s.findVisibleTx(tx, t, tableName, id)
+	return err == nil
+}
@@ -0,0 +1,293 @@
+package IDB
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestRowLock(t *testing.T) {
+	server, tm := createAccounts(t)
+	tx1 := tm.StartTransaction()
+	tx2 := tm.StartTransactionWithOptions(SNAPSHOT, TxOptions{LockTimeout: 10 * time.Millisecond})
+
+	// 共享锁之间兼容
+	err := server.LockRowTx(tx1, "accounts", 1, SHARED_LOCK)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = server.LockRowTx(tx2, "accounts", 1, SHARED_LOCK)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = server.LockRowTx(tx2, "accounts", 1, EXCLUSIVE_LOCK)
+	if err != ErrLockTimeout {
+		t.Fatalf("expected %v got %v", ErrLockTimeout, err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	err = server.LockRowTxContext(ctx, tx2, "accounts", 2, SHARED_LOCK)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = server.LockRowTxContext(ctx, tx1, "accounts", 2, EXCLUSIVE_LOCK)
+	if err != context.Canceled {
+		t.Fatalf("expected %v got %v", context.Canceled, err)
+	}
+	err = server.LockRowTx(tx1, "accounts", 1, 0)
+	if err != ErrInvalidLock {
+		t.Fatalf("expected %v got %v", ErrInvalidLock, err)
+	}
+	tx2.Rollback()
+
+	// tx1升级为排他锁后，等待者按请求顺序获得锁
+	err = server.LockRowTx(tx1, "accounts", 1, EXCLUSIVE_LOCK)
+	if err != nil {
+		t.Fatal(err)
+	}
+	order := make(chan int, 3)
+	var wg sync.WaitGroup
+	for i, mode := range []LockMode{EXCLUSIVE_LOCK, SHARED_LOCK, SHARED_LOCK} {
+		tx := tm.StartTransaction()
+		wg.Add(1)
+		go func(i int, mode LockMode) {
+			defer wg.Done()
+			if err := server.LockRowTx(tx, "accounts", 1, mode); err != nil {
+				t.Error(err)
+			}
+			order <- i
+			if mode == EXCLUSIVE_LOCK {
+				time.Sleep(10 * time.Millisecond)
+			}
+			tx.Rollback()
+		}(i, mode)
+		waitForLockQueue(tm, rowKey{"accounts", 1}, i+1)
+	}
+	tx1.Rollback()
+	wg.Wait()
+	close(order)
+	if first := <-order; first != 0 {
+		t.Fatalf("expected %v got %v", 0, first)
+	}
+	if locks := tm.(*TxMgrImpl).locks; len(locks.rows) != 0 || len(locks.held) != 0 {
+		t.Fatalf("unexpected %v %v", locks.rows, locks.held)
+	}
+}
+
+// waitForLockQueue 等到该行有n个等待者
+func waitForLockQueue(tm TxMgr, key rowKey, n int) {
+	lm := tm.(*TxMgrImpl).locks
+	for {
+		lm.mu.Lock()
+		rl := lm.rows[key]
+		waiting := rl != nil && len(rl.queue) >= n
+		lm.mu.Unlock()
+		if waiting {
+			return
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestDeadlock(t *testing.T) {
+	server, tm := createAccounts(t)
+	for _, olderWaitsFirst := range []bool{true, false} {
+		tx1 := tm.StartTransaction()
+		tx2 := tm.StartTransaction()
+		err := server.LockRowTx(tx1, "accounts", 1, EXCLUSIVE_LOCK)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = server.LockRowTx(tx2, "accounts", 2, EXCLUSIVE_LOCK)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// 先等待的一方在后台等待，另一方加锁时形成环。无论谁后加锁，都中止较晚开始的tx2
+		waiter, waiterRow, other, otherRow := tx1, 2, tx2, 1
+		if !olderWaitsFirst {
+			waiter, waiterRow, other, otherRow = tx2, 1, tx1, 2
+		}
+		errs := make(chan error, 1)
+		go func() {
+			errs <- server.LockRowTx(waiter, "accounts", waiterRow, EXCLUSIVE_LOCK)
+		}()
+		waitForLockQueue(tm, rowKey{"accounts", waiterRow}, 1)
+		otherErr := server.LockRowTx(other, "accounts", otherRow, EXCLUSIVE_LOCK)
+		waiterErr := <-errs
+
+		victimErr, survivorErr := otherErr, waiterErr
+		if !olderWaitsFirst {
+			victimErr, survivorErr = waiterErr, otherErr
+		}
+		if victimErr != ErrDeadlock || survivorErr != nil {
+			t.Fatalf("unexpected %v %v", victimErr, survivorErr)
+		}
+		err = tx2.Commit()
+		if err != ErrDeadlock {
+			t.Fatalf("expected %v got %v", ErrDeadlock, err)
+		}
+		err = tx1.Commit()
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestWriteWaitsForRowLock(t *testing.T) {
+	server, _ := createAccounts(t)
+	tm := server.TxMgr()
+	locker := server.Begin()
+	for _, id := range []int{1, 2} {
+		_, err := server.SelectByIDForUpdateTx(locker, "accounts", id)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// 不在事务中的写入以及其他事务的提交都要等加锁的事务结束
+	tx := server.Begin()
+	err := server.UpdateByIDTx(tx, "accounts", map[string]interface{}{"balance": 50}, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	errs := make(chan error, 3)
+	go func() {
+		errs <- server.UpdateByID("accounts", map[string]interface{}{"balance": 0}, 1)
+	}()
+	go func() {
+		errs <- server.DeleteByID("accounts", 2)
+	}()
+	waitForLockQueue(tm, rowKey{"accounts", 1}, 1)
+	waitForLockQueue(tm, rowKey{"accounts", 2}, 1)
+	go func() {
+		errs <- tx.Commit()
+	}()
+	waitForLockQueue(tm, rowKey{"accounts", 1}, 2)
+
+	if b := balanceTx(t, server, locker, 1); b != "100" {
+		t.Fatalf("expected %v got %v", "100", b)
+	}
+	if _, err = server.SelectByID("accounts", 2); err != nil {
+		t.Fatal(err)
+	}
+	err = server.UpdateByIDTx(locker, "accounts", map[string]interface{}{"balance": 150}, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = locker.Commit()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 加锁的事务提交后，tx的快照已经过期，不在事务中的写入继续执行
+	conflicts := 0
+	for i := 0; i < 3; i++ {
+		err = <-errs
+		if errors.Is(err, ErrWriteConflict) {
+			conflicts++
+			continue
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if conflicts != 1 {
+		t.Fatalf("expected %v got %v", 1, conflicts)
+	}
+	r, _ := server.SelectByID("accounts", 1)
+	if r.Value[1] != "0" {
+		t.Fatalf("expected %v got %v", "0", r.Value[1])
+	}
+	if _, err = server.SelectByID("accounts", 2); err != ErrKeyNotFound {
+		t.Fatalf("expected %v got %v", ErrKeyNotFound, err)
+	}
+}
+
+func TestSelectByIDForUpdateTx(t *testing.T) {
+	server, tm := createAccounts(t)
+
+	// 加锁后读写，并发的存款不会丢失
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			tx := tm.StartTransactionWithOptions(READ_COMMITTED)
+			r, err := server.SelectByIDForUpdateTx(tx, "accounts", 1)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			balance, _ := strconv.Atoi(r.Value[1])
+			err = server.UpdateByIDTx(tx, "accounts", map[string]interface{}{"balance": balance + 1}, 1)
+			if err == nil {
+				err = tx.Commit()
+			}
+			if err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+	r, _ := server.SelectByID("accounts", 1)
+	if r.Value[1] != "110" {
+		t.Fatalf("expected %v got %v", "110", r.Value[1])
+	}
+
+	// 快照之后被修改过的行，加锁后返回写冲突
+	tx1 := tm.StartTransaction()
+	tx2 := tm.StartTransaction()
+	_, err := server.SelectByIDForUpdateTx(tx1, "accounts", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = server.UpdateByIDTx(tx1, "accounts", map[string]interface{}{"balance": 0}, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		waitForLockQueue(tm, rowKey{"accounts", 1}, 1)
+		tx1.Commit()
+	}()
+	_, err = server.SelectByIDForUpdateTx(tx2, "accounts", 1)
+	var we *WriteConflictError
+	if !errors.As(err, &we) || we.ID != 1 {
+		t.Fatalf("expected %v got %v", ErrWriteConflict, err)
+	}
+	tx2.Rollback()
+
+	// SQL中的FOR UPDATE
+	ss := server.NewSession()
+	_, err = ss.Exec("BEGIN")
+	if err != nil {
+		t.Fatal(err)
+	}
+	rows, err := ss.Query("SELECT * FROM accounts WHERE balance < 100 FOR UPDATE")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rows.Records) != 1 || rows.Records[0].Key != 1 {
+		t.Fatalf("unexpected %v", rows.Records)
+	}
+	tx3 := tm.StartTransactionWithOptions(SNAPSHOT, TxOptions{LockTimeout: time.Millisecond})
+	err = server.LockRowTx(tx3, "accounts", 1, SHARED_LOCK)
+	if err != ErrLockTimeout {
+		t.Fatalf("expected %v got %v", ErrLockTimeout, err)
+	}
+	err = server.LockRowTx(tx3, "accounts", 2, EXCLUSIVE_LOCK)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = ss.Exec("COMMIT")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = server.LockRowTx(tx3, "accounts", 1, SHARED_LOCK)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
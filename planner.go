@@ -0,0 +1,750 @@
+package IDB
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	// 顺序遍历一条record的代价
+	seqCost = 1.0
+	// 没有统计信息时的默认选择率
+	defaultEqSelectivity    = 0.1
+	defaultRangeSelectivity = 1.0 / 3
+	defaultLikeSelectivity  = 0.25
+	defaultNullSelectivity  = 0.1
+)
+
+// accessKind 找到候选record的方式
+type accessKind int
+
+const (
+	// accessFullScan 遍历所有叶节点
+	accessFullScan accessKind = iota
+	// accessPKLookup INT主键等值查找，通过Tree.Find找到record
+	accessPKLookup
+	// accessPKRange INT主键范围遍历
+	accessPKRange
+	// accessIndex 通过索引找到record id，包括STRING主键索引
+	accessIndex
+)
+
+// accessPath 访问路径以及估算的行数、代价
+type accessPath struct {
+	kind  accessKind
+	field *FieldMeta
+	idx   *index
+	// accessPKLookup的key
+	keys []int
+	// accessIndex的索引值
+	values []string
+	// accessPKRange的范围，包含边界
+	lo, hi int
+	rows   float64
+	cost   float64
+}
+
+// keyRange INT字段上的范围条件，包含边界
+type keyRange struct {
+	lo, hi int
+}
+
+// predicates 顶层AND中可以用来选择访问路径的条件
+type predicates struct {
+	// 等值条件，In有多个值
+	eqs    map[*FieldMeta][]interface{}
+	ranges map[*FieldMeta]*keyRange
+}
+
+func collectPredicates(fields []*FieldMeta, expr Expr, p *predicates) {
+	switch e := expr.(type) {
+	case *andExpr:
+		for _, sub := range e.exprs {
+			collectPredicates(fields, sub, p)
+		}
+	case *cmpExpr:
+		if e.value == nil {
+			return
+		}
+		f, v, ok := predicateValue(fields, e.field, e.value)
+		if !ok {
+			return
+		}
+		if e.op == opEq {
+			p.addEq(f, []interface{}{v})
+			return
+		}
+		n, isInt := v.(int)
+		if !isInt {
+			return
+		}
+		switch e.op {
+		case opGt:
+			if n == math.MaxInt64 {
+				p.addRange(f, 1, 0)
+				return
+			}
+			p.addRange(f, n+1, math.MaxInt64)
+		case opGe:
+			p.addRange(f, n, math.MaxInt64)
+		case opLt:
+			if n == math.MinInt64 {
+				p.addRange(f, 1, 0)
+				return
+			}
+			p.addRange(f, math.MinInt64, n-1)
+		case opLe:
+			p.addRange(f, math.MinInt64, n)
+		}
+	case *inExpr:
+		var values []interface{}
+		var f *FieldMeta
+		for _, value := range e.values {
+			if value == nil {
+				continue
+			}
+			vf, v, ok := predicateValue(fields, e.field, value)
+			if !ok {
+				return
+			}
+			f = vf
+			values = append(values, v)
+		}
+		if f != nil {
+			p.addEq(f, values)
+		}
+	case *betweenExpr:
+		_, lo, ok1 := predicateValue(fields, e.field, e.lo)
+		f, hi, ok2 := predicateValue(fields, e.field, e.hi)
+		if !ok1 || !ok2 {
+			return
+		}
+		l, isInt1 := lo.(int)
+		h, isInt2 := hi.(int)
+		if isInt1 && isInt2 {
+			p.addRange(f, l, h)
+		}
+	}
+}
+
+// predicateValue 找到字段并转换值，条件已经绑定过，出错的条件直接忽略
+func predicateValue(fields []*FieldMeta, name string, value interface{}) (*FieldMeta, interface{}, bool) {
+	if value == nil {
+		return nil, nil, false
+	}
+	f, err := exprField(fields, name)
+	if err != nil {
+		return nil, nil, false
+	}
+	v, err := exprValue(f, value)
+	if err != nil {
+		return nil, nil, false
+	}
+	return f, v, true
+}
+
+// addEq 同一字段有多个等值条件时保留值最少的
+func (p *predicates) addEq(f *FieldMeta, values []interface{}) {
+	if old, ok := p.eqs[f]; ok && len(old) <= len(values) {
+		return
+	}
+	p.eqs[f] = values
+}
+
+// addRange 同一字段的范围取交集
+func (p *predicates) addRange(f *FieldMeta, lo, hi int) {
+	r := p.ranges[f]
+	if r == nil {
+		p.ranges[f] = &keyRange{lo: lo, hi: hi}
+		return
+	}
+	if lo > r.lo {
+		r.lo = lo
+	}
+	if hi < r.hi {
+		r.hi = hi
+	}
+}
+
+// pkBounds INT主键上的条件限定的id范围，没有条件时不限
+func pkBounds(fields []*FieldMeta, expr Expr) (int, int) {
+	lo, hi := math.MinInt64, math.MaxInt64
+	p := &predicates{eqs: make(map[*FieldMeta][]interface{}), ranges: make(map[*FieldMeta]*keyRange)}
+	collectPredicates(fields, expr, p)
+	for _, f := range fields {
+		if !f.isPrimaryKey || f.tp != INT {
+			continue
+		}
+		if r := p.ranges[f]; r != nil {
+			lo, hi = r.lo, r.hi
+		}
+		if values, ok := p.eqs[f]; ok {
+			min, max := math.MaxInt64, math.MinInt64
+			for _, v := range values {
+				k := v.(int)
+				if k < min {
+					min = k
+				}
+				if k > max {
+					max = k
+				}
+			}
+			if min > lo {
+				lo = min
+			}
+			if max < hi {
+				hi = max
+			}
+		}
+	}
+	return lo, hi
+}
+
+// lookupCost 通过key查找一条record的代价，与树高成正比
+func lookupCost(n float64) float64 {
+	return 1 + math.Log2(n+1)
+}
+
+// planAccess 根据条件以及表的统计信息选择代价最小的访问路径
+func (t *table) planAccess(fields []*FieldMeta, expr Expr) *accessPath {
+	n := float64(t.data.Count())
+	best := &accessPath{kind: accessFullScan, rows: n, cost: n * seqCost}
+	if expr == nil {
+		return best
+	}
+	p := &predicates{eqs: make(map[*FieldMeta][]interface{}), ranges: make(map[*FieldMeta]*keyRange)}
+	collectPredicates(fields, expr, p)
+
+	consider := func(a *accessPath) {
+		if a.cost < best.cost {
+			best = a
+		}
+	}
+	// 按字段顺序考虑，代价相同时结果稳定
+	indexes := t.allIndexes()
+	for _, f := range fields {
+		values, hasEq := p.eqs[f]
+		r := p.ranges[f]
+		if f.isPrimaryKey && f.tp == INT {
+			if hasEq {
+				consider(t.planPKLookup(f, values, n))
+			}
+			if r != nil {
+				consider(t.planPKRange(f, r, n))
+			}
+		}
+		if !hasEq {
+			continue
+		}
+		for _, idx := range indexes {
+			if idx.covers(f.pos) {
+				consider(planIndexLookup(idx, f, values, n))
+			}
+		}
+	}
+	return best
+}
+
+func (t *table) planPKLookup(f *FieldMeta, values []interface{}, n float64) *accessPath {
+	set := make(map[int]bool, len(values))
+	keys := make([]int, 0, len(values))
+	for _, v := range values {
+		k := v.(int)
+		if !set[k] {
+			set[k] = true
+			keys = append(keys, k)
+		}
+	}
+	sort.Ints(keys)
+	rows := math.Min(float64(len(keys)), n)
+	return &accessPath{kind: accessPKLookup, field: f, keys: keys, rows: rows, cost: float64(len(keys)) * lookupCost(n)}
+}
+
+func (t *table) planPKRange(f *FieldMeta, r *keyRange, n float64) *accessPath {
+	rows := t.rangeRows(f, r.lo, r.hi, n)
+	return &accessPath{kind: accessPKRange, field: f, lo: r.lo, hi: r.hi, rows: rows, cost: lookupCost(n) + rows*seqCost}
+}
+
+// rangeRows 估算主键在[lo, hi]之间的行数。有统计信息时使用直方图，否则假设key在最小值与最大值之间均匀分布
+func (t *table) rangeRows(f *FieldMeta, lo, hi int, n float64) float64 {
+	if frac, ok := t.stats.rangeFraction(f, rangeBound(lo, math.MinInt64), rangeBound(hi, math.MaxInt64)); ok {
+		return frac * n
+	}
+	min, max, ok := t.data.keyBounds()
+	if !ok {
+		return 0
+	}
+	if lo < min {
+		lo = min
+	}
+	if hi > max {
+		hi = max
+	}
+	if lo > hi {
+		return 0
+	}
+	return n * (float64(hi) - float64(lo) + 1) / (float64(max) - float64(min) + 1)
+}
+
+// rangeBound 转换为存储的值，不限时为空
+func rangeBound(v int, unbounded int) string {
+	if v == unbounded {
+		return ""
+	}
+	return strconv.Itoa(v)
+}
+
+// planIndexLookup 索引中有每个值对应的record数量，行数是准确的
+func planIndexLookup(idx *index, f *FieldMeta, values []interface{}, n float64) *accessPath {
+	keys := make([]string, 0, len(values))
+	set := make(map[string]bool, len(values))
+	for _, v := range values {
+		k := storedValue(v)
+		if !set[k] {
+			set[k] = true
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+
+	idx.mu.RLock()
+	var rows float64
+	for _, k := range keys {
+		rows += float64(len(idx.entries[k]))
+	}
+	idx.mu.RUnlock()
+	return &accessPath{kind: accessIndex, field: f, idx: idx, values: keys, rows: rows, cost: float64(len(keys)) + rows*lookupCost(n)}
+}
+
+// scan 按访问路径遍历候选record，结果按record id排序。fn返回false时停止
+func (a *accessPath) scan(t *table, fn func(r *Record) bool) {
+	switch a.kind {
+	case accessPKLookup:
+		t.findAll(a.keys, fn)
+	case accessPKRange:
+		t.data.scanRange(a.lo, a.hi, fn)
+	case accessIndex:
+		var ids []int
+		for _, v := range a.values {
+			ids = append(ids, a.idx.lookup(v)...)
+		}
+		sort.Ints(ids)
+		t.findAll(ids, fn)
+	default:
+		t.data.scanLeaves(fn)
+	}
+}
+
+// findAll 依次查找record，已经被删除的跳过
+func (t *table) findAll(ids []int, fn func(r *Record) bool) {
+	for _, id := range ids {
+		r, err := t.data.Find(id)
+		if err != nil {
+			continue
+		}
+		if !fn(r) {
+			return
+		}
+	}
+}
+
+// scan 选择访问路径并遍历可能满足条件的record，fn中仍需判断条件
+func (t *table) scan(fields []*FieldMeta, expr Expr, fn func(r *Record) bool) {
+	t.planAccess(fields, expr).scan(t, fn)
+}
+
+func (a *accessPath) String() string {
+	switch a.kind {
+	case accessPKLookup:
+		keys := make([]interface{}, len(a.keys))
+		for i, k := range a.keys {
+			keys[i] = k
+		}
+		return "PK Lookup: " + formatEqs(a.field, keys)
+	case accessPKRange:
+		var conds []string
+		if a.lo != math.MinInt64 {
+			conds = append(conds, fmt.Sprintf("%s >= %d", a.field.name, a.lo))
+		}
+		if a.hi != math.MaxInt64 {
+			conds = append(conds, fmt.Sprintf("%s <= %d", a.field.name, a.hi))
+		}
+		return "PK Range Scan: " + strings.Join(conds, " AND ")
+	case accessIndex:
+		values := make([]interface{}, len(a.values))
+		for i, v := range a.values {
+			values[i] = v
+		}
+		if a.field.tp == INT {
+			for i, v := range a.values {
+				values[i] = formatIntValue(v)
+			}
+		}
+		return fmt.Sprintf("Index Lookup using %s: %s", a.idx.name, formatEqs(a.field, values))
+	}
+	return "Full Scan"
+}
+
+func formatEqs(f *FieldMeta, values []interface{}) string {
+	if len(values) == 1 {
+		return fmt.Sprintf("%s = %s", f.name, formatValue(values[0]))
+	}
+	return In(f.name, values...).String()
+}
+
+// formatIntValue 索引中INT字段以字符串保存，输出时不加引号
+func formatIntValue(v string) interface{} {
+	n, err := strconv.Atoi(v)
+	if err != nil {
+		return v
+	}
+	return n
+}
+
+// selectivity 估算满足条件的record比例。Analyze后使用统计信息，否则使用默认值
+func (t *table) selectivity(fields []*FieldMeta, expr Expr) float64 {
+	n := float64(t.data.Count())
+	switch e := expr.(type) {
+	case *cmpExpr:
+		f, err := exprField(fields, e.field)
+		if err != nil {
+			return 1
+		}
+		if e.value == nil {
+			if e.op == opNe {
+				return 1 - t.nullSelectivity(f)
+			}
+			return t.nullSelectivity(f)
+		}
+		_, v, ok := predicateValue(fields, e.field, e.value)
+		if !ok {
+			return 1
+		}
+		switch e.op {
+		case opEq:
+			return t.eqSelectivity(f, storedValue(v), n)
+		case opNe:
+			return math.Max(0, 1-t.eqSelectivity(f, storedValue(v), n)-t.nullSelectivity(f))
+		case opGt, opGe:
+			return t.rangeSelectivity(f, e.op, v, nil, n)
+		}
+		if k, isInt := v.(int); isInt && e.op == opLt {
+			if k == math.MinInt64 {
+				return 0
+			}
+			v = k - 1
+		}
+		return t.rangeSelectivity(f, opLe, nil, v, n)
+	case *inExpr:
+		f, err := exprField(fields, e.field)
+		if err != nil {
+			return 1
+		}
+		var s float64
+		for _, value := range e.values {
+			if _, v, ok := predicateValue(fields, e.field, value); ok {
+				s += t.eqSelectivity(f, storedValue(v), n)
+			}
+		}
+		return math.Min(1, s)
+	case *betweenExpr:
+		f, lo, ok1 := predicateValue(fields, e.field, e.lo)
+		_, hi, ok2 := predicateValue(fields, e.field, e.hi)
+		if !ok1 || !ok2 {
+			return 1
+		}
+		return t.rangeSelectivity(f, opGe, lo, hi, n)
+	case *likeExpr:
+		return defaultLikeSelectivity
+	case *nullExpr:
+		f, err := exprField(fields, e.field)
+		if err != nil {
+			return 1
+		}
+		return t.nullSelectivity(f)
+	case *andExpr:
+		s := 1.0
+		for _, sub := range e.exprs {
+			s *= t.selectivity(fields, sub)
+		}
+		return s
+	case *orExpr:
+		s := 1.0
+		for _, sub := range e.exprs {
+			s *= 1 - t.selectivity(fields, sub)
+		}
+		return 1 - s
+	case *notExpr:
+		return 1 - t.selectivity(fields, e.expr)
+	}
+	return 1
+}
+
+// eqSelectivity 主键唯一，有索引时使用索引中的数量，否则使用直方图
+func (t *table) eqSelectivity(f *FieldMeta, v string, n float64) float64 {
+	if n == 0 {
+		return 0
+	}
+	if f.isPrimaryKey {
+		return 1 / n
+	}
+	for _, idx := range t.allIndexes() {
+		if !idx.covers(f.pos) {
+			continue
+		}
+		idx.mu.RLock()
+		count := len(idx.entries[v])
+		idx.mu.RUnlock()
+		return float64(count) / n
+	}
+	if frac, ok := t.stats.eqFraction(f, v); ok {
+		return frac
+	}
+	return defaultEqSelectivity
+}
+
+// rangeSelectivity 估算在lo、hi之间的比例，为nil时不限。lo的比较方式为op，hi包含边界
+func (t *table) rangeSelectivity(f *FieldMeta, op cmpOp, lo, hi interface{}, n float64) float64 {
+	if n == 0 {
+		return 0
+	}
+	var from, to string
+	if lo != nil {
+		from = storedValue(lo)
+		// INT字段转换为包含边界
+		if k, ok := lo.(int); ok && op == opGt {
+			if k == math.MaxInt64 {
+				return 0
+			}
+			from = strconv.Itoa(k + 1)
+		}
+	}
+	if hi != nil {
+		to = storedValue(hi)
+	}
+
+	if f.isPrimaryKey && f.tp == INT {
+		l, h := math.MinInt64, math.MaxInt64
+		if from != "" {
+			l, _ = strconv.Atoi(from)
+		}
+		if to != "" {
+			h, _ = strconv.Atoi(to)
+		}
+		return t.rangeRows(f, l, h, n) / n
+	}
+	if frac, ok := t.stats.rangeFraction(f, from, to); ok {
+		return frac
+	}
+	return defaultRangeSelectivity
+}
+
+// nullSelectivity 空值比例
+func (t *table) nullSelectivity(f *FieldMeta) float64 {
+	if f.isPrimaryKey {
+		return 0
+	}
+	if frac, ok := t.stats.nullFraction(f); ok {
+		return frac
+	}
+	return defaultNullSelectivity
+}
+
+// selectPlan SELECT的执行计划
+type selectPlan struct {
+	t         *table
+	tableName string
+	fields    []*FieldMeta
+	expr      Expr
+	isTarget  IsTarget
+	opt       QueryOptions
+	q         *query
+	// 为nil时遍历事务快照中id在[lo, hi]之间的record
+	access *accessPath
+	lo, hi int
+	// 执行时统计的实际行数
+	scanned, matched, returned int
+}
+
+// planSelect 检查条件以及查询选项并选择访问路径
+func (s *idbServer) planSelect(tx *Tx, tableName string, expr Expr, opts []QueryOptions) (*selectPlan, error) {
+	t, err := s.getTable(tableName)
+	if err != nil {
+		return nil, err
+	}
+	if expr == nil {
+		expr = And()
+	}
+
+	p := &selectPlan{t: t, tableName: tableName, fields: t.meta.getFields(), expr: expr}
+	p.isTarget, err = bindTarget(expr, p.fields)
+	if err != nil {
+		return nil, err
+	}
+	p.q, err = newQuery(p.fields, opts)
+	if err != nil {
+		return nil, err
+	}
+	if len(opts) > 0 {
+		p.opt = opts[0]
+	}
+	// 事务中需要合并未提交的修改，只能遍历快照，主键上的条件用来缩小遍历范围
+	if tx == nil {
+		p.access = t.planAccess(p.fields, expr)
+	} else {
+		p.lo, p.hi = pkBounds(p.fields, expr)
+	}
+	return p, nil
+}
+
+// run 执行查询，执行后不能再次执行
+func (p *selectPlan) run(s *idbServer, tx *Tx) ([]*Record, error) {
+	defer p.q.close()
+
+	var err error
+	visit := func(r *Record) bool {
+		p.scanned++
+		if !p.isTarget(r) {
+			return true
+		}
+		p.matched++
+		var more bool
+		more, err = p.q.add(r)
+		return err == nil && more
+	}
+	if p.access != nil {
+		p.access.scan(p.t, visit)
+	} else if scanErr := s.scanRangeTx(tx, p.t, p.tableName, p.lo, p.hi, visit); scanErr != nil {
+		return nil, scanErr
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	records, err := p.q.result()
+	if err != nil {
+		return nil, err
+	}
+	p.returned = len(records)
+	return records, nil
+}
+
+// planNode 执行计划树中的节点
+type planNode struct {
+	name     string
+	estRows  float64
+	actRows  int
+	children []*planNode
+}
+
+// tree 生成执行计划树，从上到下依次为投影、分页、排序、过滤以及访问路径
+func (p *selectPlan) tree() *planNode {
+	n := &planNode{
+		name:    fmt.Sprintf("%v on %s (cost=%.1f)", p.access, p.tableName, p.access.cost),
+		estRows: p.access.rows,
+		actRows: p.scanned,
+	}
+	rows := p.access.rows
+
+	if a, ok := p.expr.(*andExpr); !ok || len(a.exprs) > 0 {
+		rows = math.Min(rows, float64(p.t.data.Count())*p.t.selectivity(p.fields, p.expr))
+		n = &planNode{name: "Filter: " + p.expr.String(), estRows: rows, actRows: p.matched, children: []*planNode{n}}
+	}
+
+	k := p.opt.Offset + p.opt.Limit
+	if len(p.opt.OrderBy) > 0 {
+		keys := make([]string, len(p.opt.OrderBy))
+		for i, o := range p.opt.OrderBy {
+			keys[i] = o.Field
+			if o.Desc {
+				keys[i] += " DESC"
+			}
+		}
+		name, act := "Sort: ", p.matched
+		if p.opt.Limit > 0 {
+			name = fmt.Sprintf("Top-N Sort (n=%d): ", k)
+			rows = math.Min(rows, float64(k))
+			if act > k {
+				act = k
+			}
+		}
+		n = &planNode{name: name + strings.Join(keys, ", "), estRows: rows, actRows: act, children: []*planNode{n}}
+	}
+
+	if p.opt.Limit > 0 || p.opt.Offset > 0 {
+		rows = math.Max(0, rows-float64(p.opt.Offset))
+		name := fmt.Sprintf("Limit: offset=%d", p.opt.Offset)
+		if p.opt.Limit > 0 {
+			rows = math.Min(rows, float64(p.opt.Limit))
+			name = fmt.Sprintf("Limit: %d offset=%d", p.opt.Limit, p.opt.Offset)
+		}
+		n = &planNode{name: name, estRows: rows, actRows: p.returned, children: []*planNode{n}}
+	}
+
+	if len(p.opt.Fields) > 0 {
+		n = &planNode{name: "Project: " + strings.Join(p.opt.Fields, ", "), estRows: rows, actRows: p.returned, children: []*planNode{n}}
+	}
+	return n
+}
+
+// format 每个节点一行，子节点缩进。analyze为true时输出实际行数
+func (n *planNode) format(b *strings.Builder, depth int, analyze bool) {
+	if depth > 0 {
+		b.WriteString(strings.Repeat("  ", depth-1) + "-> ")
+	}
+	fmt.Fprintf(b, "%s (est rows=%.0f", n.name, n.estRows)
+	if analyze {
+		fmt.Fprintf(b, " actual rows=%d", n.actRows)
+	}
+	b.WriteString(")\n")
+	for _, c := range n.children {
+		c.format(b, depth+1, analyze)
+	}
+}
+
+// Explain 输出SELECT的执行计划，query可以带有EXPLAIN [ANALYZE]前缀。
+// analyze为true时执行查询并输出每个节点的实际行数
+func (s *idbServer) Explain(query string, analyze bool, args ...interface{}) (string, error) {
+	stmt, params, err := parseSQL(query)
+	if err != nil {
+		return "", err
+	}
+	if params != len(args) {
+		return "", ErrArgCount
+	}
+	var sel *selectStmt
+	switch stmt := stmt.(type) {
+	case *selectStmt:
+		sel = stmt
+	case *explainStmt:
+		sel, analyze = stmt.sel, analyze || stmt.analyze
+	default:
+		return "", ErrNotQuery
+	}
+	return s.explain(sel, analyze, args)
+}
+
+func (s *idbServer) explain(sel *selectStmt, analyze bool, args []interface{}) (string, error) {
+	expr, opt, err := selectArgs(sel, args)
+	if err != nil {
+		return "", err
+	}
+	p, err := s.planSelect(nil, sel.table, expr, []QueryOptions{opt})
+	if err != nil {
+		return "", err
+	}
+	if analyze {
+		if _, err = p.run(s, nil); err != nil {
+			return "", err
+		}
+	} else {
+		p.q.close()
+	}
+
+	var b strings.Builder
+	p.tree().format(&b, 0, analyze)
+	return b.String(), nil
+}
@@ -0,0 +1,102 @@
+package IDB
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func createPlannerTable(t *testing.T) *idbServer {
+	server := NewIDBServer()
+	_, err := server.Exec("CREATE TABLE people (id INT PRIMARY KEY, city STRING, age INT)")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 1; i <= 100; i++ {
+		_, err = server.Exec("INSERT INTO people VALUES (?, ?, ?)", i, fmt.Sprintf("c%d", i%10), i%30)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	err = server.CreateIndex("people", "city", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return server
+}
+
+func TestPlanAccess(t *testing.T) {
+	server := createPlannerTable(t)
+	tests := []struct {
+		sql  string
+		plan string
+	}{
+		{"SELECT * FROM people WHERE id = 5", "PK Lookup: id = 5 on people (cost=7.7) (est rows=1)"},
+		{"SELECT * FROM people WHERE id IN (3, 1, 3)", "PK Lookup: id IN (1, 3)"},
+		{"SELECT * FROM people WHERE id BETWEEN 10 AND 19", "PK Range Scan: id >= 10 AND id <= 19 on people (cost=17.7) (est rows=10)"},
+		{"SELECT * FROM people WHERE id > 95 AND age > 1", "PK Range Scan: id >= 96 on people"},
+		{"SELECT * FROM people WHERE city = 'c3' AND age < 10", "Index Lookup using city: city = 'c3' on people"},
+		// 范围太大时全表扫描更快
+		{"SELECT * FROM people WHERE id > 5", "Full Scan on people (cost=100.0) (est rows=100)"},
+		{"SELECT * FROM people WHERE city = 'c3' OR id = 1", "Full Scan"},
+	}
+	for _, test := range tests {
+		plan, err := server.Explain(test.sql, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !strings.Contains(plan, test.plan) {
+			t.Fatalf("%s: expected %v got %v", test.sql, test.plan, plan)
+		}
+	}
+
+	// 通过不同访问路径查询的结果一致
+	records, err := server.SelectWhere("people", And(Between("id", 10, 19), Ne("city", "c5")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 9 || records[0].Key != 10 || records[8].Key != 19 {
+		t.Fatalf("unexpected %v", records)
+	}
+	err = server.DeleteByID("people", 12)
+	if err != nil {
+		t.Fatal(err)
+	}
+	records, err = server.SelectWhere("people", And(Ge("id", 11), Lt("id", 14), In("city", "c2", "c3")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 1 || records[0].Key != 13 {
+		t.Fatalf("unexpected %v", records)
+	}
+}
+
+func TestExplain(t *testing.T) {
+	server := createPlannerTable(t)
+	plan, err := server.Explain("SELECT id FROM people WHERE city = ? ORDER BY age DESC LIMIT 3 OFFSET 1", true, "c3")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := `Project: id (est rows=3 actual rows=3)
+-> Limit: 3 offset=1 (est rows=3 actual rows=3)
+  -> Top-N Sort (n=4): age DESC (est rows=4 actual rows=4)
+    -> Filter: city = 'c3' (est rows=10 actual rows=10)
+      -> Index Lookup using city: city = 'c3' on people (cost=77.6) (est rows=10 actual rows=10)
+`
+	if plan != expected {
+		t.Fatalf("expected %v got %v", expected, plan)
+	}
+
+	// SQL中的EXPLAIN
+	rows, err := server.Query("EXPLAIN SELECT * FROM people")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rows.Records) != 1 || rows.Columns[0] != "plan" || rows.Records[0].Value[0] != "Full Scan on people (cost=100.0) (est rows=100)" {
+		t.Fatalf("unexpected %v", rows.Records)
+	}
+	_, err = server.Explain("DELETE FROM people", false)
+	if err != ErrNotQuery {
+		t.Fatalf("expected %v got %v", ErrNotQuery, err)
+	}
+}
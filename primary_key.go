@@ -0,0 +1,180 @@
+package IDB
+
+import (
+	"errors"
+	"strconv"
+	"sync/atomic"
+)
+
+var (
+	ErrDuplicatePrimaryKey = errors.New("storage: duplicate primary key")
+	ErrPrimaryKeyImmutable = errors.New("storage: primary key can not be altered")
+	ErrInvalidPrimaryKey   = errors.New("storage: invalid primary key")
+)
+
+// checkPrimaryKey 检查主键定义。最多一个主键，自增只能用于INT主键
+func checkPrimaryKey(fields []*FieldMeta) error {
+	var pkCount int
+	for _, f := range fields {
+		if f.autoIncrement && (!f.isPrimaryKey || f.tp != INT) {
+			return ErrInvalidPrimaryKey
+		}
+		if !f.isPrimaryKey {
+			continue
+		}
+		if f.tp != INT && f.tp != STRING {
+			return ErrInvalidPrimaryKey
+		}
+		pkCount++
+	}
+	if pkCount > 1 {
+		return ErrInvalidPrimaryKey
+	}
+	return nil
+}
+
+// isRequired 主键除了自增的都必填
+func (f *FieldMeta) isRequired() bool {
+	return f.required || (f.isPrimaryKey && !f.autoIncrement)
+}
+
+// primaryKey 找到主键字段。没有定义主键时返回nil，使用自增id作为主键
+func (m *tableMeta) primaryKey() *FieldMeta {
+	for _, f := range m.getFields() {
+		if f.isPrimaryKey {
+			return f
+		}
+	}
+	return nil
+}
+
+// raiseIDCount 显式指定INT主键后，保证之后自增的id不会与之重复
+func (m *tableMeta) raiseIDCount(id int) {
+	for {
+		cur := atomic.LoadInt64(&(m.idCount))
+		if int64(id) <= cur || atomic.CompareAndSwapInt64(&(m.idCount), cur, int64(id)) {
+			return
+		}
+	}
+}
+
+// assignKey 为新record分配b+树中的key。INT主键直接作为key，STRING主键以及无主键时使用自增id
+func (t *table) assignKey(values []string) (int, error) {
+	pk := t.meta.primaryKey()
+	if pk == nil || pk.tp == STRING {
+		return int(atomic.AddInt64(&(t.meta.idCount), 1)), nil
+	}
+
+	v := values[pk.pos]
+	if v == "" {
+		id := int(atomic.AddInt64(&(t.meta.idCount), 1))
+		values[pk.pos] = strconv.Itoa(id)
+		return id, nil
+	}
+	id, err := strconv.Atoi(v)
+	if err != nil {
+		return 0, ErrMismatchFieldType
+	}
+	t.meta.raiseIDCount(id)
+	return id, nil
+}
+
+// checkPKBeforeInsertTx 事务插入前检查主键是否与表中以及事务中的数据重复
+func (t *table) checkPKBeforeInsertTx(cache map[int]*OpRecord, r *Record) error {
+	pk := t.meta.primaryKey()
+	if pk == nil {
+		return nil
+	}
+
+	if t.pkIndex == nil {
+		// 同一事务中删除后可以再插入
+		if rc := cache[r.Key]; rc != nil {
+			if rc.op == DELETE {
+				return nil
+			}
+			return t.pkError(r)
+		}
+		if _, err := t.data.Find(r.Key); err == nil {
+			return t.pkError(r)
+		}
+		return nil
+	}
+
+	key := t.pkIndex.keyOf(r)
+	if t.pkIndexConflicts(cache, key) {
+		return t.pkError(r)
+	}
+	for _, rc := range cache {
+		if rc.op == INSERT && t.pkIndex.keyOf(rc.opChange.(*InsertOpChange).record) == key {
+			return t.pkError(r)
+		}
+	}
+	return nil
+}
+
+// checkPKBeforeCommit 提交前检查事务插入的主键是否已被其他事务插入
+func (t *table) checkPKBeforeCommit(cache map[int]*OpRecord, r *Record) error {
+	if t.meta.primaryKey() == nil {
+		return nil
+	}
+	if t.pkIndex == nil {
+		if _, err := t.data.Find(r.Key); err == nil {
+			return t.pkError(r)
+		}
+		return nil
+	}
+	if t.pkIndexConflicts(cache, t.pkIndex.keyOf(r)) {
+		return t.pkError(r)
+	}
+	return nil
+}
+
+// pkIndexConflicts 表中是否存在该主键，且没有被事务删除
+func (t *table) pkIndexConflicts(cache map[int]*OpRecord, key string) bool {
+	for _, id := range t.pkIndex.lookup(key) {
+		if rc := cache[id]; rc == nil || rc.op != DELETE {
+			return true
+		}
+	}
+	return false
+}
+
+// SelectByPK 根据主键查询数据。没有定义主键的表使用自增id查询
+func (s *idbServer) SelectByPK(tableName string, pkValue interface{}) (*Record, error) {
+	t, err := s.getTable(tableName)
+	if err != nil {
+		return nil, err
+	}
+
+	id, err := t.findIDByPK(pkValue)
+	if err != nil {
+		return nil, err
+	}
+	record, err := t.data.Find(id)
+	if err != nil {
+		return nil, err
+	}
+	return t.meta.materialize(record), nil
+}
+
+// findIDByPK 根据主键找到b+树中的key
+func (t *table) findIDByPK(pkValue interface{}) (int, error) {
+	pk := t.meta.primaryKey()
+	if pk == nil || pk.tp == INT {
+		id, ok := pkValue.(int)
+		if !ok {
+			return 0, ErrMismatchFieldType
+		}
+		return id, nil
+	}
+
+	v, ok := pkValue.(string)
+	if !ok {
+		return 0, ErrMismatchFieldType
+	}
+	ids := t.pkIndex.lookup(v)
+	if len(ids) == 0 {
+		return 0, ErrKeyNotFound
+	}
+	return ids[0], nil
+}
@@ -0,0 +1,286 @@
+package IDB
+
+import (
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+func TestInvalidPrimaryKey(t *testing.T) {
+	server := NewIDBServer()
+	err := server.CreateTable("test", []*FieldMeta{
+		{name: "a", isPrimaryKey: true, tp: INT},
+		{name: "b", isPrimaryKey: true, tp: STRING},
+	})
+	if err != ErrInvalidPrimaryKey {
+		t.Fatalf("expected %v got %v", ErrInvalidPrimaryKey, err)
+	}
+	err = server.CreateTable("test", []*FieldMeta{
+		{name: "a", isPrimaryKey: true, autoIncrement: true, tp: STRING},
+	})
+	if err != ErrInvalidPrimaryKey {
+		t.Fatalf("expected %v got %v", ErrInvalidPrimaryKey, err)
+	}
+}
+
+func TestIntPrimaryKey(t *testing.T) {
+	server := NewIDBServer()
+	fms := []*FieldMeta{
+		{
+			name:          "order_no",
+			isPrimaryKey:  true,
+			autoIncrement: true,
+			tp:            INT,
+		},
+		{
+			name: "name",
+			tp:   STRING,
+		},
+	}
+	tableName := "orders"
+	server.CreateTable(tableName, fms)
+
+	err := server.Insert(tableName, []interface{}{1001, "hello"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = server.Insert(tableName, []interface{}{1001, "world"})
+	if !errors.Is(err, ErrDuplicatePrimaryKey) {
+		t.Fatalf("expected %v got %v", ErrDuplicatePrimaryKey, err)
+	}
+
+	// 自增主键从显式指定的最大值之后开始
+	err = server.Insert(tableName, []interface{}{nil, "world"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	record, err := server.SelectByPK(tableName, 1002)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if record.Key != 1002 || record.Value[0] != "1002" || record.Value[1] != "world" {
+		t.Fatalf("unexpected %v", record)
+	}
+	record, err = server.SelectByPK(tableName, 1001)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if record.Value[1] != "hello" {
+		t.Fatalf("unexpected %v", record)
+	}
+	_, err = server.SelectByPK(tableName, "1001")
+	if err != ErrMismatchFieldType {
+		t.Fatalf("expected %v got %v", ErrMismatchFieldType, err)
+	}
+
+	err = server.UpdateByID(tableName, map[string]interface{}{"order_no": 2000}, 1001)
+	if err != ErrPrimaryKeyImmutable {
+		t.Fatalf("expected %v got %v", ErrPrimaryKeyImmutable, err)
+	}
+	err = server.DropColumn(tableName, "order_no")
+	if err != ErrPrimaryKeyImmutable {
+		t.Fatalf("expected %v got %v", ErrPrimaryKeyImmutable, err)
+	}
+}
+
+func TestStringPrimaryKey(t *testing.T) {
+	server := NewIDBServer()
+	fms := []*FieldMeta{
+		{
+			name:         "order_no",
+			isPrimaryKey: true,
+			tp:           STRING,
+		},
+		{
+			name: "amount",
+			tp:   INT,
+		},
+	}
+	tableName := "orders"
+	server.CreateTable(tableName, fms)
+
+	err := server.Insert(tableName, []interface{}{"SO-001", 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = server.Insert(tableName, []interface{}{"SO-001", 20})
+	if !errors.Is(err, ErrDuplicatePrimaryKey) {
+		t.Fatalf("expected %v got %v", ErrDuplicatePrimaryKey, err)
+	}
+	err = server.Insert(tableName, []interface{}{nil, 20})
+	if err != ErrFieldRequired {
+		t.Fatalf("expected %v got %v", ErrFieldRequired, err)
+	}
+
+	record, err := server.SelectByPK(tableName, "SO-001")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if record.Value[0] != "SO-001" || record.Value[1] != "10" {
+		t.Fatalf("unexpected %v", record)
+	}
+	_, err = server.SelectByPK(tableName, "SO-002")
+	if err != ErrKeyNotFound {
+		t.Fatalf("expected %v got %v", ErrKeyNotFound, err)
+	}
+
+	// 删除之后可以再插入相同主键
+	err = server.DeleteByID(tableName, record.Key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = server.Insert(tableName, []interface{}{"SO-001", 30})
+	if err != nil {
+		t.Fatal(err)
+	}
+	record, err = server.SelectByPK(tableName, "SO-001")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if record.Value[1] != "30" {
+		t.Fatalf("unexpected %v", record)
+	}
+}
+
+func TestPrimaryKeyInTx(t *testing.T) {
+	server := NewIDBServer()
+	inspector := NewUndoInspector()
+	server.WithOptions(func(option *ServerOptionConfig) {
+		option.inspector = inspector
+	})
+	fms := []*FieldMeta{
+		{
+			name:         "order_no",
+			isPrimaryKey: true,
+			tp:           STRING,
+		},
+	}
+	tableName := "orders"
+	server.CreateTable(tableName, fms)
+	err := server.Insert(tableName, []interface{}{"SO-001"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tm := NewTxMgr(server, inspector)
+	tx := tm.StartTransaction()
+	err = server.InsertTx(tx, tableName, []interface{}{"SO-001"})
+	if !errors.Is(err, ErrDuplicatePrimaryKey) {
+		t.Fatalf("expected %v got %v", ErrDuplicatePrimaryKey, err)
+	}
+	err = server.InsertTx(tx, tableName, []interface{}{"SO-002"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = server.InsertTx(tx, tableName, []interface{}{"SO-002"})
+	if !errors.Is(err, ErrDuplicatePrimaryKey) {
+		t.Fatalf("expected %v got %v", ErrDuplicatePrimaryKey, err)
+	}
+
+	// 事务中删除后再插入
+	record, err := server.SelectByPK(tableName, "SO-001")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = server.DeleteByIDTx(tx, tableName, record.Key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = server.InsertTx(tx, tableName, []interface{}{"SO-001"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 其他人先插入了相同主键
+	err = server.Insert(tableName, []interface{}{"SO-002"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = tx.Commit()
+	if !errors.Is(err, ErrDuplicatePrimaryKey) {
+		t.Fatalf("expected %v got %v", ErrDuplicatePrimaryKey, err)
+	}
+	records, err := server.SelectByFields(tableName, map[string]interface{}{"order_no": "SO-002"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 1 {
+		t.Fatalf("unexpected %v", records)
+	}
+	// 提交失败，事务中的删除也没有生效
+	r, err := server.SelectByPK(tableName, "SO-001")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.Key != record.Key {
+		t.Fatalf("unexpected %v", r)
+	}
+
+	// INT主键事务中删除后再插入
+	intTable := "items"
+	server.CreateTable(intTable, []*FieldMeta{
+		{name: "id", isPrimaryKey: true, tp: INT},
+		{name: "name", tp: STRING},
+	})
+	err = server.Insert(intTable, []interface{}{7, "hello"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tx = tm.StartTransaction()
+	err = server.InsertTx(tx, intTable, []interface{}{7, "world"})
+	if !errors.Is(err, ErrDuplicatePrimaryKey) {
+		t.Fatalf("expected %v got %v", ErrDuplicatePrimaryKey, err)
+	}
+	err = server.DeleteByIDTx(tx, intTable, 7)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = server.InsertTx(tx, intTable, []interface{}{7, "world"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = tx.Commit()
+	if err != nil {
+		t.Fatal(err)
+	}
+	record, err = server.SelectByPK(intTable, 7)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if record.Value[1] != "world" {
+		t.Fatalf("unexpected %v", record)
+	}
+}
+
+func TestConcurrentInsertSamePrimaryKey(t *testing.T) {
+	server := NewIDBServer()
+	tableName := "orders"
+	server.CreateTable(tableName, []*FieldMeta{
+		{name: "order_no", isPrimaryKey: true, tp: STRING},
+	})
+	intTable := "items"
+	server.CreateTable(intTable, []*FieldMeta{
+		{name: "id", isPrimaryKey: true, tp: INT},
+	})
+
+	count := 100
+	var succeed, intSucceed int64
+	wg := &sync.WaitGroup{}
+	wg.Add(count)
+	for i := 0; i < count; i++ {
+		go func() {
+			defer wg.Done()
+			if server.Insert(tableName, []interface{}{"SO-001"}) == nil {
+				atomic.AddInt64(&succeed, 1)
+			}
+			if server.Insert(intTable, []interface{}{1}) == nil {
+				atomic.AddInt64(&intSucceed, 1)
+			}
+		}()
+	}
+	wg.Wait()
+	if succeed != 1 || intSucceed != 1 {
+		t.Fatalf("expected only one insert succeed, got %d %d", succeed, intSucceed)
+	}
+}
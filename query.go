@@ -0,0 +1,395 @@
+package IDB
+
+import (
+	"container/heap"
+	"encoding/gob"
+	"errors"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+var (
+	ErrInvalidQueryOptions = errors.New("storage: invalid query options")
+)
+
+// sortRunSize 排序时内存中最多保留的record数量，超过后将排好序的部分写入临时文件，最后归并
+var sortRunSize = 10000
+
+// QueryOptions 查询选项
+type QueryOptions struct {
+	// 返回的字段，按指定顺序返回。为空时返回所有字段
+	Fields []string
+	// 排序字段，按字段类型比较，空值排在最前。为空时按id排序
+	OrderBy []OrderBy
+	// 最多返回的数量，为0时不限制
+	Limit int
+	// 跳过的数量
+	Offset int
+}
+
+// OrderBy 排序字段
+type OrderBy struct {
+	Field string
+	Desc  bool
+}
+
+// query 根据选项收集查询结果
+type query struct {
+	fields  []*FieldMeta
+	project []*FieldMeta
+	limit   int
+	offset  int
+	// 没有排序时按id顺序收集
+	rows   []*Record
+	sorter *recordSorter
+}
+
+func newQuery(fields []*FieldMeta, opts []QueryOptions) (*query, error) {
+	q := &query{fields: fields}
+	if len(opts) == 0 {
+		return q, nil
+	}
+	opt := opts[0]
+	var err error
+	if opt.Limit < 0 || opt.Offset < 0 {
+		return nil, ErrInvalidQueryOptions
+	}
+	q.limit, q.offset = opt.Limit, opt.Offset
+
+	q.project, err = projection(fields, opt.Fields)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(opt.OrderBy) > 0 {
+		less, err := orderLess(fields, opt.OrderBy)
+		if err != nil {
+			return nil, err
+		}
+		var k int
+		if q.limit > 0 {
+			k = q.offset + q.limit
+		}
+		q.sorter = newRecordSorter(less, k)
+	}
+	return q, nil
+}
+
+// add 添加满足条件的record，返回false时不需要再继续查找
+func (q *query) add(r *Record) (bool, error) {
+	if q.sorter != nil {
+		return true, q.sorter.add(r)
+	}
+	if q.offset > 0 {
+		q.offset--
+		return true, nil
+	}
+	q.rows = append(q.rows, r)
+	return q.limit == 0 || len(q.rows) < q.limit, nil
+}
+
+// result 排序、分页并投影字段。排序时跳过offset条，取到limit条后不再归并
+func (q *query) result() ([]*Record, error) {
+	if q.sorter == nil {
+		records := make([]*Record, len(q.rows))
+		for i, r := range q.rows {
+			records[i] = q.projectRecord(r)
+		}
+		return records, nil
+	}
+
+	records := make([]*Record, 0)
+	offset := q.offset
+	err := q.sorter.finish(func(r *Record) bool {
+		if offset > 0 {
+			offset--
+			return true
+		}
+		records = append(records, q.projectRecord(r))
+		return q.limit == 0 || len(records) < q.limit
+	})
+	if err != nil {
+		return nil, err
+	}
+	return records, nil
+}
+
+// close 删除排序产生的临时文件
+func (q *query) close() {
+	if q.sorter != nil {
+		q.sorter.close()
+	}
+}
+
+func (q *query) projectRecord(r *Record) *Record {
+	if len(q.project) == 0 {
+		return projectRecord(q.fields, r)
+	}
+	return projectRecord(q.project, r)
+}
+
+// projection 找到需要返回的字段，names为空时返回nil
+func projection(fields []*FieldMeta, names []string) ([]*FieldMeta, error) {
+	var project []*FieldMeta
+	for _, name := range names {
+		f, err := exprField(fields, name)
+		if err != nil {
+			return nil, err
+		}
+		project = append(project, f)
+	}
+	return project, nil
+}
+
+// projectRecord 按fields的顺序返回record的值
+func projectRecord(fields []*FieldMeta, r *Record) *Record {
+	if isIdentityLayout(fields, r) {
+		return r
+	}
+	values := make([]string, len(fields))
+	for i, f := range fields {
+		values[i] = fieldValue(f, r)
+	}
+	return &Record{
+		Key:   r.Key,
+		Value: values,
+		Meta:  r.Meta,
+	}
+}
+
+// orderLess 根据排序字段生成比较方法，值相同时按id排序
+func orderLess(fields []*FieldMeta, orderBy []OrderBy) (func(a, b *Record) bool, error) {
+	orderFields := make([]*FieldMeta, len(orderBy))
+	for i, o := range orderBy {
+		f, err := exprField(fields, o.Field)
+		if err != nil {
+			return nil, err
+		}
+		orderFields[i] = f
+	}
+	return func(a, b *Record) bool {
+		for i, f := range orderFields {
+			c := compareValues(f.tp, fieldValue(f, a), fieldValue(f, b))
+			if c == 0 {
+				continue
+			}
+			if orderBy[i].Desc {
+				return c > 0
+			}
+			return c < 0
+		}
+		return a.Key < b.Key
+	}, nil
+}
+
+// compareValues 按字段类型比较存储的值，空值最小
+func compareValues(tp fieldType, a, b string) int {
+	switch {
+	case a == b:
+		return 0
+	case a == "":
+		return -1
+	case b == "":
+		return 1
+	}
+	if tp == INT {
+		x, errX := strconv.Atoi(a)
+		y, errY := strconv.Atoi(b)
+		if errX == nil && errY == nil {
+			switch {
+			case x < y:
+				return -1
+			case x > y:
+				return 1
+			}
+			return 0
+		}
+	}
+	return strings.Compare(a, b)
+}
+
+// recordSorter 排序record。指定k时使用大小为k的堆只保留最小的k个，
+// 否则内存中超过sortRunSize后将排好序的部分写入临时文件，最后多路归并
+type recordSorter struct {
+	less func(a, b *Record) bool
+	k    int
+	buf  []*Record
+	runs []*os.File
+}
+
+func newRecordSorter(less func(a, b *Record) bool, k int) *recordSorter {
+	return &recordSorter{less: less, k: k}
+}
+
+func (s *recordSorter) add(r *Record) error {
+	if s.k > 0 {
+		h := &maxHeap{rs: s.buf, less: s.less}
+		if h.Len() < s.k {
+			heap.Push(h, r)
+		} else if s.less(r, h.rs[0]) {
+			h.rs[0] = r
+			heap.Fix(h, 0)
+		}
+		s.buf = h.rs
+		return nil
+	}
+
+	s.buf = append(s.buf, r)
+	if len(s.buf) >= sortRunSize {
+		return s.spill()
+	}
+	return nil
+}
+
+// spillRecord 写入临时文件的record
+type spillRecord struct {
+	Key   int
+	Value []string
+}
+
+// spill 将内存中的record排序后写入临时文件
+func (s *recordSorter) spill() error {
+	f, err := os.CreateTemp("", "idb-sort-*")
+	if err != nil {
+		return err
+	}
+	s.runs = append(s.runs, f)
+
+	s.sortBuf()
+	enc := gob.NewEncoder(f)
+	for _, r := range s.buf {
+		if err := enc.Encode(&spillRecord{Key: r.Key, Value: r.Value}); err != nil {
+			return err
+		}
+	}
+	s.buf = s.buf[:0]
+	return nil
+}
+
+func (s *recordSorter) sortBuf() {
+	sort.Slice(s.buf, func(i, j int) bool {
+		return s.less(s.buf[i], s.buf[j])
+	})
+}
+
+// finish 按顺序将record交给fn，fn返回false时停止，最后删除临时文件。
+// 归并时每个临时文件只读取当前需要比较的record
+func (s *recordSorter) finish(fn func(r *Record) bool) error {
+	s.sortBuf()
+	if len(s.runs) == 0 {
+		for _, r := range s.buf {
+			if !fn(r) {
+				break
+			}
+		}
+		return nil
+	}
+	defer s.close()
+
+	// 每个临时文件以及内存中剩余的部分都是有序的，归并即可
+	h := &mergeHeap{less: s.less}
+	for _, f := range s.runs {
+		if _, err := f.Seek(0, io.SeekStart); err != nil {
+			return err
+		}
+		src := &runSource{dec: gob.NewDecoder(f)}
+		ok, err := src.next()
+		if err != nil {
+			return err
+		}
+		if ok {
+			h.srcs = append(h.srcs, src)
+		}
+	}
+	if len(s.buf) > 0 {
+		src := &runSource{rs: s.buf}
+		src.next()
+		h.srcs = append(h.srcs, src)
+	}
+	heap.Init(h)
+
+	for h.Len() > 0 {
+		src := h.srcs[0]
+		if !fn(src.cur) {
+			return nil
+		}
+		ok, err := src.next()
+		if err != nil {
+			return err
+		}
+		if ok {
+			heap.Fix(h, 0)
+		} else {
+			heap.Pop(h)
+		}
+	}
+	return nil
+}
+
+func (s *recordSorter) close() {
+	for _, f := range s.runs {
+		f.Close()
+		os.Remove(f.Name())
+	}
+	s.runs = nil
+}
+
+// runSource 一个有序的部分，来自临时文件或者内存
+type runSource struct {
+	dec *gob.Decoder
+	rs  []*Record
+	cur *Record
+}
+
+func (src *runSource) next() (bool, error) {
+	if src.dec == nil {
+		if len(src.rs) == 0 {
+			return false, nil
+		}
+		src.cur, src.rs = src.rs[0], src.rs[1:]
+		return true, nil
+	}
+
+	var sr spillRecord
+	if err := src.dec.Decode(&sr); err != nil {
+		if err == io.EOF {
+			return false, nil
+		}
+		return false, err
+	}
+	src.cur = &Record{Key: sr.Key, Value: sr.Value}
+	return true, nil
+}
+
+type maxHeap struct {
+	rs   []*Record
+	less func(a, b *Record) bool
+}
+
+func (h *maxHeap) Len() int           { return len(h.rs) }
+func (h *maxHeap) Less(i, j int) bool { return h.less(h.rs[j], h.rs[i]) }
+func (h *maxHeap) Swap(i, j int)      { h.rs[i], h.rs[j] = h.rs[j], h.rs[i] }
+func (h *maxHeap) Push(x interface{}) { h.rs = append(h.rs, x.(*Record)) }
+func (h *maxHeap) Pop() interface{} {
+	r := h.rs[len(h.rs)-1]
+	h.rs = h.rs[:len(h.rs)-1]
+	return r
+}
+
+type mergeHeap struct {
+	srcs []*runSource
+	less func(a, b *Record) bool
+}
+
+func (h *mergeHeap) Len() int           { return len(h.srcs) }
+func (h *mergeHeap) Less(i, j int) bool { return h.less(h.srcs[i].cur, h.srcs[j].cur) }
+func (h *mergeHeap) Swap(i, j int)      { h.srcs[i], h.srcs[j] = h.srcs[j], h.srcs[i] }
+func (h *mergeHeap) Push(x interface{}) { h.srcs = append(h.srcs, x.(*runSource)) }
+func (h *mergeHeap) Pop() interface{} {
+	src := h.srcs[len(h.srcs)-1]
+	h.srcs = h.srcs[:len(h.srcs)-1]
+	return src
+}
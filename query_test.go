@@ -0,0 +1,136 @@
+package IDB
+
+import (
+	"errors"
+	"strconv"
+	"testing"
+)
+
+func TestSelectWithQueryOptions(t *testing.T) {
+	server := NewIDBServer()
+	err := createPeopleTable(server)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		opts   QueryOptions
+		expect []string
+	}{
+		// INT按数值排序，空值最小
+		{QueryOptions{OrderBy: []OrderBy{{Field: "age"}}}, []string{"dave", "alice", "carol", "bob", "eve_1"}},
+		{QueryOptions{OrderBy: []OrderBy{{Field: "age", Desc: true}}}, []string{"eve_1", "bob", "carol", "alice", "dave"}},
+		{QueryOptions{OrderBy: []OrderBy{{Field: "city"}, {Field: "name", Desc: true}}}, []string{"eve_1", "carol", "alice", "bob", "dave"}},
+		{QueryOptions{OrderBy: []OrderBy{{Field: "age", Desc: true}}, Limit: 2}, []string{"eve_1", "bob"}},
+		{QueryOptions{OrderBy: []OrderBy{{Field: "age"}}, Limit: 2, Offset: 1}, []string{"alice", "carol"}},
+		{QueryOptions{OrderBy: []OrderBy{{Field: "age"}}, Offset: 3}, []string{"bob", "eve_1"}},
+		// 没有排序时按id顺序分页
+		{QueryOptions{Limit: 2, Offset: 2}, []string{"carol", "dave"}},
+		{QueryOptions{Offset: 5}, nil},
+	}
+	for i, test := range tests {
+		records, err := server.SelectWhere("people", nil, test.opts)
+		if err == ErrValueNotFound {
+			records, err = nil, nil
+		}
+		if err != nil {
+			t.Fatalf("%d: %v", i, err)
+		}
+		if len(records) != len(test.expect) {
+			t.Fatalf("%d: expected %v got %v", i, test.expect, records)
+		}
+		for j, r := range records {
+			if r.Value[0] != test.expect[j] {
+				t.Fatalf("%d: expected %v got %v", i, test.expect, records)
+			}
+		}
+	}
+
+	// 投影按指定顺序返回字段
+	records, err := server.SelectByFields("people", map[string]interface{}{"city": "beijing"}, QueryOptions{
+		Fields:  []string{"age", "name"},
+		OrderBy: []OrderBy{{Field: "age", Desc: true}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 2 || len(records[0].Value) != 2 || records[0].Value[0] != "9" || records[0].Value[1] != "carol" || records[1].Key != 1 {
+		t.Fatalf("unexpected %v", records)
+	}
+
+	_, err = server.SelectWhere("people", nil, QueryOptions{OrderBy: []OrderBy{{Field: "height"}}})
+	if !errors.Is(err, ErrFieldNotExist) {
+		t.Fatalf("expected %v got %v", ErrFieldNotExist, err)
+	}
+	_, err = server.SelectWhere("people", nil, QueryOptions{Fields: []string{"height"}})
+	if !errors.Is(err, ErrFieldNotExist) {
+		t.Fatalf("expected %v got %v", ErrFieldNotExist, err)
+	}
+	_, err = server.SelectWhere("people", nil, QueryOptions{Limit: -1})
+	if err != ErrInvalidQueryOptions {
+		t.Fatalf("expected %v got %v", ErrInvalidQueryOptions, err)
+	}
+}
+
+func TestExternalSort(t *testing.T) {
+	// 调小内存中保留的数量，触发写入临时文件
+	old := sortRunSize
+	sortRunSize = 7
+	defer func() {
+		sortRunSize = old
+	}()
+
+	server := NewIDBServer()
+	server.CreateTable("numbers", []*FieldMeta{
+		{name: "n", tp: INT},
+	})
+	count := 100
+	for i := 0; i < count; i++ {
+		// 打乱顺序
+		err := server.Insert("numbers", []interface{}{(i * 37) % count})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	records, err := server.SelectWhere("numbers", Ge("n", 10), QueryOptions{
+		OrderBy: []OrderBy{{Field: "n", Desc: true}},
+		Offset:  5,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != count-15 {
+		t.Fatalf("expected %v got %v", count-15, len(records))
+	}
+	for i, r := range records {
+		if r.Value[0] != strconv.Itoa(count-6-i) {
+			t.Fatalf("expected %v got %v", count-6-i, r.Value[0])
+		}
+	}
+
+	// 归并时fn返回false后不再读取
+	sorter := newRecordSorter(func(a, b *Record) bool {
+		return a.Key < b.Key
+	}, 0)
+	defer sorter.close()
+	for i := count; i > 0; i-- {
+		if err = sorter.add(&Record{Key: i}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var keys []int
+	err = sorter.finish(func(r *Record) bool {
+		keys = append(keys, r.Key)
+		return len(keys) < 3
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(keys) != 3 || keys[0] != 1 || keys[2] != 3 {
+		t.Fatalf("unexpected %v", keys)
+	}
+	if len(sorter.runs) != 0 {
+		t.Fatalf("expected %v got %v", 0, len(sorter.runs))
+	}
+}
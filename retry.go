@@ -0,0 +1,99 @@
+package IDB
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"time"
+)
+
+const (
+	defaultMaxRetries = 10
+	defaultBaseDelay  = time.Millisecond
+	defaultMaxDelay   = 100 * time.Millisecond
+)
+
+// RetryPolicy RunInTx遇到可以重试的错误时的重试策略，字段为0时使用默认值
+type RetryPolicy struct {
+	// 最多重试次数，小于0时不重试
+	MaxRetries int
+	// 第一次重试前的等待时间，之后每次翻倍，不超过MaxDelay
+	BaseDelay time.Duration
+	MaxDelay  time.Duration
+	// 每次重试前调用，attempt从1开始，err为导致重试的错误，delay为等待时间
+	OnRetry func(attempt int, err error, delay time.Duration)
+}
+
+// IsRetryable 事务因为与并发事务冲突而失败，重新执行整个事务可能成功
+func IsRetryable(err error) bool {
+	return errors.Is(err, ErrWriteConflict) || errors.Is(err, ErrSerializationFailure) || errors.Is(err, ErrDeadlock)
+}
+
+// delay 第attempt次重试前的等待时间。在指数退避的后一半中随机选择，避免冲突的事务同时重试
+func (p RetryPolicy) delay(attempt int) time.Duration {
+	base, max := p.BaseDelay, p.MaxDelay
+	if base <= 0 {
+		base = defaultBaseDelay
+	}
+	if max <= 0 {
+		max = defaultMaxDelay
+	}
+	d := base
+	for i := 1; i < attempt && d < max; i++ {
+		d *= 2
+	}
+	if d > max {
+		d = max
+	}
+	half := d / 2
+	return half + time.Duration(rand.Int63n(int64(d-half)+1))
+}
+
+func (p RetryPolicy) maxRetries() int {
+	if p.MaxRetries == 0 {
+		return defaultMaxRetries
+	}
+	return p.MaxRetries
+}
+
+// RunInTx 在事务中执行fn，fn返回nil时提交，否则回滚。fn或者提交返回可以重试的错误时，按opts.Retry等待后在新的事务中重新执行，
+// 重试次数用完后返回最后的错误。ctx结束时不再重试，返回ctx的错误。fn panic时回滚后继续panic
+func (s *idbServer) RunInTx(ctx context.Context, opts TxOptions, fn func(tx *Tx) error) error {
+	for attempt := 0; ; attempt++ {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		err := s.runInTx(opts, fn)
+		if err == nil || !IsRetryable(err) || attempt >= opts.Retry.maxRetries() {
+			return err
+		}
+
+		d := opts.Retry.delay(attempt + 1)
+		if opts.Retry.OnRetry != nil {
+			opts.Retry.OnRetry(attempt+1, err, d)
+		}
+		timer := time.NewTimer(d)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		}
+	}
+}
+
+// runInTx 执行一次事务
+func (s *idbServer) runInTx(opts TxOptions, fn func(tx *Tx) error) error {
+	tx := s.Begin(opts)
+	defer func() {
+		if r := recover(); r != nil {
+			tx.Rollback()
+			panic(r)
+		}
+	}()
+	if err := fn(tx); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
@@ -0,0 +1,128 @@
+package IDB
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestRunInTx(t *testing.T) {
+	server, _ := createAccounts(t)
+	ctx := context.Background()
+
+	// 第一次执行时并发的事务先修改了同一行，提交时写冲突后重试
+	deposit := func(opts TxOptions, conflicts int) (int, error) {
+		attempts := 0
+		err := server.RunInTx(ctx, opts, func(tx *Tx) error {
+			attempts++
+			b, _ := strconv.Atoi(balanceTx(t, server, tx, 1))
+			if attempts <= conflicts {
+				err := server.RunInTx(ctx, TxOptions{}, func(other *Tx) error {
+					return server.UpdateByIDTx(other, "accounts", map[string]interface{}{"balance": 0}, 1)
+				})
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+			return server.UpdateByIDTx(tx, "accounts", map[string]interface{}{"balance": b + 1}, 1)
+		})
+		return attempts, err
+	}
+	var retries []int
+	opts := TxOptions{Retry: RetryPolicy{OnRetry: func(attempt int, err error, delay time.Duration) {
+		if !IsRetryable(err) || delay > defaultBaseDelay {
+			t.Fatalf("unexpected %v %v", err, delay)
+		}
+		retries = append(retries, attempt)
+	}}}
+	attempts, err := deposit(opts, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if attempts != 2 || len(retries) != 1 || retries[0] != 1 {
+		t.Fatalf("unexpected %v %v", attempts, retries)
+	}
+	if b := balanceTx(t, server, server.Begin(), 1); b != "1" {
+		t.Fatalf("expected %v got %v", "1", b)
+	}
+
+	// 重试次数用完后返回最后的错误
+	for maxRetries, expected := range map[int]int{-1: 1, 2: 3} {
+		attempts, err = deposit(TxOptions{Retry: RetryPolicy{MaxRetries: maxRetries}}, 10)
+		if !errors.Is(err, ErrWriteConflict) || attempts != expected {
+			t.Fatalf("unexpected %v %v", err, attempts)
+		}
+	}
+
+	// 不能重试的错误直接返回，事务已经回滚
+	attempts = 0
+	err = server.RunInTx(ctx, TxOptions{Isolation: READ_COMMITTED}, func(tx *Tx) error {
+		attempts++
+		if tx.level != READ_COMMITTED {
+			t.Fatalf("expected %v got %v", READ_COMMITTED, tx.level)
+		}
+		server.UpdateByIDTx(tx, "accounts", map[string]interface{}{"balance": 7}, 2)
+		return ErrLockTimeout
+	})
+	if err != ErrLockTimeout || attempts != 1 {
+		t.Fatalf("unexpected %v %v", err, attempts)
+	}
+	if b := balanceTx(t, server, server.Begin(), 2); b != "100" {
+		t.Fatalf("expected %v got %v", "100", b)
+	}
+
+	// 等待重试时ctx结束
+	cctx, cancel := context.WithCancel(ctx)
+	err = server.RunInTx(cctx, TxOptions{Retry: RetryPolicy{BaseDelay: time.Hour, MaxDelay: time.Hour, OnRetry: func(int, error, time.Duration) {
+		cancel()
+	}}}, func(tx *Tx) error {
+		return ErrDeadlock
+	})
+	if err != context.Canceled {
+		t.Fatalf("expected %v got %v", context.Canceled, err)
+	}
+}
+
+func TestRunInTxConcurrent(t *testing.T) {
+	server, _ := createAccounts(t)
+
+	// SNAPSHOT事务加锁后发现该行已经被修改，重试后存款不会丢失
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			err := server.RunInTx(context.Background(), TxOptions{Retry: RetryPolicy{MaxRetries: 100}}, func(tx *Tx) error {
+				r, err := server.SelectByIDForUpdateTx(tx, "accounts", 1)
+				if err != nil {
+					return err
+				}
+				balance, _ := strconv.Atoi(r.Value[1])
+				return server.UpdateByIDTx(tx, "accounts", map[string]interface{}{"balance": balance + 1}, 1)
+			})
+			if err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+	r, _ := server.SelectByID("accounts", 1)
+	if r.Value[1] != "110" {
+		t.Fatalf("expected %v got %v", "110", r.Value[1])
+	}
+}
+
+func TestRetryDelay(t *testing.T) {
+	p := RetryPolicy{BaseDelay: 10 * time.Millisecond, MaxDelay: 40 * time.Millisecond}
+	for attempt, max := range []time.Duration{10, 20, 40, 40, 40} {
+		max *= time.Millisecond
+		for i := 0; i < 100; i++ {
+			if d := p.delay(attempt + 1); d < max/2 || d > max {
+				t.Fatalf("attempt %v: unexpected %v", attempt+1, d)
+			}
+		}
+	}
+}
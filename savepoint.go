@@ -0,0 +1,98 @@
+package IDB
+
+import "errors"
+
+var (
+	ErrNoSavepoint = errors.New("transaction: no such savepoint")
+)
+
+// savepoint 保存点时事务缓存的副本以及持有的行锁
+type savepoint struct {
+	name  string
+	cache map[string]map[int]*OpRecord
+	locks map[rowKey]LockMode
+}
+
+// Savepoint 在事务中建立保存点，同名的保存点可以重复建立，之后的操作使用最近的一个
+func (tx *Tx) Savepoint(name string) {
+	tx.savepoints = append(tx.savepoints, &savepoint{
+		name:  name,
+		cache: copyTxCache(tx.cache),
+		locks: tx.locks.heldLocks(tx.id),
+	})
+}
+
+// RollbackTo 撤销保存点之后的修改，释放之后获得的行锁，之后建立的保存点也被删除，该保存点仍然保留。
+// 事务中插入时已经分配的自增id不会收回
+func (tx *Tx) RollbackTo(name string) error {
+	i := tx.findSavepoint(name)
+	if i < 0 {
+		return ErrNoSavepoint
+	}
+	sp := tx.savepoints[i]
+	tx.savepoints = tx.savepoints[:i+1]
+
+	for tableName, c := range tx.cache {
+		saved, ok := sp.cache[tableName]
+		if !ok {
+			delete(tx.cache, tableName)
+			continue
+		}
+		c.cache = copyOpRecords(saved)
+	}
+	tx.locks.releaseExcept(tx.id, sp.locks)
+	return nil
+}
+
+// Release 删除保存点以及之后建立的保存点，不影响已经执行的修改
+func (tx *Tx) Release(name string) error {
+	i := tx.findSavepoint(name)
+	if i < 0 {
+		return ErrNoSavepoint
+	}
+	tx.savepoints = tx.savepoints[:i]
+	return nil
+}
+
+func (tx *Tx) findSavepoint(name string) int {
+	for i := len(tx.savepoints) - 1; i >= 0; i-- {
+		if tx.savepoints[i].name == name {
+			return i
+		}
+	}
+	return -1
+}
+
+// copyTxCache 复制每个表的操作记录，保存点之后的修改会原地修改OpRecord
+func copyTxCache(cache map[string]*txCache) map[string]map[int]*OpRecord {
+	copied := make(map[string]map[int]*OpRecord, len(cache))
+	for tableName, c := range cache {
+		copied[tableName] = copyOpRecords(c.cache)
+	}
+	return copied
+}
+
+func copyOpRecords(rcs map[int]*OpRecord) map[int]*OpRecord {
+	copied := make(map[int]*OpRecord, len(rcs))
+	for id, rc := range rcs {
+		copied[id] = copyOpRecord(rc)
+	}
+	return copied
+}
+
+func copyOpRecord(rc *OpRecord) *OpRecord {
+	copied := &OpRecord{op: rc.op, LastTxID: rc.LastTxID}
+	switch change := rc.opChange.(type) {
+	case *InsertOpChange:
+		copied.opChange = &InsertOpChange{record: copyRecord(change.record)}
+	case *UpdateOpChange:
+		data := make(map[int]string, len(change.change))
+		for i, v := range change.change {
+			data[i] = v
+		}
+		copied.opChange = &UpdateOpChange{change: data, record: copyRecord(change.record)}
+	case *DeleteOpChange:
+		copied.opChange = &DeleteOpChange{id: change.id}
+	}
+	return copied
+}
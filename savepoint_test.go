@@ -0,0 +1,135 @@
+package IDB
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSavepoint(t *testing.T) {
+	server, tm := createAccounts(t)
+	_, err := server.Exec("CREATE TABLE logs (id INT PRIMARY KEY, msg STRING)")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tx := tm.StartTransaction()
+	err = server.UpdateByIDTx(tx, "accounts", map[string]interface{}{"balance": 10}, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 查询后UpdateOpChange中缓存了record
+	if b := balanceTx(t, server, tx, 1); b != "10" {
+		t.Fatalf("expected %v got %v", "10", b)
+	}
+	tx.Savepoint("a")
+
+	err = server.UpdateByIDTx(tx, "accounts", map[string]interface{}{"balance": 20}, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = server.DeleteByIDTx(tx, "accounts", 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = server.InsertTx(tx, "accounts", []interface{}{3, 30})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = server.InsertTx(tx, "logs", []interface{}{1, "hello"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = server.LockRowTx(tx, "accounts", 2, EXCLUSIVE_LOCK)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tx.Savepoint("b")
+	if b := balanceTx(t, server, tx, 1); b != "20" {
+		t.Fatalf("expected %v got %v", "20", b)
+	}
+
+	// 回滚到a之后b也被删除，a可以再次回滚
+	for i := 0; i < 2; i++ {
+		err = tx.RollbackTo("a")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if b := balanceTx(t, server, tx, 1); b != "10" {
+			t.Fatalf("expected %v got %v", "10", b)
+		}
+		if b := balanceTx(t, server, tx, 2); b != "100" {
+			t.Fatalf("expected %v got %v", "100", b)
+		}
+		if count, _ := sumBalanceTx(t, server, tx); count != 2 {
+			t.Fatalf("expected %v got %v", 2, count)
+		}
+		if _, ok := tx.cache["logs"]; ok {
+			t.Fatal("expected no logs cache")
+		}
+		err = server.UpdateByIDTx(tx, "accounts", map[string]interface{}{"balance": 40}, 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	err = tx.RollbackTo("b")
+	if err != ErrNoSavepoint {
+		t.Fatalf("expected %v got %v", ErrNoSavepoint, err)
+	}
+
+	// 保存点之后获得的行锁已经释放
+	other := tm.StartTransactionWithOptions(SNAPSHOT, TxOptions{LockTimeout: time.Millisecond})
+	err = server.LockRowTx(other, "accounts", 2, EXCLUSIVE_LOCK)
+	if err != nil {
+		t.Fatal(err)
+	}
+	other.Rollback()
+
+	err = tx.Release("a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = tx.RollbackTo("a")
+	if err != ErrNoSavepoint {
+		t.Fatalf("expected %v got %v", ErrNoSavepoint, err)
+	}
+	err = tx.Commit()
+	if err != nil {
+		t.Fatal(err)
+	}
+	records, err := server.SelectWhere("accounts", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 2 || records[0].Value[1] != "40" || records[1].Value[1] != "100" {
+		t.Fatalf("unexpected %v", records)
+	}
+}
+
+func TestSavepointSQL(t *testing.T) {
+	server, _ := createAccounts(t)
+	ss := server.NewSession()
+	for _, sql := range []string{
+		"BEGIN",
+		"INSERT INTO accounts VALUES (3, 30)",
+		"SAVEPOINT s",
+		"INSERT INTO accounts VALUES (4, 40)",
+		"ROLLBACK TO SAVEPOINT s",
+		"INSERT INTO accounts VALUES (5, 50)",
+		"RELEASE s",
+		"COMMIT",
+	} {
+		if _, err := ss.Exec(sql); err != nil {
+			t.Fatalf("%s: %v", sql, err)
+		}
+	}
+	_, err := ss.Exec("SAVEPOINT s")
+	if err != ErrNoTx {
+		t.Fatalf("expected %v got %v", ErrNoTx, err)
+	}
+	rows, err := ss.Query("SELECT id FROM accounts")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rows.Records) != 4 || rows.Records[2].Value[0] != "3" || rows.Records[3].Value[0] != "5" {
+		t.Fatalf("unexpected %v", rows.Records)
+	}
+}
@@ -0,0 +1,430 @@
+package IDB
+
+import (
+	"context"
+	"errors"
+	"strings"
+)
+
+var (
+	ErrArgCount     = errors.New("sql: wrong number of arguments")
+	ErrNotQuery     = errors.New("sql: statement does not return rows")
+	ErrTxInProgress = errors.New("sql: transaction already in progress")
+	ErrNoTx         = errors.New("sql: no transaction in progress")
+)
+
+// Result Exec的执行结果
+type Result struct {
+	RowsAffected int
+}
+
+// Rows Query的查询结果，Records中的值与Columns顺序一致
+type Rows struct {
+	Columns []string
+	Records []*Record
+}
+
+// Session SQL会话，保存BEGIN开始的事务。不能并发使用。
+// 表结构变更立即生效，不属于事务
+type Session struct {
+	s  *idbServer
+	tx *Tx
+}
+
+// NewSession 创建SQL会话
+func (s *idbServer) NewSession() *Session {
+	return &Session{s: s}
+}
+
+// Exec 在默认会话中执行SQL，args按顺序绑定到占位符?
+func (s *idbServer) Exec(sql string, args ...interface{}) (*Result, error) {
+	s.sessionMu.Lock()
+	defer s.sessionMu.Unlock()
+	return s.session.Exec(sql, args...)
+}
+
+// Query 在默认会话中执行SELECT
+func (s *idbServer) Query(sql string, args ...interface{}) (*Rows, error) {
+	s.sessionMu.Lock()
+	defer s.sessionMu.Unlock()
+	return s.session.Query(sql, args...)
+}
+
+// Exec 执行SQL，SELECT返回查询到的数量
+func (ss *Session) Exec(sql string, args ...interface{}) (*Result, error) {
+	stmt, err := ss.prepare(sql, args)
+	if err != nil {
+		return nil, err
+	}
+
+	switch stmt := stmt.(type) {
+	case *createTableStmt:
+		return &Result{}, ss.s.CreateTable(stmt.table, stmt.fields)
+	case *dropTableStmt:
+		err = ss.s.DropTable(stmt.table)
+		if err == ErrTableNotExist && stmt.ifExists {
+			err = nil
+		}
+		return &Result{}, err
+	case *insertStmt:
+		return ss.execInsert(stmt, args)
+	case *selectStmt, *explainStmt:
+		rows, err := ss.execQuery(stmt, args)
+		if err != nil {
+			return nil, err
+		}
+		return &Result{RowsAffected: len(rows.Records)}, nil
+	case *updateStmt:
+		return ss.execUpdate(stmt, args)
+	case *deleteStmt:
+		return ss.execDelete(stmt, args)
+	case *txStmt:
+		return &Result{}, ss.execTx(stmt)
+	}
+	return nil, ErrInvalidOp
+}
+
+// Query 执行SELECT或者EXPLAIN，没有数据时返回空的Rows
+func (ss *Session) Query(sql string, args ...interface{}) (*Rows, error) {
+	stmt, err := ss.prepare(sql, args)
+	if err != nil {
+		return nil, err
+	}
+	return ss.execQuery(stmt, args)
+}
+
+func (ss *Session) execQuery(stmt interface{}, args []interface{}) (*Rows, error) {
+	switch stmt := stmt.(type) {
+	case *selectStmt:
+		return ss.execSelect(stmt, args)
+	case *explainStmt:
+		return ss.execExplain(stmt, args)
+	}
+	return nil, ErrNotQuery
+}
+
+func (ss *Session) prepare(sql string, args []interface{}) (interface{}, error) {
+	stmt, params, err := parseSQL(sql)
+	if err != nil {
+		return nil, err
+	}
+	if params != len(args) {
+		return nil, ErrArgCount
+	}
+	return stmt, nil
+}
+
+func (ss *Session) execTx(stmt *txStmt) error {
+	switch stmt.op {
+	case "BEGIN":
+		if ss.tx != nil {
+			return ErrTxInProgress
+		}
+		ss.tx = ss.s.Begin()
+		return nil
+	case "COMMIT":
+		if ss.tx == nil {
+			return ErrNoTx
+		}
+		tx := ss.tx
+		ss.tx = nil
+		return tx.Commit()
+	case "ROLLBACK":
+		if ss.tx == nil {
+			return ErrNoTx
+		}
+		tx := ss.tx
+		ss.tx = nil
+		return tx.Rollback()
+	}
+
+	if ss.tx == nil {
+		return ErrNoTx
+	}
+	switch stmt.op {
+	case "SAVEPOINT":
+		ss.tx.Savepoint(stmt.savepoint)
+		return nil
+	case "ROLLBACK TO":
+		return ss.tx.RollbackTo(stmt.savepoint)
+	default:
+		return ss.tx.Release(stmt.savepoint)
+	}
+}
+
+func (ss *Session) execInsert(stmt *insertStmt, args []interface{}) (*Result, error) {
+	t, err := ss.s.getTable(stmt.table)
+	if err != nil {
+		return nil, err
+	}
+	fields := t.meta.getFields()
+
+	for _, row := range stmt.rows {
+		if stmt.columns == nil {
+			// 与Insert一致
+			if len(row) != len(fields) {
+				return nil, ErrFieldRequired
+			}
+			data := make([]interface{}, len(row))
+			for i, v := range row {
+				if data[i], err = bindColumn(fields[i], v, args); err != nil {
+					return nil, err
+				}
+			}
+			if ss.tx == nil {
+				err = ss.s.Insert(stmt.table, data)
+			} else {
+				err = ss.s.InsertTx(ss.tx, stmt.table, data)
+			}
+		} else {
+			values, bindErr := bindColumns(fields, stmt.columns, row, args)
+			if bindErr != nil {
+				return nil, bindErr
+			}
+			if ss.tx == nil {
+				err = ss.s.InsertMap(stmt.table, values)
+			} else {
+				err = ss.s.InsertMapTx(ss.tx, stmt.table, values)
+			}
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	return &Result{RowsAffected: len(stmt.rows)}, nil
+}
+
+// selectArgs 绑定参数，生成SELECT的条件以及查询选项
+func selectArgs(stmt *selectStmt, args []interface{}) (Expr, QueryOptions, error) {
+	opt := QueryOptions{Fields: stmt.fields, OrderBy: stmt.orderBy}
+	expr, err := condToExpr(stmt.where, args)
+	if err != nil {
+		return nil, opt, err
+	}
+	if opt.Limit, err = bindInt(stmt.limit, args); err != nil {
+		return nil, opt, err
+	}
+	if opt.Offset, err = bindInt(stmt.offset, args); err != nil {
+		return nil, opt, err
+	}
+	return expr, opt, nil
+}
+
+func (ss *Session) execSelect(stmt *selectStmt, args []interface{}) (*Rows, error) {
+	t, err := ss.s.getTable(stmt.table)
+	if err != nil {
+		return nil, err
+	}
+	expr, opt, err := selectArgs(stmt, args)
+	if err != nil {
+		return nil, err
+	}
+
+	rows := &Rows{Columns: stmt.fields}
+	if rows.Columns == nil {
+		for _, f := range t.meta.getFields() {
+			rows.Columns = append(rows.Columns, f.name)
+		}
+	}
+	if stmt.forUpdate && ss.tx != nil {
+		rows.Records, err = ss.selectForUpdate(stmt.table, expr, opt)
+	} else {
+		rows.Records, err = ss.s.selectWhere(ss.tx, stmt.table, expr, []QueryOptions{opt})
+	}
+	if err == ErrValueNotFound {
+		return rows, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return rows, nil
+}
+
+// selectForUpdate 给查询到的record加排他锁。READ_COMMITTED事务加锁后重新查询，直到查询到的record都已经加锁
+func (ss *Session) selectForUpdate(tableName string, expr Expr, opt QueryOptions) ([]*Record, error) {
+	locked := make(map[int]bool)
+	for {
+		records, err := ss.s.selectWhere(ss.tx, tableName, expr, []QueryOptions{opt})
+		if err != nil {
+			return nil, err
+		}
+		var ids []int
+		for _, r := range records {
+			if !locked[r.Key] {
+				ids = append(ids, r.Key)
+				locked[r.Key] = true
+			}
+		}
+		if len(ids) == 0 {
+			return records, nil
+		}
+		if _, err = ss.s.lockRowsTx(context.Background(), ss.tx, tableName, ids); err != nil {
+			return nil, err
+		}
+		if ss.tx.level != READ_COMMITTED {
+			return records, nil
+		}
+	}
+}
+
+// execExplain 执行计划每行作为一条record返回。执行计划不区分事务，ANALYZE在事务外执行
+func (ss *Session) execExplain(stmt *explainStmt, args []interface{}) (*Rows, error) {
+	plan, err := ss.s.explain(stmt.sel, stmt.analyze, args)
+	if err != nil {
+		return nil, err
+	}
+	rows := &Rows{Columns: []string{"plan"}}
+	for i, line := range strings.Split(strings.TrimSuffix(plan, "\n"), "\n") {
+		rows.Records = append(rows.Records, &Record{Key: i + 1, Value: []string{line}})
+	}
+	return rows, nil
+}
+
+func (ss *Session) execUpdate(stmt *updateStmt, args []interface{}) (*Result, error) {
+	t, err := ss.s.getTable(stmt.table)
+	if err != nil {
+		return nil, err
+	}
+	values, err := bindColumns(t.meta.getFields(), stmt.columns, stmt.values, args)
+	if err != nil {
+		return nil, err
+	}
+	expr, err := condToExpr(stmt.where, args)
+	if err != nil {
+		return nil, err
+	}
+
+	var affected int
+	if ss.tx == nil {
+		affected, err = ss.s.UpdateWhere(stmt.table, expr, values)
+	} else {
+		affected, err = ss.s.UpdateWhereTx(ss.tx, stmt.table, expr, values)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &Result{RowsAffected: affected}, nil
+}
+
+func (ss *Session) execDelete(stmt *deleteStmt, args []interface{}) (*Result, error) {
+	expr, err := condToExpr(stmt.where, args)
+	if err != nil {
+		return nil, err
+	}
+
+	var affected int
+	if ss.tx == nil {
+		affected, err = ss.s.DeleteWhere(stmt.table, expr)
+	} else {
+		affected, err = ss.s.DeleteWhereTx(ss.tx, stmt.table, expr)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &Result{RowsAffected: affected}, nil
+}
+
+// bindValue 绑定占位符，字面量直接返回
+func bindValue(v sqlValue, args []interface{}) interface{} {
+	if v.isParam {
+		return args[v.param]
+	}
+	return v.lit
+}
+
+// bindColumn 按字段类型转换值，INT字段也接受数字字符串
+func bindColumn(f *FieldMeta, v sqlValue, args []interface{}) (interface{}, error) {
+	value := bindValue(v, args)
+	if value == nil {
+		return nil, nil
+	}
+	return exprValue(f, value)
+}
+
+func bindColumns(fields []*FieldMeta, columns []string, row []sqlValue, args []interface{}) (map[string]interface{}, error) {
+	values := make(map[string]interface{}, len(columns))
+	for i, column := range columns {
+		f, err := exprField(fields, column)
+		if err != nil {
+			return nil, err
+		}
+		if values[column], err = bindColumn(f, row[i], args); err != nil {
+			return nil, err
+		}
+	}
+	return values, nil
+}
+
+// bindInt 绑定LIMIT、OFFSET，为nil时返回0
+func bindInt(v *sqlValue, args []interface{}) (int, error) {
+	if v == nil {
+		return 0, nil
+	}
+	switch n := bindValue(*v, args).(type) {
+	case int:
+		return n, nil
+	case int64:
+		return int(n), nil
+	case int32:
+		return int(n), nil
+	}
+	return 0, ErrInvalidQueryOptions
+}
+
+// condToExpr 绑定参数并将WHERE条件转换为Expr，字段类型在Expr绑定表结构时检查
+func condToExpr(c sqlCond, args []interface{}) (Expr, error) {
+	switch c := c.(type) {
+	case nil:
+		return nil, nil
+	case *cmpCond:
+		return &cmpExpr{op: c.op, field: c.column, value: bindValue(c.value, args)}, nil
+	case *inCond:
+		values := make([]interface{}, len(c.values))
+		for i, v := range c.values {
+			values[i] = bindValue(v, args)
+		}
+		return notNull(c.column, In(c.column, values...), c.not), nil
+	case *betweenCond:
+		lo, hi := bindValue(c.lo, args), bindValue(c.hi, args)
+		return notNull(c.column, Between(c.column, lo, hi), c.not), nil
+	case *likeCond:
+		pattern, ok := bindValue(c.pattern, args).(string)
+		if !ok {
+			return nil, &FieldError{Field: c.column, Err: ErrMismatchFieldType}
+		}
+		return notNull(c.column, Like(c.column, pattern), c.not), nil
+	case *nullCond:
+		if c.not {
+			return Ne(c.column, nil), nil
+		}
+		return IsNull(c.column), nil
+	case *logicCond:
+		left, err := condToExpr(c.left, args)
+		if err != nil {
+			return nil, err
+		}
+		right, err := condToExpr(c.right, args)
+		if err != nil {
+			return nil, err
+		}
+		if c.and {
+			return And(left, right), nil
+		}
+		return Or(left, right), nil
+	case *notCond:
+		e, err := condToExpr(c.cond, args)
+		if err != nil {
+			return nil, err
+		}
+		return Not(e), nil
+	}
+	return nil, ErrInvalidExpr
+}
+
+// notNull NOT IN、NOT BETWEEN、NOT LIKE与SQL一致，不匹配空值
+func notNull(column string, e Expr, not bool) Expr {
+	if !not {
+		return e
+	}
+	return And(Ne(column, nil), Not(e))
+}
@@ -0,0 +1,181 @@
+package IDB
+
+import (
+	"errors"
+	"testing"
+)
+
+func queryColumn(t *testing.T, ss *Session, sql string, args ...interface{}) []string {
+	rows, err := ss.Query(sql, args...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	values := make([]string, len(rows.Records))
+	for i, r := range rows.Records {
+		values[i] = r.Value[0]
+	}
+	return values
+}
+
+func TestExecSQL(t *testing.T) {
+	server := NewIDBServer()
+	_, err := server.Exec("CREATE TABLE people (name STRING NOT NULL, age INT, city STRING DEFAULT 'beijing')")
+	if err != nil {
+		t.Fatal(err)
+	}
+	res, err := server.Exec("INSERT INTO people VALUES ('alice', 3, 'shanghai'), (?, ?, ?)", "bob", 25, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.RowsAffected != 2 {
+		t.Fatalf("expected %v got %v", 2, res.RowsAffected)
+	}
+	// 指定字段时使用默认值，INT字段绑定数字字符串
+	_, err = server.Exec("INSERT INTO people (name, age) VALUES ('carol', ?)", "9")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rows, err := server.Query("SELECT name, city FROM people WHERE age > ? ORDER BY age DESC", 5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rows.Columns) != 2 || len(rows.Records) != 2 || rows.Records[0].Value[0] != "bob" || rows.Records[1].Value[1] != "beijing" {
+		t.Fatalf("unexpected %v %v", rows.Columns, rows.Records)
+	}
+
+	ss := server.NewSession()
+	names := queryColumn(t, ss, "SELECT name FROM people WHERE city IS NULL OR name LIKE 'a%'")
+	if len(names) != 2 || names[0] != "alice" || names[1] != "bob" {
+		t.Fatalf("unexpected %v", names)
+	}
+	names = queryColumn(t, ss, "SELECT name FROM people WHERE age NOT IN (3, 9) ORDER BY name LIMIT ?", 1)
+	if len(names) != 1 || names[0] != "bob" {
+		t.Fatalf("unexpected %v", names)
+	}
+
+	res, err = server.Exec("UPDATE people SET city = 'shenzhen', age = 10 WHERE age BETWEEN 5 AND 30")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.RowsAffected != 2 {
+		t.Fatalf("expected %v got %v", 2, res.RowsAffected)
+	}
+	res, err = server.Exec("DELETE FROM people WHERE city = ?", "shenzhen")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.RowsAffected != 2 {
+		t.Fatalf("expected %v got %v", 2, res.RowsAffected)
+	}
+	names = queryColumn(t, ss, "SELECT name FROM people")
+	if len(names) != 1 || names[0] != "alice" {
+		t.Fatalf("unexpected %v", names)
+	}
+
+	// 参数数量以及类型
+	_, err = server.Exec("INSERT INTO people VALUES (?, ?, ?)", "dave", 1)
+	if err != ErrArgCount {
+		t.Fatalf("expected %v got %v", ErrArgCount, err)
+	}
+	_, err = server.Exec("INSERT INTO people VALUES (?, ?, ?)", "dave", "old", nil)
+	if !errors.Is(err, ErrMismatchFieldType) {
+		t.Fatalf("expected %v got %v", ErrMismatchFieldType, err)
+	}
+	_, err = server.Query("SELECT * FROM people WHERE height = 1")
+	if !errors.Is(err, ErrFieldNotExist) {
+		t.Fatalf("expected %v got %v", ErrFieldNotExist, err)
+	}
+	_, err = server.Query("DELETE FROM people")
+	if err != ErrNotQuery {
+		t.Fatalf("expected %v got %v", ErrNotQuery, err)
+	}
+	_, err = server.Exec("SELEC * FROM people")
+	if !errors.Is(err, ErrSyntax) {
+		t.Fatalf("expected %v got %v", ErrSyntax, err)
+	}
+
+	_, err = server.Exec("DROP TABLE people")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = server.Exec("DROP TABLE IF EXISTS people")
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestExecSQLInTx(t *testing.T) {
+	server := NewIDBServer()
+	_, err := server.Exec("CREATE TABLE accounts (name STRING PRIMARY KEY, balance INT)")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = server.Exec("INSERT INTO accounts VALUES ('alice', 100), ('bob', 50)")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ss1 := server.NewSession()
+	ss2 := server.NewSession()
+	_, err = ss1.Exec("BEGIN")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = ss1.Exec("BEGIN")
+	if err != ErrTxInProgress {
+		t.Fatalf("expected %v got %v", ErrTxInProgress, err)
+	}
+	_, err = ss1.Exec("UPDATE accounts SET balance = 70 WHERE name = 'alice'")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = ss1.Exec("INSERT INTO accounts VALUES ('carol', 30)")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 事务内可以看到自己的修改，其他会话看不到
+	balances := queryColumn(t, ss1, "SELECT balance FROM accounts ORDER BY name")
+	if len(balances) != 3 || balances[0] != "70" || balances[2] != "30" {
+		t.Fatalf("unexpected %v", balances)
+	}
+	balances = queryColumn(t, ss2, "SELECT balance FROM accounts ORDER BY name")
+	if len(balances) != 2 || balances[0] != "100" {
+		t.Fatalf("unexpected %v", balances)
+	}
+
+	_, err = ss1.Exec("COMMIT")
+	if err != nil {
+		t.Fatal(err)
+	}
+	balances = queryColumn(t, ss2, "SELECT balance FROM accounts ORDER BY name")
+	if len(balances) != 3 || balances[0] != "70" {
+		t.Fatalf("unexpected %v", balances)
+	}
+
+	// 回滚
+	_, err = ss2.Exec("START TRANSACTION")
+	if err != nil {
+		t.Fatal(err)
+	}
+	res, err := ss2.Exec("DELETE FROM accounts WHERE balance < 60")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.RowsAffected != 2 {
+		t.Fatalf("expected %v got %v", 2, res.RowsAffected)
+	}
+	_, err = ss2.Exec("ROLLBACK")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = ss2.Exec("COMMIT")
+	if err != ErrNoTx {
+		t.Fatalf("expected %v got %v", ErrNoTx, err)
+	}
+	balances = queryColumn(t, ss2, "SELECT balance FROM accounts")
+	if len(balances) != 3 {
+		t.Fatalf("unexpected %v", balances)
+	}
+}
@@ -0,0 +1,185 @@
+package IDB
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+var (
+	ErrSyntax = errors.New("sql: syntax error")
+)
+
+// SyntaxError SQL解析错误，Line、Column从1开始
+type SyntaxError struct {
+	// 出错位置在SQL中的字节偏移
+	Pos    int
+	Line   int
+	Column int
+	Msg    string
+}
+
+func (e *SyntaxError) Error() string {
+	return fmt.Sprintf("sql: syntax error at line %d column %d: %s", e.Line, e.Column, e.Msg)
+}
+
+func (e *SyntaxError) Unwrap() error {
+	return ErrSyntax
+}
+
+func newSyntaxError(sql string, pos int, format string, args ...interface{}) *SyntaxError {
+	if pos > len(sql) {
+		pos = len(sql)
+	}
+	line := strings.Count(sql[:pos], "\n") + 1
+	column := pos - strings.LastIndex(sql[:pos], "\n")
+	return &SyntaxError{
+		Pos:    pos,
+		Line:   line,
+		Column: column,
+		Msg:    fmt.Sprintf(format, args...),
+	}
+}
+
+type tokenKind int
+
+const (
+	tokEOF tokenKind = iota
+	// tokIdent 标识符或者关键字，加引号的标识符不会被当作关键字
+	tokIdent
+	tokNumber
+	tokString
+	// tokParam 占位符?
+	tokParam
+	tokSymbol
+)
+
+type token struct {
+	kind   tokenKind
+	text   string
+	pos    int
+	quoted bool
+}
+
+func (t token) String() string {
+	switch t.kind {
+	case tokEOF:
+		return "end of input"
+	case tokString:
+		return fmt.Sprintf("'%s'", t.text)
+	}
+	return fmt.Sprintf("%q", t.text)
+}
+
+// lex 将SQL切分为token，最后一个token为tokEOF
+func lex(sql string) ([]token, error) {
+	var toks []token
+	i := 0
+	for {
+		// 跳过空白以及--注释
+		for i < len(sql) {
+			if isSpace(sql[i]) {
+				i++
+			} else if strings.HasPrefix(sql[i:], "--") {
+				for i < len(sql) && sql[i] != '\n' {
+					i++
+				}
+			} else {
+				break
+			}
+		}
+		if i >= len(sql) {
+			return append(toks, token{kind: tokEOF, pos: i}), nil
+		}
+
+		start := i
+		c := sql[i]
+		switch {
+		case isIdentStart(c):
+			for i < len(sql) && isIdentPart(sql[i]) {
+				i++
+			}
+			toks = append(toks, token{kind: tokIdent, text: sql[start:i], pos: start})
+
+		case isDigit(c):
+			for i < len(sql) && isDigit(sql[i]) {
+				i++
+			}
+			if i < len(sql) && isIdentStart(sql[i]) {
+				return nil, newSyntaxError(sql, i, "invalid number")
+			}
+			toks = append(toks, token{kind: tokNumber, text: sql[start:i], pos: start})
+
+		case c == '\'':
+			// 字符串中两个单引号表示一个单引号
+			var b strings.Builder
+			i++
+			for {
+				if i >= len(sql) {
+					return nil, newSyntaxError(sql, start, "unterminated string")
+				}
+				if sql[i] == '\'' {
+					if i+1 < len(sql) && sql[i+1] == '\'' {
+						b.WriteByte('\'')
+						i += 2
+						continue
+					}
+					i++
+					break
+				}
+				b.WriteByte(sql[i])
+				i++
+			}
+			toks = append(toks, token{kind: tokString, text: b.String(), pos: start})
+
+		case c == '`' || c == '"':
+			end := strings.IndexByte(sql[i+1:], c)
+			if end < 0 {
+				return nil, newSyntaxError(sql, start, "unterminated identifier")
+			}
+			i += end + 2
+			toks = append(toks, token{kind: tokIdent, text: sql[start+1 : i-1], pos: start, quoted: true})
+
+		case c == '?':
+			i++
+			toks = append(toks, token{kind: tokParam, text: "?", pos: start})
+
+		default:
+			n := symbolLen(sql[i:])
+			if n == 0 {
+				return nil, newSyntaxError(sql, i, "unexpected character %q", c)
+			}
+			i += n
+			toks = append(toks, token{kind: tokSymbol, text: sql[start:i], pos: start})
+		}
+	}
+}
+
+// symbolLen 符号的长度，不是符号时返回0
+func symbolLen(s string) int {
+	for _, sym := range []string{"<=", ">=", "<>", "!="} {
+		if strings.HasPrefix(s, sym) {
+			return 2
+		}
+	}
+	if strings.IndexByte("=<>(),*;-", s[0]) >= 0 {
+		return 1
+	}
+	return 0
+}
+
+func isSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\n' || c == '\r'
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+func isIdentStart(c byte) bool {
+	return c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+}
+
+func isIdentPart(c byte) bool {
+	return isIdentStart(c) || isDigit(c)
+}
@@ -0,0 +1,741 @@
+package IDB
+
+import (
+	"strconv"
+	"strings"
+)
+
+type createTableStmt struct {
+	table  string
+	fields []*FieldMeta
+}
+
+type dropTableStmt struct {
+	table    string
+	ifExists bool
+}
+
+type insertStmt struct {
+	table string
+	// 为空时按表字段顺序插入
+	columns []string
+	rows    [][]sqlValue
+}
+
+type selectStmt struct {
+	table string
+	// 为空时返回所有字段
+	fields  []string
+	where   sqlCond
+	orderBy []OrderBy
+	limit   *sqlValue
+	offset  *sqlValue
+	// FOR UPDATE给查询到的record加排他锁
+	forUpdate bool
+}
+
+type updateStmt struct {
+	table   string
+	columns []string
+	values  []sqlValue
+	where   sqlCond
+}
+
+type deleteStmt struct {
+	table string
+	where sqlCond
+}
+
+// explainStmt EXPLAIN [ANALYZE] SELECT ...
+type explainStmt struct {
+	analyze bool
+	sel     *selectStmt
+}
+
+// txStmt BEGIN、COMMIT、ROLLBACK以及保存点
+type txStmt struct {
+	op string
+	// SAVEPOINT、ROLLBACK TO、RELEASE的保存点
+	savepoint string
+}
+
+// sqlValue 字面量或者占位符
+type sqlValue struct {
+	lit     interface{}
+	isParam bool
+	// 第几个占位符
+	param int
+	pos   int
+}
+
+// sqlCond WHERE条件，执行时绑定参数后转换为Expr
+type sqlCond interface{}
+
+type cmpCond struct {
+	op     cmpOp
+	column string
+	value  sqlValue
+}
+
+type inCond struct {
+	column string
+	values []sqlValue
+	not    bool
+}
+
+type betweenCond struct {
+	column string
+	lo, hi sqlValue
+	not    bool
+}
+
+type likeCond struct {
+	column  string
+	pattern sqlValue
+	not     bool
+}
+
+type nullCond struct {
+	column string
+	not    bool
+}
+
+type logicCond struct {
+	and         bool
+	left, right sqlCond
+}
+
+type notCond struct {
+	cond sqlCond
+}
+
+type parser struct {
+	sql    string
+	toks   []token
+	i      int
+	params int
+}
+
+// parseSQL 解析一条SQL，返回语句以及占位符数量
+func parseSQL(sql string) (interface{}, int, error) {
+	toks, err := lex(sql)
+	if err != nil {
+		return nil, 0, err
+	}
+	p := &parser{sql: sql, toks: toks}
+	stmt, err := p.parseStatement()
+	if err != nil {
+		return nil, 0, err
+	}
+	p.acceptSymbol(";")
+	if p.peek().kind != tokEOF {
+		return nil, 0, p.unexpected()
+	}
+	return stmt, p.params, nil
+}
+
+func (p *parser) peek() token {
+	return p.toks[p.i]
+}
+
+func (p *parser) next() token {
+	t := p.toks[p.i]
+	if t.kind != tokEOF {
+		p.i++
+	}
+	return t
+}
+
+func (p *parser) errorf(pos int, format string, args ...interface{}) error {
+	return newSyntaxError(p.sql, pos, format, args...)
+}
+
+func (p *parser) unexpected() error {
+	t := p.peek()
+	return p.errorf(t.pos, "unexpected %v", t)
+}
+
+func (p *parser) isKeyword(kw string) bool {
+	t := p.peek()
+	return t.kind == tokIdent && !t.quoted && strings.EqualFold(t.text, kw)
+}
+
+func (p *parser) acceptKeyword(kw string) bool {
+	if p.isKeyword(kw) {
+		p.i++
+		return true
+	}
+	return false
+}
+
+func (p *parser) expectKeyword(kw string) error {
+	if !p.acceptKeyword(kw) {
+		t := p.peek()
+		return p.errorf(t.pos, "expected %s but got %v", kw, t)
+	}
+	return nil
+}
+
+func (p *parser) acceptSymbol(sym string) bool {
+	t := p.peek()
+	if t.kind == tokSymbol && t.text == sym {
+		p.i++
+		return true
+	}
+	return false
+}
+
+func (p *parser) expectSymbol(sym string) error {
+	if !p.acceptSymbol(sym) {
+		t := p.peek()
+		return p.errorf(t.pos, "expected %q but got %v", sym, t)
+	}
+	return nil
+}
+
+func (p *parser) ident() (string, error) {
+	t := p.peek()
+	if t.kind != tokIdent {
+		return "", p.errorf(t.pos, "expected identifier but got %v", t)
+	}
+	p.i++
+	return t.text, nil
+}
+
+// identList 解析以逗号分隔的标识符
+func (p *parser) identList() ([]string, error) {
+	var names []string
+	for {
+		name, err := p.ident()
+		if err != nil {
+			return nil, err
+		}
+		names = append(names, name)
+		if !p.acceptSymbol(",") {
+			return names, nil
+		}
+	}
+}
+
+func (p *parser) parseStatement() (interface{}, error) {
+	switch {
+	case p.acceptKeyword("CREATE"):
+		return p.parseCreateTable()
+	case p.acceptKeyword("DROP"):
+		return p.parseDropTable()
+	case p.acceptKeyword("INSERT"):
+		return p.parseInsert()
+	case p.acceptKeyword("SELECT"):
+		return p.parseSelect()
+	case p.acceptKeyword("EXPLAIN"):
+		return p.parseExplain()
+	case p.acceptKeyword("UPDATE"):
+		return p.parseUpdate()
+	case p.acceptKeyword("DELETE"):
+		return p.parseDelete()
+	case p.acceptKeyword("BEGIN"), p.acceptKeyword("START"):
+		// START TRANSACTION
+		p.acceptKeyword("TRANSACTION")
+		return &txStmt{op: "BEGIN"}, nil
+	case p.acceptKeyword("COMMIT"):
+		return &txStmt{op: "COMMIT"}, nil
+	case p.acceptKeyword("ROLLBACK"):
+		if !p.acceptKeyword("TO") {
+			return &txStmt{op: "ROLLBACK"}, nil
+		}
+		return p.parseSavepoint("ROLLBACK TO")
+	case p.acceptKeyword("SAVEPOINT"):
+		name, err := p.ident()
+		if err != nil {
+			return nil, err
+		}
+		return &txStmt{op: "SAVEPOINT", savepoint: name}, nil
+	case p.acceptKeyword("RELEASE"):
+		return p.parseSavepoint("RELEASE")
+	}
+	return nil, p.unexpected()
+}
+
+// parseCreateTable CREATE TABLE t (col type [PRIMARY KEY] [AUTO_INCREMENT] [NOT NULL] [UNIQUE] [DEFAULT literal], ...)
+func (p *parser) parseCreateTable() (interface{}, error) {
+	if err := p.expectKeyword("TABLE"); err != nil {
+		return nil, err
+	}
+	table, err := p.ident()
+	if err != nil {
+		return nil, err
+	}
+	if err := p.expectSymbol("("); err != nil {
+		return nil, err
+	}
+
+	stmt := &createTableStmt{table: table}
+	for {
+		f, err := p.parseColumn()
+		if err != nil {
+			return nil, err
+		}
+		stmt.fields = append(stmt.fields, f)
+		if !p.acceptSymbol(",") {
+			break
+		}
+	}
+	if err := p.expectSymbol(")"); err != nil {
+		return nil, err
+	}
+	return stmt, nil
+}
+
+func (p *parser) parseColumn() (*FieldMeta, error) {
+	name, err := p.ident()
+	if err != nil {
+		return nil, err
+	}
+	f := &FieldMeta{name: name}
+
+	t := p.peek()
+	tp, err := p.ident()
+	if err != nil {
+		return nil, err
+	}
+	switch strings.ToUpper(tp) {
+	case "INT", "INTEGER", "BIGINT":
+		f.tp = INT
+	case "STRING", "TEXT", "VARCHAR", "CHAR":
+		f.tp = STRING
+		// VARCHAR(n)中的长度不做限制
+		if p.acceptSymbol("(") {
+			if p.next().kind != tokNumber {
+				return nil, p.errorf(t.pos, "invalid length of %s", tp)
+			}
+			if err := p.expectSymbol(")"); err != nil {
+				return nil, err
+			}
+		}
+	default:
+		return nil, p.errorf(t.pos, "unsupported column type %s", tp)
+	}
+
+	for {
+		switch {
+		case p.acceptKeyword("PRIMARY"):
+			if err := p.expectKeyword("KEY"); err != nil {
+				return nil, err
+			}
+			f.isPrimaryKey = true
+		case p.acceptKeyword("AUTO_INCREMENT"):
+			f.autoIncrement = true
+		case p.acceptKeyword("NOT"):
+			if err := p.expectKeyword("NULL"); err != nil {
+				return nil, err
+			}
+			f.required = true
+		case p.acceptKeyword("UNIQUE"):
+			f.unique = true
+		case p.acceptKeyword("DEFAULT"):
+			v, err := p.parseValue()
+			if err != nil {
+				return nil, err
+			}
+			if v.isParam {
+				return nil, p.errorf(v.pos, "placeholder is not allowed in DEFAULT")
+			}
+			switch lit := v.lit.(type) {
+			case int:
+				f.defaultValue = strconv.Itoa(lit)
+			case string:
+				f.defaultValue = lit
+			}
+		default:
+			return f, nil
+		}
+	}
+}
+
+// parseDropTable DROP TABLE [IF EXISTS] t
+func (p *parser) parseDropTable() (interface{}, error) {
+	if err := p.expectKeyword("TABLE"); err != nil {
+		return nil, err
+	}
+	stmt := &dropTableStmt{}
+	if p.acceptKeyword("IF") {
+		if err := p.expectKeyword("EXISTS"); err != nil {
+			return nil, err
+		}
+		stmt.ifExists = true
+	}
+	table, err := p.ident()
+	if err != nil {
+		return nil, err
+	}
+	stmt.table = table
+	return stmt, nil
+}
+
+// parseInsert INSERT INTO t [(col, ...)] VALUES (v, ...), ...
+func (p *parser) parseInsert() (interface{}, error) {
+	if err := p.expectKeyword("INTO"); err != nil {
+		return nil, err
+	}
+	table, err := p.ident()
+	if err != nil {
+		return nil, err
+	}
+	stmt := &insertStmt{table: table}
+	if p.acceptSymbol("(") {
+		if stmt.columns, err = p.identList(); err != nil {
+			return nil, err
+		}
+		if err := p.expectSymbol(")"); err != nil {
+			return nil, err
+		}
+	}
+	if err := p.expectKeyword("VALUES"); err != nil {
+		return nil, err
+	}
+
+	for {
+		start := p.peek()
+		if err := p.expectSymbol("("); err != nil {
+			return nil, err
+		}
+		row, err := p.valueList()
+		if err != nil {
+			return nil, err
+		}
+		if err := p.expectSymbol(")"); err != nil {
+			return nil, err
+		}
+		if stmt.columns != nil && len(row) != len(stmt.columns) {
+			return nil, p.errorf(start.pos, "expected %d values but got %d", len(stmt.columns), len(row))
+		}
+		stmt.rows = append(stmt.rows, row)
+		if !p.acceptSymbol(",") {
+			return stmt, nil
+		}
+	}
+}
+
+// parseExplain EXPLAIN [ANALYZE] SELECT ...
+func (p *parser) parseExplain() (interface{}, error) {
+	stmt := &explainStmt{analyze: p.acceptKeyword("ANALYZE")}
+	if err := p.expectKeyword("SELECT"); err != nil {
+		return nil, err
+	}
+	sel, err := p.parseSelect()
+	if err != nil {
+		return nil, err
+	}
+	stmt.sel = sel.(*selectStmt)
+	return stmt, nil
+}
+
+// parseSavepoint ROLLBACK TO [SAVEPOINT] name、RELEASE [SAVEPOINT] name
+func (p *parser) parseSavepoint(op string) (interface{}, error) {
+	p.acceptKeyword("SAVEPOINT")
+	name, err := p.ident()
+	if err != nil {
+		return nil, err
+	}
+	return &txStmt{op: op, savepoint: name}, nil
+}
+
+// parseSelect SELECT *|col, ... FROM t [WHERE cond] [ORDER BY col [ASC|DESC], ...] [LIMIT n [OFFSET m]] [FOR UPDATE]
+func (p *parser) parseSelect() (interface{}, error) {
+	stmt := &selectStmt{}
+	if !p.acceptSymbol("*") {
+		fields, err := p.identList()
+		if err != nil {
+			return nil, err
+		}
+		stmt.fields = fields
+	}
+	if err := p.expectKeyword("FROM"); err != nil {
+		return nil, err
+	}
+	table, err := p.ident()
+	if err != nil {
+		return nil, err
+	}
+	stmt.table = table
+
+	if stmt.where, err = p.parseWhere(); err != nil {
+		return nil, err
+	}
+
+	if p.acceptKeyword("ORDER") {
+		if err := p.expectKeyword("BY"); err != nil {
+			return nil, err
+		}
+		for {
+			field, err := p.ident()
+			if err != nil {
+				return nil, err
+			}
+			o := OrderBy{Field: field}
+			if p.acceptKeyword("DESC") {
+				o.Desc = true
+			} else {
+				p.acceptKeyword("ASC")
+			}
+			stmt.orderBy = append(stmt.orderBy, o)
+			if !p.acceptSymbol(",") {
+				break
+			}
+		}
+	}
+
+	if p.acceptKeyword("LIMIT") {
+		v, err := p.parseValue()
+		if err != nil {
+			return nil, err
+		}
+		stmt.limit = &v
+		if p.acceptKeyword("OFFSET") {
+			v, err := p.parseValue()
+			if err != nil {
+				return nil, err
+			}
+			stmt.offset = &v
+		}
+	}
+
+	if p.acceptKeyword("FOR") {
+		if err := p.expectKeyword("UPDATE"); err != nil {
+			return nil, err
+		}
+		stmt.forUpdate = true
+	}
+	return stmt, nil
+}
+
+// parseUpdate UPDATE t SET col = v, ... [WHERE cond]
+func (p *parser) parseUpdate() (interface{}, error) {
+	table, err := p.ident()
+	if err != nil {
+		return nil, err
+	}
+	if err := p.expectKeyword("SET"); err != nil {
+		return nil, err
+	}
+	stmt := &updateStmt{table: table}
+	for {
+		column, err := p.ident()
+		if err != nil {
+			return nil, err
+		}
+		if err := p.expectSymbol("="); err != nil {
+			return nil, err
+		}
+		v, err := p.parseValue()
+		if err != nil {
+			return nil, err
+		}
+		stmt.columns = append(stmt.columns, column)
+		stmt.values = append(stmt.values, v)
+		if !p.acceptSymbol(",") {
+			break
+		}
+	}
+	if stmt.where, err = p.parseWhere(); err != nil {
+		return nil, err
+	}
+	return stmt, nil
+}
+
+// parseDelete DELETE FROM t [WHERE cond]
+func (p *parser) parseDelete() (interface{}, error) {
+	if err := p.expectKeyword("FROM"); err != nil {
+		return nil, err
+	}
+	table, err := p.ident()
+	if err != nil {
+		return nil, err
+	}
+	stmt := &deleteStmt{table: table}
+	if stmt.where, err = p.parseWhere(); err != nil {
+		return nil, err
+	}
+	return stmt, nil
+}
+
+func (p *parser) parseWhere() (sqlCond, error) {
+	if !p.acceptKeyword("WHERE") {
+		return nil, nil
+	}
+	return p.parseOr()
+}
+
+func (p *parser) parseOr() (sqlCond, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for p.acceptKeyword("OR") {
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		left = &logicCond{left: left, right: right}
+	}
+	return left, nil
+}
+
+func (p *parser) parseAnd() (sqlCond, error) {
+	left, err := p.parseNot()
+	if err != nil {
+		return nil, err
+	}
+	for p.acceptKeyword("AND") {
+		right, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		left = &logicCond{and: true, left: left, right: right}
+	}
+	return left, nil
+}
+
+func (p *parser) parseNot() (sqlCond, error) {
+	if p.acceptKeyword("NOT") {
+		c, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		return &notCond{cond: c}, nil
+	}
+	if p.acceptSymbol("(") {
+		c, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if err := p.expectSymbol(")"); err != nil {
+			return nil, err
+		}
+		return c, nil
+	}
+	return p.parsePredicate()
+}
+
+var cmpSymbols = map[string]cmpOp{
+	"=":  opEq,
+	"!=": opNe,
+	"<>": opNe,
+	"<":  opLt,
+	"<=": opLe,
+	">":  opGt,
+	">=": opGe,
+}
+
+// parsePredicate col op v | col [NOT] IN (v, ...) | col [NOT] BETWEEN v AND v | col [NOT] LIKE v | col IS [NOT] NULL
+func (p *parser) parsePredicate() (sqlCond, error) {
+	column, err := p.ident()
+	if err != nil {
+		return nil, err
+	}
+
+	if t := p.peek(); t.kind == tokSymbol {
+		op, ok := cmpSymbols[t.text]
+		if !ok {
+			return nil, p.unexpected()
+		}
+		p.i++
+		v, err := p.parseValue()
+		if err != nil {
+			return nil, err
+		}
+		return &cmpCond{op: op, column: column, value: v}, nil
+	}
+
+	if p.acceptKeyword("IS") {
+		not := p.acceptKeyword("NOT")
+		if err := p.expectKeyword("NULL"); err != nil {
+			return nil, err
+		}
+		return &nullCond{column: column, not: not}, nil
+	}
+
+	not := p.acceptKeyword("NOT")
+	switch {
+	case p.acceptKeyword("IN"):
+		if err := p.expectSymbol("("); err != nil {
+			return nil, err
+		}
+		values, err := p.valueList()
+		if err != nil {
+			return nil, err
+		}
+		if err := p.expectSymbol(")"); err != nil {
+			return nil, err
+		}
+		return &inCond{column: column, values: values, not: not}, nil
+	case p.acceptKeyword("BETWEEN"):
+		lo, err := p.parseValue()
+		if err != nil {
+			return nil, err
+		}
+		if err := p.expectKeyword("AND"); err != nil {
+			return nil, err
+		}
+		hi, err := p.parseValue()
+		if err != nil {
+			return nil, err
+		}
+		return &betweenCond{column: column, lo: lo, hi: hi, not: not}, nil
+	case p.acceptKeyword("LIKE"):
+		pattern, err := p.parseValue()
+		if err != nil {
+			return nil, err
+		}
+		return &likeCond{column: column, pattern: pattern, not: not}, nil
+	}
+	return nil, p.unexpected()
+}
+
+func (p *parser) valueList() ([]sqlValue, error) {
+	var values []sqlValue
+	for {
+		v, err := p.parseValue()
+		if err != nil {
+			return nil, err
+		}
+		values = append(values, v)
+		if !p.acceptSymbol(",") {
+			return values, nil
+		}
+	}
+}
+
+// parseValue ? | [-]number | 'string' | NULL
+func (p *parser) parseValue() (sqlValue, error) {
+	t := p.peek()
+	switch {
+	case t.kind == tokParam:
+		p.i++
+		v := sqlValue{isParam: true, param: p.params, pos: t.pos}
+		p.params++
+		return v, nil
+	case t.kind == tokString:
+		p.i++
+		return sqlValue{lit: t.text, pos: t.pos}, nil
+	case t.kind == tokNumber, t.kind == tokSymbol && t.text == "-":
+		p.i++
+		text := t.text
+		if t.kind == tokSymbol {
+			n := p.next()
+			if n.kind != tokNumber {
+				return sqlValue{}, p.errorf(n.pos, "expected number but got %v", n)
+			}
+			text += n.text
+		}
+		n, err := strconv.Atoi(text)
+		if err != nil {
+			return sqlValue{}, p.errorf(t.pos, "invalid number %s", text)
+		}
+		return sqlValue{lit: n, pos: t.pos}, nil
+	case p.isKeyword("NULL"):
+		p.i++
+		return sqlValue{pos: t.pos}, nil
+	}
+	return sqlValue{}, p.errorf(t.pos, "expected value but got %v", t)
+}
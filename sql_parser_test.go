@@ -0,0 +1,63 @@
+package IDB
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestParseSQL(t *testing.T) {
+	stmt, params, err := parseSQL("SELECT name, age FROM people WHERE age >= ? AND (city = 'bei''jing' OR city IS NULL) ORDER BY age DESC, name LIMIT 10 OFFSET ?;")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if params != 2 {
+		t.Fatalf("expected %v got %v", 2, params)
+	}
+	sel := stmt.(*selectStmt)
+	if sel.table != "people" || len(sel.fields) != 2 || len(sel.orderBy) != 2 || !sel.orderBy[0].Desc || sel.orderBy[1].Desc {
+		t.Fatalf("unexpected %+v", sel)
+	}
+	if sel.limit.lit != 10 || !sel.offset.isParam || sel.offset.param != 1 {
+		t.Fatalf("unexpected %+v %+v", sel.limit, sel.offset)
+	}
+	and := sel.where.(*logicCond)
+	or := and.right.(*logicCond)
+	if !and.and || or.and || or.left.(*cmpCond).value.lit != "bei'jing" {
+		t.Fatalf("unexpected %+v", sel.where)
+	}
+
+	stmt, _, err = parseSQL("create table users (id int primary key auto_increment, name varchar(20) not null unique, age int default -1)")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ct := stmt.(*createTableStmt)
+	if len(ct.fields) != 3 || !ct.fields[0].isPrimaryKey || !ct.fields[0].autoIncrement || !ct.fields[1].required || !ct.fields[1].unique || ct.fields[2].defaultValue != "-1" {
+		t.Fatalf("unexpected %+v", ct)
+	}
+
+	// 出错时返回行列
+	tests := []struct {
+		sql    string
+		line   int
+		column int
+	}{
+		{"SELECT * people", 1, 10},
+		{"SELECT *\nFROM people\nWHERE age >", 3, 12},
+		{"INSERT INTO people VALUES ('a', 1", 1, 34},
+		{"INSERT INTO people (name, age) VALUES ('a')", 1, 39},
+		{"UPDATE people SET name = 'a", 1, 26},
+		{"DELETE FROM people WHERE age ~ 1", 1, 30},
+		{"CREATE TABLE t (a FLOAT)", 1, 19},
+		{"SELECT * FROM people LIMIT 1 2", 1, 30},
+	}
+	for _, test := range tests {
+		_, _, err := parseSQL(test.sql)
+		var se *SyntaxError
+		if !errors.As(err, &se) || !errors.Is(err, ErrSyntax) {
+			t.Fatalf("%s: expected %v got %v", test.sql, ErrSyntax, err)
+		}
+		if se.Line != test.line || se.Column != test.column {
+			t.Fatalf("%s: expected %d:%d got %v", test.sql, test.line, test.column, err)
+		}
+	}
+}
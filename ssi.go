@@ -0,0 +1,243 @@
+package IDB
+
+import "sync"
+
+// ssiTracker 跟踪SERIALIZABLE事务之间的读写反依赖(rw-antidependency)。
+// T1读过的数据被并发的T2修改时记为T1 -> T2，存在Tin -> Tpivot -> Tout且Tout最先提交时可能无法序列化，提交的事务返回ErrSerializationFailure
+type ssiTracker struct {
+	mu     sync.Mutex
+	sxacts map[int]*sxact
+	// 已经提交的事务数量，用于比较提交顺序
+	commitSeq int
+}
+
+// sxact SERIALIZABLE事务的读写集合以及反依赖
+type sxact struct {
+	ssi      *ssiTracker
+	id       int
+	rv       *readView
+	readOnly bool
+	reads    map[string]*readSet
+	// 提交时事务缓存中的record id
+	writes map[string]map[int]bool
+	// 提交顺序，未提交时为0
+	commitSeq int
+	// in 读过该事务修改的数据的事务，out 修改过该事务读过的数据的事务
+	in  map[*sxact]bool
+	out map[*sxact]bool
+}
+
+// readSet 事务在一个表中读过的数据。遍历过整个表时与表中任何修改冲突，包括插入；
+// 遍历过的id范围与范围内的任何修改冲突
+type readSet struct {
+	ids     map[int]bool
+	ranges  []keyRange
+	scanned bool
+}
+
+func newSSITracker() *ssiTracker {
+	return &ssiTracker{sxacts: make(map[int]*sxact)}
+}
+
+// begin 开始跟踪事务。只读事务开始时没有并发的读写事务时，快照是安全的，返回nil
+func (ssi *ssiTracker) begin(id int, rv *readView, readOnly bool) *sxact {
+	ssi.mu.Lock()
+	defer ssi.mu.Unlock()
+
+	if readOnly && !ssi.hasActiveWriter() {
+		return nil
+	}
+	sx := &sxact{
+		ssi:      ssi,
+		id:       id,
+		rv:       rv,
+		readOnly: readOnly,
+		reads:    make(map[string]*readSet),
+		in:       make(map[*sxact]bool),
+		out:      make(map[*sxact]bool),
+	}
+	ssi.sxacts[id] = sx
+	return sx
+}
+
+func (ssi *ssiTracker) hasActiveWriter() bool {
+	for _, sx := range ssi.sxacts {
+		if sx.commitSeq == 0 && !sx.readOnly {
+			return true
+		}
+	}
+	return false
+}
+
+func (sx *sxact) readSet(tableName string) *readSet {
+	rs, ok := sx.reads[tableName]
+	if !ok {
+		rs = &readSet{ids: make(map[int]bool)}
+		sx.reads[tableName] = rs
+	}
+	return rs
+}
+
+func (sx *sxact) recordRead(tableName string, id int) {
+	sx.ssi.mu.Lock()
+	defer sx.ssi.mu.Unlock()
+
+	sx.readSet(tableName).ids[id] = true
+}
+
+func (sx *sxact) recordScan(tableName string) {
+	sx.ssi.mu.Lock()
+	defer sx.ssi.mu.Unlock()
+
+	sx.readSet(tableName).scanned = true
+}
+
+func (sx *sxact) recordRange(tableName string, lo, hi int) {
+	sx.ssi.mu.Lock()
+	defer sx.ssi.mu.Unlock()
+
+	rs := sx.readSet(tableName)
+	rs.ranges = append(rs.ranges, keyRange{lo: lo, hi: hi})
+}
+
+// contains id是否被读过
+func (rs *readSet) contains(id int) bool {
+	if rs.ids[id] {
+		return true
+	}
+	for _, r := range rs.ranges {
+		if id >= r.lo && id <= r.hi {
+			return true
+		}
+	}
+	return false
+}
+
+// readsAny 是否读过writes中的数据
+func (sx *sxact) readsAny(writes map[string]map[int]bool) bool {
+	for name, ids := range writes {
+		rs, ok := sx.reads[name]
+		if !ok {
+			continue
+		}
+		if rs.scanned {
+			return true
+		}
+		for id := range ids {
+			if rs.contains(id) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// commit 提交前加上与并发事务之间的反依赖，构成危险结构时返回ErrSerializationFailure，之后回滚时会清除反依赖。
+// 返回nil时事务记为已提交，执行操作失败时需要调用undoCommit
+func (ssi *ssiTracker) commit(sx *sxact, cache map[string]*txCache) error {
+	ssi.mu.Lock()
+	defer ssi.mu.Unlock()
+
+	writes := make(map[string]map[int]bool, len(cache))
+	for name, c := range cache {
+		ids := make(map[int]bool, len(c.cache))
+		for id := range c.cache {
+			ids[id] = true
+		}
+		writes[name] = ids
+	}
+
+	for _, other := range ssi.sxacts {
+		if other == sx {
+			continue
+		}
+		// 在该事务开始前已经提交的事务不是并发的
+		if other.commitSeq != 0 && sx.rv.visible(other.id) {
+			continue
+		}
+		if other.commitSeq != 0 && sx.readsAny(other.writes) {
+			sx.out[other] = true
+			other.in[sx] = true
+		}
+		if other.readsAny(writes) {
+			other.out[sx] = true
+			sx.in[other] = true
+		}
+	}
+
+	// 该事务作为Tpivot或者Tin。out中的事务都已经提交
+	for tin := range sx.in {
+		for tout := range sx.out {
+			if dangerous(tin, sx, tout) {
+				return ErrSerializationFailure
+			}
+		}
+	}
+	for pivot := range sx.out {
+		for tout := range pivot.out {
+			if dangerous(sx, pivot, tout) {
+				return ErrSerializationFailure
+			}
+		}
+	}
+
+	ssi.commitSeq++
+	sx.commitSeq = ssi.commitSeq
+	sx.writes = writes
+	return nil
+}
+
+// undoCommit 提交时执行操作失败，事务恢复为未提交，之后回滚时清除反依赖
+func (ssi *ssiTracker) undoCommit(sx *sxact) {
+	ssi.mu.Lock()
+	defer ssi.mu.Unlock()
+
+	sx.commitSeq = 0
+	sx.writes = nil
+}
+
+// dangerous Tin -> Tpivot -> Tout中Tout最先提交时可能无法序列化。
+// Tin为只读事务时，Tout还需要在Tin开始前提交
+func dangerous(tin, pivot, tout *sxact) bool {
+	if tout.commitSeq == 0 {
+		return false
+	}
+	after := func(sx *sxact) bool {
+		return sx == tout || sx.commitSeq == 0 || sx.commitSeq > tout.commitSeq
+	}
+	if !after(pivot) || !after(tin) {
+		return false
+	}
+	return !tin.readOnly || tin.rv.visible(tout.id)
+}
+
+// end 事务结束。回滚的事务不再参与冲突检查。
+// 已经提交的事务与活跃事务都不并发时不会再有新的反依赖，从跟踪中移除，已有的反依赖保留在其他事务中
+func (ssi *ssiTracker) end(sx *sxact) {
+	ssi.mu.Lock()
+	defer ssi.mu.Unlock()
+
+	if sx.commitSeq == 0 {
+		delete(ssi.sxacts, sx.id)
+		for other := range sx.in {
+			delete(other.out, sx)
+		}
+		for other := range sx.out {
+			delete(other.in, sx)
+		}
+	}
+	for id, committed := range ssi.sxacts {
+		if committed.commitSeq != 0 && !ssi.concurrentWithActive(committed) {
+			delete(ssi.sxacts, id)
+		}
+	}
+}
+
+func (ssi *ssiTracker) concurrentWithActive(committed *sxact) bool {
+	for _, active := range ssi.sxacts {
+		if active.commitSeq == 0 && !active.rv.visible(committed.id) {
+			return true
+		}
+	}
+	return false
+}
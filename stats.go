@@ -0,0 +1,433 @@
+package IDB
+
+import (
+	"errors"
+	"hash/fnv"
+	"math"
+	"math/bits"
+	"sort"
+	"strconv"
+	"sync"
+)
+
+var (
+	ErrNotAnalyzed = errors.New("storage: table not analyzed")
+)
+
+const (
+	// HyperLogLog使用2^hllPrecision个寄存器，标准误差约为1.04/sqrt(2^hllPrecision)
+	hllPrecision = 10
+	hllRegisters = 1 << hllPrecision
+	// 等深直方图最多的桶数量
+	histogramBuckets = 32
+)
+
+// TableStats 表的统计信息
+type TableStats struct {
+	Rows    int
+	Columns []*ColumnStats
+}
+
+// ColumnStats 字段的统计信息。Min、Max以及直方图边界中INT字段为int，STRING字段为string，没有非空值时为nil
+type ColumnStats struct {
+	Name string
+	// HyperLogLog估算的不同值数量
+	Distinct     uint64
+	NullFraction float64
+	Min, Max     interface{}
+	Histogram    []HistogramBucket
+}
+
+// HistogramBucket 等深直方图的桶，包含[Lower, Upper]之间的非空值
+type HistogramBucket struct {
+	Lower, Upper interface{}
+	Count        int
+	// 建立直方图时桶中不同值的数量
+	Distinct int
+}
+
+// hyperLogLog 估算不同值的数量，只能添加不能删除
+type hyperLogLog struct {
+	registers [hllRegisters]uint8
+}
+
+func (h *hyperLogLog) add(v string) {
+	f := fnv.New64a()
+	f.Write([]byte(v))
+	x := mix64(f.Sum64())
+	i := x >> (64 - hllPrecision)
+	rho := uint8(bits.LeadingZeros64(x<<hllPrecision|1<<(hllPrecision-1)) + 1)
+	if rho > h.registers[i] {
+		h.registers[i] = rho
+	}
+}
+
+// mix64 打散fnv的结果，使高位也分布均匀
+func mix64(x uint64) uint64 {
+	x ^= x >> 30
+	x *= 0xbf58476d1ce4e5b9
+	x ^= x >> 27
+	x *= 0x94d049bb133111eb
+	x ^= x >> 31
+	return x
+}
+
+func (h *hyperLogLog) estimate() uint64 {
+	m := float64(hllRegisters)
+	var sum float64
+	zeros := 0
+	for _, r := range h.registers {
+		sum += math.Ldexp(1, -int(r))
+		if r == 0 {
+			zeros++
+		}
+	}
+	e := 0.7213 / (1 + 1.079/m) * m * m / sum
+	// 基数较小时使用线性计数
+	if e <= 2.5*m && zeros > 0 {
+		e = m * math.Log(m/float64(zeros))
+	}
+	return uint64(e + 0.5)
+}
+
+// bucket 直方图的桶，值为存储的字符串
+type bucket struct {
+	lower, upper string
+	count        int
+	distinct     int
+}
+
+// columnStats 字段统计信息，按字段在record.Value中的位置保存。字段类型改变后不再使用
+type columnStats struct {
+	tp      fieldType
+	hll     hyperLogLog
+	nulls   int
+	min     string
+	max     string
+	buckets []*bucket
+}
+
+// tableStats 表的统计信息，Analyze后随写入增量更新。
+// 删除时无法从HyperLogLog中移除，也不会收缩最小值、最大值，需要重新Analyze
+type tableStats struct {
+	mu       *sync.RWMutex
+	analyzed bool
+	rows     int
+	columns  map[int]*columnStats
+}
+
+func newTableStats() *tableStats {
+	return &tableStats{mu: &sync.RWMutex{}}
+}
+
+// Analyze 遍历表计算统计信息，之后随写入增量更新。计算期间阻塞写但不阻塞读
+func (s *idbServer) Analyze(tableName string) (*TableStats, error) {
+	t, err := s.getTable(tableName)
+	if err != nil {
+		return nil, err
+	}
+
+	t.writeMu.Lock()
+	defer t.writeMu.Unlock()
+
+	fields := t.meta.getFields()
+	values := make([][]string, len(fields))
+	nulls := make([]int, len(fields))
+	rows := 0
+	t.data.scanLeaves(func(r *Record) bool {
+		rows++
+		for i, f := range fields {
+			v := fieldValue(f, r)
+			if v == "" {
+				nulls[i]++
+				continue
+			}
+			values[i] = append(values[i], v)
+		}
+		return true
+	})
+
+	columns := make(map[int]*columnStats, len(fields))
+	for i, f := range fields {
+		columns[f.pos] = buildColumnStats(f.tp, values[i], nulls[i])
+	}
+
+	t.stats.mu.Lock()
+	t.stats.analyzed = true
+	t.stats.rows = rows
+	t.stats.columns = columns
+	t.stats.mu.Unlock()
+	return t.stats.export(fields), nil
+}
+
+// Stats 获取表当前的统计信息，未Analyze时返回ErrNotAnalyzed
+func (s *idbServer) Stats(tableName string) (*TableStats, error) {
+	t, err := s.getTable(tableName)
+	if err != nil {
+		return nil, err
+	}
+	if !t.stats.isAnalyzed() {
+		return nil, ErrNotAnalyzed
+	}
+	return t.stats.export(t.meta.getFields()), nil
+}
+
+func buildColumnStats(tp fieldType, values []string, nulls int) *columnStats {
+	cs := &columnStats{tp: tp, nulls: nulls}
+	if len(values) == 0 {
+		return cs
+	}
+	sort.Slice(values, func(i, j int) bool {
+		return compareValues(tp, values[i], values[j]) < 0
+	})
+	for _, v := range values {
+		cs.hll.add(v)
+	}
+	cs.min, cs.max = values[0], values[len(values)-1]
+
+	// 每个桶大约depth个值，相同的值放在同一个桶中
+	depth := (len(values) + histogramBuckets - 1) / histogramBuckets
+	for start := 0; start < len(values); {
+		end := start + depth
+		if end > len(values) {
+			end = len(values)
+		}
+		for end < len(values) && compareValues(tp, values[end], values[end-1]) == 0 {
+			end++
+		}
+		b := &bucket{lower: values[start], upper: values[end-1], count: end - start, distinct: 1}
+		for i := start + 1; i < end; i++ {
+			if compareValues(tp, values[i], values[i-1]) != 0 {
+				b.distinct++
+			}
+		}
+		cs.buckets = append(cs.buckets, b)
+		start = end
+	}
+	return cs
+}
+
+func (ts *tableStats) isAnalyzed() bool {
+	ts.mu.RLock()
+	defer ts.mu.RUnlock()
+	return ts.analyzed
+}
+
+// add 插入record后更新统计信息，调用方持有writeMu
+func (ts *tableStats) add(fields []*FieldMeta, r *Record) {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+	if !ts.analyzed {
+		return
+	}
+	ts.rows++
+	for _, f := range fields {
+		if cs := ts.columns[f.pos]; cs != nil && cs.tp == f.tp {
+			cs.add(fieldValue(f, r))
+		}
+	}
+}
+
+// remove 删除record后更新统计信息，调用方持有writeMu
+func (ts *tableStats) remove(fields []*FieldMeta, r *Record) {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+	if !ts.analyzed {
+		return
+	}
+	ts.rows--
+	for _, f := range fields {
+		if cs := ts.columns[f.pos]; cs != nil && cs.tp == f.tp {
+			cs.remove(fieldValue(f, r))
+		}
+	}
+}
+
+func (cs *columnStats) add(v string) {
+	if v == "" {
+		cs.nulls++
+		return
+	}
+	cs.hll.add(v)
+	if cs.min == "" || compareValues(cs.tp, v, cs.min) < 0 {
+		cs.min = v
+	}
+	if cs.max == "" || compareValues(cs.tp, v, cs.max) > 0 {
+		cs.max = v
+	}
+	if len(cs.buckets) == 0 {
+		cs.buckets = []*bucket{{lower: v, upper: v, count: 1, distinct: 1}}
+		return
+	}
+	// 超出范围时扩展第一个或者最后一个桶
+	b := cs.findBucket(v)
+	if compareValues(cs.tp, v, b.lower) < 0 {
+		b.lower = v
+	}
+	if compareValues(cs.tp, v, b.upper) > 0 {
+		b.upper = v
+	}
+	b.count++
+}
+
+func (cs *columnStats) remove(v string) {
+	if v == "" {
+		if cs.nulls > 0 {
+			cs.nulls--
+		}
+		return
+	}
+	if len(cs.buckets) == 0 {
+		return
+	}
+	if b := cs.findBucket(v); b.count > 0 {
+		b.count--
+	}
+}
+
+// findBucket 第一个上界不小于v的桶，v大于所有上界时为最后一个桶
+func (cs *columnStats) findBucket(v string) *bucket {
+	i := sort.Search(len(cs.buckets), func(i int) bool {
+		return compareValues(cs.tp, cs.buckets[i].upper, v) >= 0
+	})
+	if i == len(cs.buckets) {
+		i--
+	}
+	return cs.buckets[i]
+}
+
+// column 获取字段的统计信息，没有或者字段类型已经改变时返回nil。返回的统计信息只读
+func (ts *tableStats) column(f *FieldMeta) *columnStats {
+	if !ts.analyzed {
+		return nil
+	}
+	cs := ts.columns[f.pos]
+	if cs == nil || cs.tp != f.tp {
+		return nil
+	}
+	return cs
+}
+
+// eqFraction 估算等于v的比例。假设桶内每个值的数量相同
+func (ts *tableStats) eqFraction(f *FieldMeta, v string) (float64, bool) {
+	ts.mu.RLock()
+	defer ts.mu.RUnlock()
+	cs := ts.column(f)
+	if cs == nil || ts.rows <= 0 {
+		return 0, false
+	}
+	if len(cs.buckets) == 0 || compareValues(f.tp, v, cs.min) < 0 || compareValues(f.tp, v, cs.max) > 0 {
+		return 0, true
+	}
+	b := cs.findBucket(v)
+	if compareValues(f.tp, v, b.lower) < 0 {
+		// 落在两个桶之间
+		return 0, true
+	}
+	distinct := b.distinct
+	if distinct < 1 {
+		distinct = 1
+	}
+	return float64(b.count) / float64(distinct) / float64(ts.rows), true
+}
+
+// rangeFraction 估算在[lo, hi]之间的比例，INT字段在桶内按线性插值，STRING字段部分重叠的桶按一半计算
+func (ts *tableStats) rangeFraction(f *FieldMeta, lo, hi string) (float64, bool) {
+	ts.mu.RLock()
+	defer ts.mu.RUnlock()
+	cs := ts.column(f)
+	if cs == nil || ts.rows <= 0 {
+		return 0, false
+	}
+	var rows float64
+	for _, b := range cs.buckets {
+		if (lo != "" && compareValues(f.tp, b.upper, lo) < 0) || (hi != "" && compareValues(f.tp, b.lower, hi) > 0) {
+			continue
+		}
+		rows += float64(b.count) * bucketOverlap(f.tp, b, lo, hi)
+	}
+	return math.Min(1, rows/float64(ts.rows)), true
+}
+
+// bucketOverlap 桶与[lo, hi]重叠的比例，lo、hi为空时表示不限
+func bucketOverlap(tp fieldType, b *bucket, lo, hi string) float64 {
+	loIn := lo == "" || compareValues(tp, lo, b.lower) <= 0
+	hiIn := hi == "" || compareValues(tp, hi, b.upper) >= 0
+	if loIn && hiIn {
+		return 1
+	}
+	if tp != INT {
+		return 0.5
+	}
+	l, errL := strconv.Atoi(b.lower)
+	u, errU := strconv.Atoi(b.upper)
+	if errL != nil || errU != nil || u == l {
+		return 0.5
+	}
+	from, to := float64(l), float64(u)
+	if !loIn {
+		x, _ := strconv.Atoi(lo)
+		from = float64(x)
+	}
+	if !hiIn {
+		x, _ := strconv.Atoi(hi)
+		to = float64(x)
+	}
+	return math.Max(0, (to-from+1)/float64(u-l+1))
+}
+
+// nullFraction 空值比例
+func (ts *tableStats) nullFraction(f *FieldMeta) (float64, bool) {
+	ts.mu.RLock()
+	defer ts.mu.RUnlock()
+	cs := ts.column(f)
+	if cs == nil || ts.rows <= 0 {
+		return 0, false
+	}
+	return float64(cs.nulls) / float64(ts.rows), true
+}
+
+func (ts *tableStats) export(fields []*FieldMeta) *TableStats {
+	ts.mu.RLock()
+	defer ts.mu.RUnlock()
+
+	out := &TableStats{Rows: ts.rows}
+	for _, f := range fields {
+		cs := ts.column(f)
+		if cs == nil {
+			continue
+		}
+		c := &ColumnStats{
+			Name:     f.name,
+			Distinct: cs.hll.estimate(),
+			Min:      statsValue(f.tp, cs.min),
+			Max:      statsValue(f.tp, cs.max),
+		}
+		if ts.rows > 0 {
+			c.NullFraction = float64(cs.nulls) / float64(ts.rows)
+		}
+		for _, b := range cs.buckets {
+			c.Histogram = append(c.Histogram, HistogramBucket{
+				Lower:    statsValue(f.tp, b.lower),
+				Upper:    statsValue(f.tp, b.upper),
+				Count:    b.count,
+				Distinct: b.distinct,
+			})
+		}
+		out.Columns = append(out.Columns, c)
+	}
+	return out
+}
+
+// statsValue 转换为字段类型对应的值，空值为nil
+func statsValue(tp fieldType, v string) interface{} {
+	if v == "" {
+		return nil
+	}
+	if tp == INT {
+		if n, err := strconv.Atoi(v); err == nil {
+			return n
+		}
+	}
+	return v
+}
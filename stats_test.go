@@ -0,0 +1,140 @@
+package IDB
+
+import (
+	"fmt"
+	"math"
+	"strings"
+	"testing"
+)
+
+func TestHyperLogLog(t *testing.T) {
+	h := &hyperLogLog{}
+	count := 20000
+	for i := 0; i < count; i++ {
+		h.add(fmt.Sprintf("v%d", i))
+		// 重复的值不影响估算
+		h.add(fmt.Sprintf("v%d", i/2))
+	}
+	got := h.estimate()
+	if math.Abs(float64(got)-float64(count)) > float64(count)*0.05 {
+		t.Fatalf("expected about %v got %v", count, got)
+	}
+}
+
+func TestAnalyze(t *testing.T) {
+	server := NewIDBServer()
+	_, err := server.Exec("CREATE TABLE people (id INT PRIMARY KEY, age INT, city STRING)")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 1; i <= 1000; i++ {
+		var city interface{}
+		if i%4 != 0 {
+			city = fmt.Sprintf("c%d", i%10)
+		}
+		_, err = server.Exec("INSERT INTO people VALUES (?, ?, ?)", i, i%50, city)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	_, err = server.Stats("people")
+	if err != ErrNotAnalyzed {
+		t.Fatalf("expected %v got %v", ErrNotAnalyzed, err)
+	}
+
+	stats, err := server.Analyze("people")
+	if err != nil {
+		t.Fatal(err)
+	}
+	age, city := stats.Columns[1], stats.Columns[2]
+	if stats.Rows != 1000 || age.Name != "age" || age.Min != 0 || age.Max != 49 {
+		t.Fatalf("unexpected %+v %+v", stats, age)
+	}
+	if age.Distinct < 45 || age.Distinct > 55 {
+		t.Fatalf("expected about %v got %v", 50, age.Distinct)
+	}
+	if city.NullFraction != 0.25 || city.Min != "c0" || city.Max != "c9" {
+		t.Fatalf("unexpected %+v", city)
+	}
+	if len(age.Histogram) > histogramBuckets || histogramCount(age.Histogram) != 1000 || histogramCount(city.Histogram) != 750 {
+		t.Fatalf("unexpected %+v", age.Histogram)
+	}
+
+	// 写入后增量更新
+	for i := 1001; i <= 1100; i++ {
+		_, err = server.Exec("INSERT INTO people VALUES (?, ?, ?)", i, i-900, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	_, err = server.Exec("DELETE FROM people WHERE id <= 10")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = server.Exec("UPDATE people SET city = 'c0' WHERE id = 20")
+	if err != nil {
+		t.Fatal(err)
+	}
+	stats, err = server.Stats("people")
+	if err != nil {
+		t.Fatal(err)
+	}
+	age, city = stats.Columns[1], stats.Columns[2]
+	if stats.Rows != 1090 || age.Max != 200 || histogramCount(age.Histogram) != 1090 {
+		t.Fatalf("unexpected %+v %+v", stats, age)
+	}
+	if age.Distinct < 140 || age.Distinct > 160 {
+		t.Fatalf("expected about %v got %v", 150, age.Distinct)
+	}
+	if city.NullFraction != 347.0/1090 || histogramCount(city.Histogram) != 743 {
+		t.Fatalf("unexpected %+v", city)
+	}
+
+	// 执行计划使用直方图估算，增量更新时新值都落在最后一个桶中，重新Analyze后更准确
+	if rows := estimatedRows(t, server, "SELECT * FROM people WHERE age > 100", "Filter"); rows < 80 || rows > 120 {
+		t.Fatalf("expected about %v got %v", 100, rows)
+	}
+	if rows := estimatedRows(t, server, "SELECT * FROM people WHERE city IS NULL", "Filter"); rows != 347 {
+		t.Fatalf("expected %v got %v", 347, rows)
+	}
+	_, err = server.Analyze("people")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rows := estimatedRows(t, server, "SELECT * FROM people WHERE age > 100", "Filter"); rows < 95 || rows > 105 {
+		t.Fatalf("expected about %v got %v", 100, rows)
+	}
+	if rows := estimatedRows(t, server, "SELECT * FROM people WHERE id BETWEEN 1 AND 100", "PK Range Scan"); rows != 90 {
+		t.Fatalf("expected %v got %v", 90, rows)
+	}
+}
+
+// estimatedRows 执行计划中以node开头的节点估算的行数
+func estimatedRows(t *testing.T, server *idbServer, sql string, node string) int {
+	plan, err := server.Explain(sql, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, line := range strings.Split(plan, "\n") {
+		line = strings.TrimLeft(line, " ->")
+		if !strings.HasPrefix(line, node) {
+			continue
+		}
+		var rows int
+		i := strings.LastIndex(line, "est rows=")
+		if _, err = fmt.Sscanf(line[i:], "est rows=%d", &rows); err != nil {
+			t.Fatal(err)
+		}
+		return rows
+	}
+	t.Fatalf("%s: no %s in %v", sql, node, plan)
+	return 0
+}
+
+func histogramCount(buckets []HistogramBucket) int {
+	n := 0
+	for _, b := range buckets {
+		n += b.Count
+	}
+	return n
+}
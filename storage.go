@@ -2,8 +2,11 @@ package IDB
 
 import (
 	"errors"
+	"fmt"
+	"math"
+	"sort"
 	"strconv"
-	"sync/atomic"
+	"sync"
 )
 
 type fieldType int
@@ -25,11 +28,18 @@ var (
 	ErrFieldNotExist        = errors.New("storage: field not exist")
 	ErrFieldRequired        = errors.New("storage: field required")
 	ErrInvalidOp            = errors.New("storage: invalid op")
+	ErrTableExists          = errors.New("storage: table exists")
 )
 
 type idbServer struct {
 	DB     *db
 	config *ServerConfig
+	// server唯一的事务管理器，第一次创建后不再替换。表结构变更时需要通知它清理undoLog
+	txMgrMu *sync.Mutex
+	txMgr   *TxMgrImpl
+	// Exec、Query使用的默认会话
+	sessionMu *sync.Mutex
+	session   *Session
 }
 
 type ServerConfig struct {
@@ -41,18 +51,36 @@ type ServerOptionConfig struct {
 }
 
 type db struct {
-	name   string
+	name string
+	// 保护tables。DDL与其他goroutine的读写可以并发
+	mu     *sync.RWMutex
 	tables map[string]*table
 }
 
 type table struct {
 	meta *tableMeta
-	data *Tree
+	// 统计信息，Analyze后随写入增量更新
+	stats *tableStats
+	data  *Tree
+	// 写数据时加锁，保证写入时表结构不变
+	writeMu *sync.Mutex
+	// STRING主键到record id的索引。INT主键直接作为b+树的key
+	pkIndex *index
 }
 
 type tableMeta struct {
 	idCount int64
-	fields  []*FieldMeta
+	// 保护fields、slotCount。变更表结构时整体替换fields，不修改原来的FieldMeta
+	mu     *sync.RWMutex
+	fields []*FieldMeta
+	// 已经分配的record.Value位置数量。删除的字段位置不会被复用
+	slotCount int
+	// 二级索引。同fields一样整体替换
+	indexes []*index
+	// 外键。同fields一样整体替换
+	foreignKeys []*foreignKey
+	// CHECK约束。同fields一样整体替换
+	checks []*check
 }
 
 type FieldMeta struct {
@@ -60,44 +88,120 @@ type FieldMeta struct {
 	isPrimaryKey bool
 	tp           fieldType
 	required     bool
+	// 仅用于INT主键。插入时未指定主键则自增
+	autoIncrement bool
+	// 字段在record.Value中的位置
+	pos int
+	// 旧record没有该字段时读到的值
+	defaultValue string
+	// 创建表时在该字段上建立唯一约束
+	unique bool
+	// 按字段名插入时未指定该字段，调用该方法获取默认值。优先于defaultValue
+	defaultFunc DefaultFunc
+	// 生成列，写入时根据其他字段计算，不能直接写入
+	generated GeneratedFunc
 }
 
 func NewIDBServer() *idbServer {
-	return &idbServer{
+	s := &idbServer{
 		DB: &db{
 			name:   "main",
+			mu:     &sync.RWMutex{},
 			tables: make(map[string]*table),
 		},
-		config: &ServerConfig{options: &ServerOptionConfig{}},
+		config:    &ServerConfig{options: &ServerOptionConfig{inspector: NewUndoInspector()}},
+		txMgrMu:   &sync.Mutex{},
+		sessionMu: &sync.Mutex{},
 	}
+	s.session = s.NewSession()
+	return s
 }
 
 type ServerOptionFunc func(option *ServerOptionConfig)
 
+// WithOptions 修改server的选项。替换inspector后已经创建的表也使用新的inspector
 func (s *idbServer) WithOptions(opts ...ServerOptionFunc) {
 	for _, optionFunc := range opts {
 		optionFunc(s.config.options)
 	}
+
+	s.DB.mu.RLock()
+	defer s.DB.mu.RUnlock()
+	for name, t := range s.DB.tables {
+		t.data.WithInspector(s.tableInspector(name))
+	}
 }
 
-func (s *idbServer) CreateTable(tableName string, fieldMetas []*FieldMeta) {
-	t := &table{
-		meta: &tableMeta{
-			idCount: 0,
-			fields:  fieldMetas,
-		},
-		data: s.createDataTree(),
+// CreateTable 创建表。若同名表已存在则报错
+func (s *idbServer) CreateTable(tableName string, fieldMetas []*FieldMeta) error {
+	if err := checkPrimaryKey(fieldMetas); err != nil {
+		return err
+	}
+	if err := checkColumns(fieldMetas); err != nil {
+		return err
+	}
+	t := newTable(newTableMeta(fieldMetas), s.createDataTree(tableName))
+
+	s.DB.mu.Lock()
+	defer s.DB.mu.Unlock()
+	if _, ok := s.DB.tables[tableName]; ok {
+		return ErrTableExists
 	}
 	s.DB.tables[tableName] = t
+	return nil
+}
+
+// getTable 根据表名找到表
+func (s *idbServer) getTable(tableName string) (*table, error) {
+	s.DB.mu.RLock()
+	defer s.DB.mu.RUnlock()
+
+	t, ok := s.DB.tables[tableName]
+	if !ok {
+		return nil, ErrTableNotExist
+	}
+	return t, nil
 }
 
-func (s *idbServer) createDataTree() *Tree {
+func (s *idbServer) createDataTree(tableName string) *Tree {
 	tree := NewTree()
-	tree.WithInspector(s.config.options.inspector)
+	tree.WithInspector(s.tableInspector(tableName))
 	return tree
 }
 
+// tableInspector 表的树使用的inspector。UndoInspector按表区分数据，不同表相同id的record不会互相覆盖
+func (s *idbServer) tableInspector(tableName string) Inspector {
+	if ui, ok := s.config.options.inspector.(*UndoInspector); ok {
+		return ui.forTable(tableName)
+	}
+	return s.config.options.inspector
+}
+
 func (s *idbServer) SelectByIDTx(tx *Tx, tableName string, id int) (*Record, error) {
+	t, err := s.getTable(tableName)
+	if err != nil {
+		return nil, err
+	}
+
+	tx.newStatement()
+	record, err := s.selectByIDTx(tx, t, tableName, id)
+	if err != nil {
+		return nil, err
+	}
+	return t.meta.materialize(record), nil
+}
+
+// selectByIDTx 查询事务可见的record，并记录到SERIALIZABLE事务的读集合中。返回的是按存储位置排列的record
+func (s *idbServer) selectByIDTx(tx *Tx, t *table, tableName string, id int) (*Record, error) {
+	r, err := s.findVisibleTx(tx, t, tableName, id)
+	if err == nil || err == ErrKeyNotFound {
+		tx.recordRead(tableName, id)
+	}
+	return r, err
+}
+
+// findVisibleTx 查询事务可见的record
+func (s *idbServer) findVisibleTx(tx *Tx, t *table, tableName string, id int) (*Record, error) {
 	// 尝试从缓存中找到对应数据
 	record, err := s.trySelectFromCache(tx, tableName, id)
 	if err != nil {
@@ -107,28 +211,28 @@ func (s *idbServer) SelectByIDTx(tx *Tx, tableName string, id int) (*Record, err
 		return record, nil
 	}
 
-	// 从b+树查询
-	record, err = s.SelectByID(tableName, id)
+	// 从b+树查询，提交中的事务修改b+树以及undoLog之后才能查询
+	defer tx.mgr.rlockVersions()()
+	record, err = t.data.Find(id)
 	if err != nil && err != ErrKeyNotFound {
 		return nil, err
 	}
 
-	// 若查询到的record小于最小活跃id或者（不在记录的活跃id中且小于下一个要分配的txID），直接返回查到的record
+	// 若查询到的record小于最小活跃id或者（不在记录的活跃id中且小于下一个要分配的txID），直接返回查到的record。
+	// b+树中的record提交时会被原地修改，返回副本
 	if err == nil {
-		lastTxID := record.Meta.LastTxID
-		rv := tx.rv
-		if rv.minActiveID == InvalidLastTxID || lastTxID < rv.minActiveID || (!rv.activeTxIDs[lastTxID] && lastTxID < rv.nextTxID) {
-			return record, nil
+		if tx.rv.visible(record.Meta.LastTxID) {
+			return copyRecord(record), nil
 		}
 	}
 
-	// 到undoLog中查询。若找不到更改，就直接返回查到的最新record
-	ur, ferr := tx.mgr.FindRecordInUndoLog(tableName, id, tx.rv.activeTxIDs)
-	if ferr == ErrRecordNotCommit {
+	// 到undoLog中查询readView中的版本。若之后没有不可见的提交，就直接返回查到的最新record
+	ur, ferr := tx.mgr.FindRecordInUndoLog(tableName, id, tx.rv)
+	if ferr == ErrRecordNotCommit || ferr == ErrNoSuchATableInTxMgr {
 		if err == ErrKeyNotFound {
 			return nil, ErrKeyNotFound
 		}
-		return record, nil
+		return copyRecord(record), nil
 	}
 	if ferr != nil {
 		return nil, ferr
@@ -139,6 +243,60 @@ func (s *idbServer) SelectByIDTx(tx *Tx, tableName string, id int) (*Record, err
 	return ur, nil
 }
 
+// scanTx 按id顺序遍历事务可见的record，fn返回false时停止。record按存储位置排列
+func (s *idbServer) scanTx(tx *Tx, t *table, tableName string, fn func(r *Record) bool) error {
+	return s.scanRangeTx(tx, t, tableName, math.MinInt64, math.MaxInt64, fn)
+}
+
+// scanRangeTx 按id顺序遍历[lo, hi]之间事务可见的record，fn返回false时停止
+func (s *idbServer) scanRangeTx(tx *Tx, t *table, tableName string, lo, hi int, fn func(r *Record) bool) error {
+	if lo == math.MinInt64 && hi == math.MaxInt64 {
+		tx.recordScan(tableName)
+	} else {
+		tx.recordRange(tableName, lo, hi)
+	}
+	// 可见的record可能在b+树中，可能在快照之后被删除只存在于undoLog中，也可能是事务自己插入的
+	idSet := make(map[int]bool)
+	t.data.scanRange(lo, hi, func(r *Record) bool {
+		idSet[r.Key] = true
+		return true
+	})
+	inRange := func(id int) bool {
+		return id >= lo && id <= hi
+	}
+	for _, id := range tx.mgr.RecordIDsInUndoLog(tableName) {
+		if inRange(id) {
+			idSet[id] = true
+		}
+	}
+	if c, ok := tx.cache[tableName]; ok {
+		for id := range c.cache {
+			if inRange(id) {
+				idSet[id] = true
+			}
+		}
+	}
+	ids := make([]int, 0, len(idSet))
+	for id := range idSet {
+		ids = append(ids, id)
+	}
+	sort.Ints(ids)
+
+	for _, id := range ids {
+		r, err := s.selectByIDTx(tx, t, tableName, id)
+		if err == ErrKeyNotFound {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if !fn(r) {
+			break
+		}
+	}
+	return nil
+}
+
 // trySelectFromCache 尝试从缓存中找到对应数据
 func (s *idbServer) trySelectFromCache(tx *Tx, tableName string, id int) (*Record, error) {
 	c, ok := tx.cache[tableName]
@@ -158,14 +316,14 @@ func (s *idbServer) trySelectFromCache(tx *Tx, tableName string, id int) (*Recor
 				if opChange.record != nil {
 					return opChange.record, nil
 				}
-				record, err := s.SelectByID(tableName, id)
+				record, err := c.t.data.Find(id)
 				if err != nil {
 					return nil, err
 				}
 				values := make([]string, len(record.Value))
 				copy(values, record.Value)
 				for i, v := range opChange.change {
-					values[i] = v
+					values = setSlot(values, i, v)
 				}
 				r := &Record{
 					Key:   id,
@@ -184,9 +342,9 @@ func (s *idbServer) trySelectFromCache(tx *Tx, tableName string, id int) (*Recor
 // SelectByID 根据id以及表名查询数据
 func (s *idbServer) SelectByID(tableName string, id int) (*Record, error) {
 	// 找到对应表
-	t, ok := s.DB.tables[tableName]
-	if !ok {
-		return nil, ErrTableNotExist
+	t, err := s.getTable(tableName)
+	if err != nil {
+		return nil, err
 	}
 
 	// 从B+树中找到对应id数据
@@ -195,119 +353,146 @@ func (s *idbServer) SelectByID(tableName string, id int) (*Record, error) {
 		return nil, err
 	}
 
-	return record, nil
+	return t.meta.materialize(record), nil
 }
 
-func (s *idbServer) SelectByFields(tableName string, conds map[string]interface{}) ([]*Record, error) {
-	// 找到对应表
-	t, ok := s.DB.tables[tableName]
-	if !ok {
-		return nil, ErrTableNotExist
+// SelectByFields 查询字段等于指定值的数据，值按字段类型比较。opts用于投影、排序以及分页
+func (s *idbServer) SelectByFields(tableName string, conds map[string]interface{}, opts ...QueryOptions) ([]*Record, error) {
+	exprs := make([]Expr, 0, len(conds))
+	for key, cond := range conds {
+		exprs = append(exprs, Eq(key, cond))
 	}
+	return s.SelectWhere(tableName, And(exprs...), opts...)
+}
 
-	// 构造isTarget方法
-	fields := t.meta.fields
-	fieldNameIndexCache := make(map[string]int)
-	isTargets := make([]IsTarget, 0)
-	var i, startIndex int
+// SelectByFieldsTx 在事务中查询字段等于指定值的数据。结果包含事务自己未提交的修改，其他数据为事务快照中的版本
+func (s *idbServer) SelectByFieldsTx(tx *Tx, tableName string, conds map[string]interface{}, opts ...QueryOptions) ([]*Record, error) {
+	exprs := make([]Expr, 0, len(conds))
 	for key, cond := range conds {
-		i, ok = fieldNameIndexCache[key]
-		if !ok {
-			for ; startIndex < len(fields); startIndex++ {
-				fieldNameIndexCache[fields[startIndex].name] = startIndex
-				if fields[startIndex].name == key {
-					i = startIndex
-					break
-				}
-			}
-			if startIndex >= len(fields) {
-				return nil, ErrFieldNotExist
-			}
-		}
-
-		isTargets = append(isTargets, func(record *Record) bool {
-			if record.Value[i] == cond {
-				return true
-			}
-			return false
-		})
+		exprs = append(exprs, Eq(key, cond))
 	}
+	return s.selectWhere(tx, tableName, And(exprs...), opts)
+}
 
-	isTarget := func(record *Record) bool {
-		for _, it := range isTargets {
-			if !it(record) {
-				return false
-			}
-		}
-		return true
+// SelectRangeTx 在事务中查询id在[lo, hi]之间的数据，可见性与SelectByFieldsTx相同
+func (s *idbServer) SelectRangeTx(tx *Tx, tableName string, lo, hi int, opts ...QueryOptions) ([]*Record, error) {
+	tx.newStatement()
+	p, err := s.planSelect(tx, tableName, nil, opts)
+	if err != nil {
+		return nil, err
 	}
+	p.lo, p.hi = lo, hi
+	records, err := p.run(s, tx)
+	if err != nil {
+		return nil, err
+	}
+	if len(records) == 0 {
+		return nil, ErrValueNotFound
+	}
+	return records, nil
+}
 
-	// 调用findByValue
-	return t.data.FineByValue(isTarget)
+// SelectWhere 查询满足条件的数据，expr为nil时返回所有数据。opts用于投影、排序以及分页
+func (s *idbServer) SelectWhere(tableName string, expr Expr, opts ...QueryOptions) ([]*Record, error) {
+	return s.selectWhere(nil, tableName, expr, opts)
+}
+
+// selectWhere tx不为nil时查询事务可见的数据
+func (s *idbServer) selectWhere(tx *Tx, tableName string, expr Expr, opts []QueryOptions) ([]*Record, error) {
+	if tx != nil {
+		tx.newStatement()
+	}
+	p, err := s.planSelect(tx, tableName, expr, opts)
+	if err != nil {
+		return nil, err
+	}
+	records, err := p.run(s, tx)
+	if err != nil {
+		return nil, err
+	}
+	if len(records) == 0 {
+		return nil, ErrValueNotFound
+	}
+	return records, nil
 }
 
 func (s *idbServer) UpdateByIDTx(tx *Tx, tableName string, values map[string]interface{}, id int) error {
-	var opRecord *OpRecord
-	var t *table
-	var err error
+	tx.newStatement()
 	// 找到表tx缓存
 	c, err := s.findTableTxCache(tx, tableName)
 	if err != nil {
 		return err
 	}
-	t = c.t
 
-	// 尝试找到cache opRecord，并更新op
-	opRecord = c.cache[id]
-	if opRecord != nil {
-		// 若记录操作为删除，则报错
-		if opRecord.op == DELETE {
-			return ErrKeyNotFound
-		}
-	} else {
-		// 若缓存中无法找到该记录且在bptree不存在，则报错
-		_, err = t.data.Find(id)
-		if err != nil {
-			return err
-		}
+	// 检查更新后的record，并计算生成列。已经被删除或者不存在的record返回ErrKeyNotFound
+	data, err := s.prepareUpdateTx(tx, tableName, c.t, values, id)
+	if err != nil {
+		return withTableName(err, tableName)
+	}
+	return updateInTxCache(c, tx.id, id, data)
+}
 
-		// 若在bptree存在，则缓存新添加更新opRecord
+// updateInTxCache 在事务缓存中记录更新。
+// 若前操作为insert，那么操作仍然为insert。只是里面的record进行更新
+// 若前操作无或者为update，那么操作为update。record若存在则更新record，否则添加更新
+func updateInTxCache(c *txCache, txID int, id int, data map[int]string) error {
+	opRecord := c.cache[id]
+	if opRecord == nil {
 		opRecord = &OpRecord{
 			opChange: &UpdateOpChange{
 				change: make(map[int]string),
 			},
 			op: UPDATE,
 		}
-		c.cache[id] = opRecord
 	}
 
-	// 若前操作为insert，那么操作仍然为insert。只是里面的record进行更新
-	// 若前操作无或者为update，那么操作为update。record若存在则更新record，否则添加更新
 	// TODO 原来的record还有记录txID之用. 若UpdateOpChange已经有record呢
-	err = wrapOpRecordWhenUpdate(t, values, opRecord)
+	err := wrapOpRecordWhenUpdate(data, opRecord)
 	if err != nil {
 		return err
 	}
-	opRecord.LastTxID = tx.id
+	opRecord.LastTxID = txID
 
 	// 记录更新后的record
 	c.cache[id] = opRecord
-
 	return nil
 }
 
-// wrapOpRecordWhenUpdate 获取更新后的record
-func wrapOpRecordWhenUpdate(t *table, values map[string]interface{}, opRecord *OpRecord) error {
+// prepareUpdateTx 将更新数据转化为string类型，并加上重新计算的生成列。
+// 更新后的record需要满足CHECK约束，更新外键字段时父表数据需要存在
+func (s *idbServer) prepareUpdateTx(tx *Tx, tableName string, t *table, values map[string]interface{}, id int) (map[int]string, error) {
 	data, err := convValuesToBPlusData(t, values)
 	if err != nil {
-		return err
+		return nil, err
+	}
+	v := &fkView{s: s, cache: tx.cache}
+	record, ok := v.find(tableName, t, id)
+	if !ok {
+		return nil, ErrKeyNotFound
 	}
 
+	updated := applyChange(record, data)
+	gen, err := t.prepareRecord(updated)
+	if err != nil {
+		return nil, err
+	}
+	err = v.checkParents(tableName, t, updated, data)
+	if err != nil {
+		return nil, err
+	}
+	for i, val := range gen {
+		data[i] = val
+	}
+	return data, nil
+}
+
+// wrapOpRecordWhenUpdate 获取更新后的record
+func wrapOpRecordWhenUpdate(data map[int]string, opRecord *OpRecord) error {
 	switch opRecord.op {
 	case INSERT:
 		opChange := opRecord.opChange.(*InsertOpChange)
 		for i, v := range data {
-			opChange.record.Value[i] = v
+			opChange.record.Value = setSlot(opChange.record.Value, i, v)
 		}
 
 	case UPDATE:
@@ -319,7 +504,7 @@ func wrapOpRecordWhenUpdate(t *table, values map[string]interface{}, opRecord *O
 			break
 		}
 		for i, v := range data {
-			opChange.record.Value[i] = v
+			opChange.record.Value = setSlot(opChange.record.Value, i, v)
 		}
 
 	default:
@@ -330,39 +515,62 @@ func wrapOpRecordWhenUpdate(t *table, values map[string]interface{}, opRecord *O
 	return nil
 }
 
-// TODO commit 不是原子的，若tx2更新前已经被tx1删除，那么tx1之前的操作不会回滚，后面的操作也不会提交
-func (s *idbServer) commit(cache map[string]*txCache) error {
-	var err error
-	for _, c := range cache {
-		for key, rc := range c.cache {
-			switch rc.op {
-			case UPDATE:
-				// TODO 怎么更新record的lastTxID呢
-				opChange := rc.opChange.(*UpdateOpChange)
-				err = c.t.data.UpdateRecord(opChange.change, key, func(meta *RecordMeta) *RecordMeta {
-					meta.LastTxID = rc.LastTxID
-					return meta
-				})
-				// 可能出现无法原子commit的关键在于更新的记录可能被删除，导致了后面操作无法commit，那就忽略这个错误就不会出现原子问题
-				// commit更新record，出现错误，就没有HandleDataBeforeUpdate，可是afterCommit却并不知道这个记录没有还是去找了，导致了nil panic
-				// (1 让tx传进来然后删除对应txCache，那么afterCommit就当作这个提交不存在 (2 忽略不存在的txData
-				if err != nil && err != ErrKeyNotFound && err != ErrUpdateSame {
-					return err
-				}
+// commit 提交事务缓存。先检查写冲突以及所有操作记录再执行，任一操作失败时按相反顺序撤销已经执行的操作，返回原来的错误。
+// 撤销也失败时返回RevertError。事务中插入时已经分配的自增id不会收回
+func (s *idbServer) commit(tx *Tx) error {
+	cache := tx.cache
+	// 提交期间表结构不能变化，外键相关的表也不能被修改
+	unlock := s.lockForWrite(txTables(cache), true, true)
+	defer unlock()
 
-			case INSERT:
-				err = c.t.data.Insert(rc.opChange.(*InsertOpChange).record)
+	// 事务开始后表可能被删除、清空或者重命名，不能写入已经不在库中的表
+	err := s.checkTxTables(cache)
+	if err != nil {
+		return err
+	}
+	if tx.checksWriteConflict() {
+		err = checkWriteConflict(tx)
+		if err != nil {
+			return err
+		}
+	}
+
+	// 事务开始后表结构可能变化了，提交前再检查一遍数据
+	for name, c := range cache {
+		err = c.t.validateTxCache(c.cache)
+		if err != nil {
+			return withTableName(err, name)
+		}
+	}
+	err = (&fkView{s: s, cache: cache}).checkTx()
+	if err != nil {
+		return err
+	}
+	if tx.sx != nil {
+		err = tx.sx.ssi.commit(tx.sx, cache)
+		if err != nil {
+			return err
+		}
+	}
+
+	// 先删除再更新最后插入，删除后再插入相同主键才不会冲突
+	var applied []*appliedOp
+	for _, op := range commitOrder {
+		for name, c := range cache {
+			for key, rc := range c.cache {
+				if rc.op != op {
+					continue
+				}
+				a, err := s.commitOpRecord(c, key, rc)
 				if err != nil {
-					return err
+					if tx.sx != nil {
+						tx.sx.ssi.undoCommit(tx.sx)
+					}
+					return withRevert(withTableName(err, name), applied)
 				}
-
-			case DELETE:
-				err = c.t.data.Delete(key)
-				// 还要忽略删除时的ErrKeyNotFound
-				if err != nil && err != ErrKeyNotFound {
-					return err
+				if a != nil {
+					applied = append(applied, a)
 				}
-
 			}
 		}
 	}
@@ -370,12 +578,169 @@ func (s *idbServer) commit(cache map[string]*txCache) error {
 	return nil
 }
 
+var commitOrder = []opType{DELETE, UPDATE, INSERT}
+
+// checkTxTables 检查事务缓存的表是否仍是库中该表名对应的表
+func (s *idbServer) checkTxTables(cache map[string]*txCache) error {
+	s.DB.mu.RLock()
+	defer s.DB.mu.RUnlock()
+
+	for name, c := range cache {
+		if s.DB.tables[name] != c.t {
+			return ErrTableChanged
+		}
+	}
+	return nil
+}
+
+// checkWriteConflict 先提交者胜出。事务更新或删除的record在readView之后被其他事务修改或者删除时返回WriteConflictError
+func checkWriteConflict(tx *Tx) error {
+	for name, c := range tx.cache {
+		for id, rc := range c.cache {
+			if rc.op == INSERT {
+				continue
+			}
+			r, err := c.t.data.Find(id)
+			if err != nil || (r.Meta.LastTxID != tx.id && !tx.rv.visible(r.Meta.LastTxID)) {
+				return &WriteConflictError{Table: name, ID: id}
+			}
+		}
+	}
+	return nil
+}
+
+// appliedOp 提交时已经执行的操作，用于撤销
+type appliedOp struct {
+	t   *table
+	op  opType
+	key int
+	// 执行前的record，插入时为nil
+	before *Record
+	txID   int
+}
+
+// commitOpRecord 提交单条操作记录，返回已经执行的操作。删除的record已经被删除时不执行，返回nil；
+// 更新的record已经被删除时返回WriteConflictError
+func (s *idbServer) commitOpRecord(c *txCache, key int, rc *OpRecord) (*appliedOp, error) {
+	var err error
+	switch rc.op {
+	case UPDATE:
+		before, ok := beforeImage(c.t, key)
+		if !ok {
+			// 不检查写冲突的隔离级别下record可能已经被其他事务删除，不能当作更新成功
+			return nil, &WriteConflictError{ID: key}
+		}
+		// TODO 怎么更新record的lastTxID呢
+		opChange := rc.opChange.(*UpdateOpChange)
+		err = c.t.updateRecord(opChange.change, key, func(meta *RecordMeta) *RecordMeta {
+			meta.LastTxID = rc.LastTxID
+			return meta
+		})
+		// 值没有变化时inspector也已经记录了更新前的数据
+		if err != nil && err != ErrUpdateSame {
+			return nil, err
+		}
+		return &appliedOp{t: c.t, op: UPDATE, key: key, before: before, txID: rc.LastTxID}, nil
+
+	case INSERT:
+		err = c.t.insertRecord(rc.opChange.(*InsertOpChange).record)
+		if err != nil {
+			return nil, err
+		}
+		return &appliedOp{t: c.t, op: INSERT, key: key}, nil
+
+	case DELETE:
+		before, ok := beforeImage(c.t, key)
+		if !ok {
+			return nil, nil
+		}
+		err = c.t.deleteRecord(key, true)
+		if err != nil {
+			return nil, err
+		}
+		return &appliedOp{t: c.t, op: DELETE, key: key, before: before}, nil
+	}
+
+	return nil, nil
+}
+
+// beforeImage 复制record，包括meta。更新时meta会被原地修改
+func beforeImage(t *table, key int) (*Record, bool) {
+	r, err := t.data.Find(key)
+	if err != nil {
+		return nil, false
+	}
+	return copyRecord(r), true
+}
+
+// RevertError 提交失败后撤销已经执行的操作也失败了，表中可能留有部分提交的数据。
+// errors.Is、errors.As可以同时匹配提交失败和撤销失败的原因
+type RevertError struct {
+	Err       error
+	RevertErr error
+}
+
+func (e *RevertError) Error() string {
+	return fmt.Sprintf("%v; revert failed: %v", e.Err, e.RevertErr)
+}
+
+func (e *RevertError) Unwrap() []error {
+	return []error{e.Err, e.RevertErr}
+}
+
+// withRevert 撤销已经执行的操作，撤销失败时同时返回原来的错误和撤销的错误
+func withRevert(err error, applied []*appliedOp) error {
+	rerr := revertOps(applied)
+	if rerr != nil {
+		return &RevertError{Err: err, RevertErr: rerr}
+	}
+	return err
+}
+
+// revertOps 按相反顺序撤销已经执行的操作，并清除inspector中对应的数据，提交失败的事务不会写入undoLog。
+// 某个操作撤销失败时继续撤销其余操作，返回第一个错误
+func revertOps(applied []*appliedOp) error {
+	var first error
+	for i := len(applied) - 1; i >= 0; i-- {
+		a := applied[i]
+		ti, _ := a.t.data.inspector.(*tableInspector)
+		var err error
+		switch a.op {
+		case INSERT:
+			err = a.t.deleteRecord(a.key, false)
+		case UPDATE:
+			err = a.t.restoreRecord(a.before)
+			if ti != nil {
+				ti.ui.GetRecordBeforeUpdate(ti.tableName, a.txID, a.key)
+			}
+		case DELETE:
+			err = a.t.insertRecord(a.before)
+			if ti != nil {
+				ti.ui.GetRecordBeforeDelete(ti.tableName, a.key)
+			}
+		}
+		if err != nil && first == nil {
+			first = err
+		}
+	}
+	return first
+}
+
 func (s *idbServer) UpdateByID(tableName string, values map[string]interface{}, id int) error {
 	// 找到对应表
-	t, ok := s.DB.tables[tableName]
-	if !ok {
-		return ErrTableNotExist
+	t, err := s.getTable(tableName)
+	if err != nil {
+		return err
+	}
+
+	// 等待加锁的事务结束，持有表的写锁之前加行锁，否则持有行锁的事务无法提交
+	release, err := s.lockRowForWrite(tableName, id)
+	if err != nil {
+		return err
 	}
+	defer release()
+	unlock := s.lockForWrite(map[string]*table{tableName: t}, true, false)
+	defer unlock()
 
 	// 将更新数据转化为string类型
 	data, err := convValuesToBPlusData(t, values)
@@ -383,38 +748,43 @@ func (s *idbServer) UpdateByID(tableName string, values map[string]interface{},
 		return err
 	}
 
+	// 更新外键字段时父表数据需要存在
+	record, err := t.data.Find(id)
+	if err != nil {
+		return err
+	}
+	err = (&fkView{s: s}).checkParents(tableName, t, applyChange(record, data), data)
+	if err != nil {
+		return err
+	}
+
 	// 更新数据
-	return t.data.UpdateRecord(data, id, nil)
+	return withTableName(t.updateRecord(data, id, nil), tableName)
 }
 
 func convValuesToBPlusData(t *table, values map[string]interface{}) (map[int]string, error) {
-	fields := t.meta.fields
+	fields := t.meta.getFields()
 	data := make(map[int]string)
-	fieldNameIndexCache := make(map[string]int)
+	var f *FieldMeta
 	var v string
 	var err error
-	var i, startIndex int
-	var ok bool
 	for key, value := range values {
-		i, ok = fieldNameIndexCache[key]
-		if !ok {
-			for ; startIndex < len(fields); startIndex++ {
-				fieldNameIndexCache[fields[startIndex].name] = startIndex
-				if fields[startIndex].name == key {
-					i = startIndex
-					break
-				}
-			}
-			if startIndex >= len(fields) {
-				return nil, ErrFieldNotExist
-			}
+		f, err = findField(fields, key)
+		if err != nil {
+			return nil, err
+		}
+		if f.isPrimaryKey {
+			return nil, ErrPrimaryKeyImmutable
+		}
+		if f.generated != nil {
+			return nil, ErrGeneratedColumn
 		}
 
-		v, err = convertValueToString(fields[i].tp, value)
+		v, err = convertValueToString(f.tp, value)
 		if err != nil {
 			return nil, err
 		}
-		data[i] = v
+		data[f.pos] = v
 	}
 
 	return data, nil
@@ -442,27 +812,51 @@ func convertValueToString(ft fieldType, v interface{}) (string, error) {
 	}
 }
 
-// Insert 插入数据。自动递增主键
+// Insert 插入数据。未定义主键或主键自增时自动递增主键
 func (s *idbServer) Insert(tableName string, data []interface{}) error {
+	return s.insert(tableName, func(fields []*FieldMeta) ([]string, error) {
+		return convDataToStorageData(fields, data)
+	})
+}
+
+// convFunc 将插入数据按照表结构转换为record.Value
+type convFunc func(fields []*FieldMeta) ([]string, error)
+
+func (s *idbServer) insert(tableName string, conv convFunc) error {
 	// 找到对应表
-	t, ok := s.DB.tables[tableName]
-	if !ok {
-		return ErrTableNotExist
+	t, err := s.getTable(tableName)
+	if err != nil {
+		return err
 	}
 
+	unlock := s.lockForWrite(map[string]*table{tableName: t}, true, false)
+	defer unlock()
+
 	// 检查插入数据类型一致
-	innerData, err := convDataToStorageData(t.meta.fields, data)
+	innerData, err := conv(t.meta.getFields())
 	if err != nil {
 		return err
 	}
 
-	// 递增表主键
+	// 分配表主键
+	key, err := t.assignKey(innerData)
+	if err != nil {
+		return err
+	}
 	r := &Record{
-		Key:   int(atomic.AddInt64(&(t.meta.idCount), 1)),
+		Key:   key,
 		Value: innerData,
 		Meta:  &RecordMeta{},
 	}
-	return t.data.Insert(r)
+	_, err = t.prepareRecord(r)
+	if err != nil {
+		return withTableName(err, tableName)
+	}
+	err = (&fkView{s: s}).checkParents(tableName, t, r, nil)
+	if err != nil {
+		return err
+	}
+	return withTableName(t.insertRecord(r), tableName)
 }
 
 func convDataToStorageData(fields []*FieldMeta, data []interface{}) ([]string, error) {
@@ -471,13 +865,20 @@ func convDataToStorageData(fields []*FieldMeta, data []interface{}) ([]string, e
 	}
 	var d interface{}
 	var err error
-	innerData := make([]string, len(fields))
+	innerData := make([]string, recordWidth(fields))
 	for i := 0; i < len(fields); i++ {
 		d = data[i]
-		if d == nil && fields[i].required {
+		// 生成列由写入时计算
+		if fields[i].generated != nil {
+			if d != nil {
+				return nil, ErrGeneratedColumn
+			}
+			continue
+		}
+		if d == nil && fields[i].isRequired() {
 			return nil, ErrFieldRequired
 		}
-		innerData[i], err = convertValueToString(fields[i].tp, d)
+		innerData[fields[i].pos], err = convertValueToString(fields[i].tp, d)
 		if err != nil {
 			return nil, err
 		}
@@ -487,6 +888,12 @@ func convDataToStorageData(fields []*FieldMeta, data []interface{}) ([]string, e
 }
 
 func (s *idbServer) InsertTx(tx *Tx, tableName string, data []interface{}) error {
+	return s.insertTx(tx, tableName, func(fields []*FieldMeta) ([]string, error) {
+		return convDataToStorageData(fields, data)
+	})
+}
+
+func (s *idbServer) insertTx(tx *Tx, tableName string, conv convFunc) error {
 	// 找到表
 	c, err := s.findTableTxCache(tx, tableName)
 	if err != nil {
@@ -494,11 +901,14 @@ func (s *idbServer) InsertTx(tx *Tx, tableName string, data []interface{}) error
 	}
 	t := c.t
 
-	// 获取record id
-	id := int(atomic.AddInt64(&(t.meta.idCount), 1))
-
 	// 构造record
-	innerData, err := convDataToStorageData(t.meta.fields, data)
+	innerData, err := conv(t.meta.getFields())
+	if err != nil {
+		return err
+	}
+
+	// 获取record id
+	id, err := t.assignKey(innerData)
 	if err != nil {
 		return err
 	}
@@ -507,7 +917,38 @@ func (s *idbServer) InsertTx(tx *Tx, tableName string, data []interface{}) error
 		Value: innerData,
 		Meta:  &RecordMeta{LastTxID: tx.id},
 	}
-	tx.cache[tableName].cache[id] = &OpRecord{
+	_, err = t.prepareRecord(record)
+	if err != nil {
+		return withTableName(err, tableName)
+	}
+
+	// 主键不能重复
+	err = t.checkPKBeforeInsertTx(c.cache, record)
+	if err != nil {
+		return withTableName(err, tableName)
+	}
+
+	// 引用的父表数据需要存在，包括事务中插入的
+	err = (&fkView{s: s, cache: tx.cache}).checkParents(tableName, t, record, nil)
+	if err != nil {
+		return err
+	}
+
+	// 同一事务中先删除再插入相同主键，相当于更新整条record
+	if rc := c.cache[id]; rc != nil && rc.op == DELETE {
+		change := make(map[int]string, len(record.Value))
+		for i, v := range record.Value {
+			change[i] = v
+		}
+		c.cache[id] = &OpRecord{
+			opChange: &UpdateOpChange{change: change},
+			op:       UPDATE,
+			LastTxID: tx.id,
+		}
+		return nil
+	}
+
+	c.cache[id] = &OpRecord{
 		opChange: &InsertOpChange{record: record},
 		op:       INSERT,
 		LastTxID: tx.id,
@@ -517,15 +958,34 @@ func (s *idbServer) InsertTx(tx *Tx, tableName string, data []interface{}) error
 }
 
 func (s *idbServer) DeleteByIDTx(tx *Tx, tableName string, id int) error {
+	tx.newStatement()
 	// 找到表
 	c, err := s.findTableTxCache(tx, tableName)
 	if err != nil {
 		return err
 	}
+
+	// 找到引用该数据的子表数据，在事务中一起级联
+	plan := newFKPlan()
+	err = (&fkView{s: s, cache: tx.cache}).planDelete(plan, tableName, c.t, id)
+	if err != nil {
+		return err
+	}
+
+	err = deleteInTxCache(c, id)
+	if err != nil {
+		return err
+	}
+	return plan.applyTx(s, tx)
+}
+
+// deleteInTxCache 在事务缓存中记录删除
+func deleteInTxCache(c *txCache, id int) error {
 	t := c.t
 
 	// 尝试找到recordCache
 	var record *Record
+	var err error
 	cr := c.cache[id]
 	// 若recordCache存在，则记录操作为删除就报错，为其他就直接删除该recordCache
 	if cr != nil {
@@ -557,6 +1017,9 @@ func (s *idbServer) DeleteByIDTx(tx *Tx, tableName string, id int) error {
 }
 
 func (s *idbServer) findTableTxCache(tx *Tx, tableName string) (*txCache, error) {
+	if tx.readOnly {
+		return nil, ErrReadOnlyTx
+	}
 	var t *table
 	// 尝试在缓存中找到表
 	c, ok := tx.cache[tableName]
@@ -564,9 +1027,10 @@ func (s *idbServer) findTableTxCache(tx *Tx, tableName string) (*txCache, error)
 		t = c.t
 	} else {
 		// 找到对应表
-		t, ok = s.DB.tables[tableName]
-		if !ok {
-			return nil, ErrTableNotExist
+		var err error
+		t, err = s.getTable(tableName)
+		if err != nil {
+			return nil, err
 		}
 
 		// 增加该表对应的txCache
@@ -581,11 +1045,26 @@ func (s *idbServer) findTableTxCache(tx *Tx, tableName string) (*txCache, error)
 
 func (s *idbServer) DeleteByID(tableName string, id int) error {
 	// 找到对应表
-	t, ok := s.DB.tables[tableName]
-	if !ok {
-		return ErrTableNotExist
+	t, err := s.getTable(tableName)
+	if err != nil {
+		return err
+	}
+
+	release, err := s.lockRowForWrite(tableName, id)
+	if err != nil {
+		return err
+	}
+	defer release()
+	unlock := s.lockForWrite(map[string]*table{tableName: t}, false, true)
+	defer unlock()
+
+	// 找到引用该数据的子表数据
+	plan := newFKPlan()
+	err = (&fkView{s: s}).planDelete(plan, tableName, t, id)
+	if err != nil {
+		return err
 	}
 
-	// 删除b+树中数据
-	return t.data.Delete(id)
+	// 删除b+树中数据以及级联的数据
+	return plan.apply(tableName, t, []int{id})
 }
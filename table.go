@@ -0,0 +1,449 @@
+package IDB
+
+import (
+	"sort"
+	"strconv"
+	"sync"
+)
+
+func newTable(meta *tableMeta, data *Tree) *table {
+	t := &table{
+		meta:    meta,
+		stats:   newTableStats(),
+		data:    data,
+		writeMu: &sync.Mutex{},
+	}
+	if pk := meta.primaryKey(); pk != nil && pk.tp == STRING {
+		t.pkIndex = newIndex(primaryKeyConstraint, []*FieldMeta{pk}, true)
+	}
+	return t
+}
+
+func newTableMeta(defs []*FieldMeta) *tableMeta {
+	// 复制字段定义，同一份定义可能被用于创建多个表
+	fields := make([]*FieldMeta, len(defs))
+	var indexes []*index
+	for i, def := range defs {
+		f := *def
+		f.pos = i
+		fields[i] = &f
+		// 主键本身已保证唯一
+		if f.unique && !f.isPrimaryKey {
+			indexes = append(indexes, newIndex(f.name, []*FieldMeta{&f}, true))
+		}
+	}
+	return &tableMeta{
+		idCount:   0,
+		mu:        &sync.RWMutex{},
+		fields:    fields,
+		slotCount: len(fields),
+		indexes:   indexes,
+	}
+}
+
+// getFields 获取当前表结构的字段
+func (m *tableMeta) getFields() []*FieldMeta {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.fields
+}
+
+// cloneSchema 复制表结构以及索引定义，主键计数重新开始
+func (m *tableMeta) cloneSchema() *tableMeta {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	indexes := make([]*index, len(m.indexes))
+	for i, idx := range m.indexes {
+		indexes[i] = idx.emptyCopy()
+	}
+	return &tableMeta{
+		idCount:     0,
+		mu:          &sync.RWMutex{},
+		fields:      m.fields,
+		slotCount:   m.slotCount,
+		indexes:     indexes,
+		foreignKeys: m.foreignKeys,
+		checks:      m.checks,
+	}
+}
+
+// materialize 将record按照当前表结构转换。表结构未变更过时直接返回原record
+func (m *tableMeta) materialize(r *Record) *Record {
+	if r == nil {
+		return nil
+	}
+	fields := m.getFields()
+	if isIdentityLayout(fields, r) {
+		return r
+	}
+
+	values := make([]string, len(fields))
+	for i, f := range fields {
+		values[i] = fieldValue(f, r)
+	}
+	return &Record{
+		Key:   r.Key,
+		Value: values,
+		Meta:  r.Meta,
+	}
+}
+
+func isIdentityLayout(fields []*FieldMeta, r *Record) bool {
+	if len(fields) != len(r.Value) {
+		return false
+	}
+	for i, f := range fields {
+		if f.pos != i {
+			return false
+		}
+	}
+	return true
+}
+
+// fieldValue 获取record中字段的值。旧record中没有该字段时返回默认值
+func fieldValue(f *FieldMeta, r *Record) string {
+	if f.pos < len(r.Value) {
+		return r.Value[f.pos]
+	}
+	return f.defaultValue
+}
+
+// findField 根据字段名找到字段
+func findField(fields []*FieldMeta, name string) (*FieldMeta, error) {
+	for _, f := range fields {
+		if f.name == name {
+			return f, nil
+		}
+	}
+	return nil, ErrFieldNotExist
+}
+
+// recordWidth 新record.Value的长度
+func recordWidth(fields []*FieldMeta) int {
+	var width int
+	for _, f := range fields {
+		if f.pos+1 > width {
+			width = f.pos + 1
+		}
+	}
+	return width
+}
+
+// applyChange 返回应用更新之后的record，不修改原record
+func applyChange(r *Record, change map[int]string) *Record {
+	values := make([]string, len(r.Value))
+	copy(values, r.Value)
+	for i, v := range change {
+		values = setSlot(values, i, v)
+	}
+	return &Record{
+		Key:   r.Key,
+		Value: values,
+		Meta:  r.Meta,
+	}
+}
+
+// copyRecord 复制record的值以及meta
+func copyRecord(r *Record) *Record {
+	if r == nil {
+		return nil
+	}
+	copied := applyChange(r, nil)
+	if r.Meta != nil {
+		meta := *r.Meta
+		copied.Meta = &meta
+	}
+	return copied
+}
+
+// normalizeStoredValue 存储的值的规范形式，与convertValueToString转换的值一致
+func normalizeStoredValue(tp fieldType, v string) string {
+	if v == "" || tp != INT {
+		return v
+	}
+	if i, err := strconv.Atoi(v); err == nil {
+		return strconv.Itoa(i)
+	}
+	return v
+}
+
+// checkStoredValue 检查存储的值是否符合字段类型。表结构变更之后，旧事务写入的值需要再检查一遍
+func checkStoredValue(f *FieldMeta, v string) error {
+	if v == "" || f.tp != INT {
+		return nil
+	}
+	if _, err := strconv.Atoi(v); err != nil {
+		return ErrMismatchFieldType
+	}
+	return nil
+}
+
+// validateTxCache 提交前检查事务写入的数据是否符合当前表结构
+func (t *table) validateTxCache(cache map[int]*OpRecord) error {
+	fields := t.meta.getFields()
+	for id, rc := range cache {
+		switch rc.op {
+		case INSERT:
+			r := rc.opChange.(*InsertOpChange).record
+			for _, f := range fields {
+				if err := checkStoredValue(f, fieldValue(f, r)); err != nil {
+					return err
+				}
+			}
+			if err := t.checkPKBeforeCommit(cache, r); err != nil {
+				return err
+			}
+			// 事务中可能更新过插入的record，重新计算生成列
+			if _, err := t.prepareRecord(r); err != nil {
+				return err
+			}
+
+		case UPDATE:
+			change := rc.opChange.(*UpdateOpChange).change
+			for _, f := range fields {
+				v, ok := change[f.pos]
+				if !ok {
+					continue
+				}
+				if err := checkStoredValue(f, v); err != nil {
+					return err
+				}
+			}
+			if base, err := t.data.Find(id); err == nil {
+				if _, err = t.prepareRecord(applyChange(base, change)); err != nil {
+					return err
+				}
+			}
+		}
+	}
+
+	return t.checkUniqueBeforeCommit(cache)
+}
+
+// checkUniqueBeforeCommit 提交前检查事务写入后的数据是否违反唯一索引
+func (t *table) checkUniqueBeforeCommit(cache map[int]*OpRecord) error {
+	for _, idx := range t.meta.getIndexes() {
+		if !idx.unique {
+			continue
+		}
+
+		// 事务中每个key被哪个record占用
+		claimed := make(map[string]int)
+		for id, rc := range cache {
+			var r *Record
+			switch rc.op {
+			case INSERT:
+				r = rc.opChange.(*InsertOpChange).record
+			case UPDATE:
+				base, err := t.data.Find(id)
+				if err != nil {
+					continue
+				}
+				r = applyChange(base, rc.opChange.(*UpdateOpChange).change)
+			default:
+				continue
+			}
+			if idx.hasNull(r) {
+				continue
+			}
+
+			key := idx.keyOf(r)
+			if other, ok := claimed[key]; ok && other != id {
+				return uniqueError(idx, r)
+			}
+			claimed[key] = id
+			// 表中占用该key的record若被事务删除或更新，由上面的claimed检查
+			for _, eid := range idx.lookup(key) {
+				if eid != id && cache[eid] == nil {
+					return uniqueError(idx, r)
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// 以下为表数据写入口，调用方需持有writeMu
+
+func (t *table) insertRecord(r *Record) error {
+	if t.pkIndex != nil && len(t.pkIndex.lookup(t.pkIndex.keyOf(r))) > 0 {
+		return t.pkError(r)
+	}
+	indexes := t.meta.getIndexes()
+	for _, idx := range indexes {
+		if idx.conflicts(r) {
+			return uniqueError(idx, r)
+		}
+	}
+
+	err := t.data.Insert(r)
+	if err == ErrKeyExists && t.meta.primaryKey() != nil {
+		return t.pkError(r)
+	}
+	if err != nil {
+		return err
+	}
+
+	if t.pkIndex != nil {
+		t.pkIndex.add(t.pkIndex.keyOf(r), r.Key)
+	}
+	for _, idx := range indexes {
+		idx.add(idx.keyOf(r), r.Key)
+	}
+	t.stats.add(t.meta.getFields(), r)
+	return nil
+}
+
+func (t *table) updateRecord(data map[int]string, key int, ma metaAlter) error {
+	record, err := t.data.Find(key)
+	if err != nil {
+		return err
+	}
+
+	// 不修改调用方的data
+	filled := make(map[int]string, len(data))
+	for i, v := range data {
+		filled[i] = v
+	}
+	// 旧record没有新增的字段，更新时补上默认值
+	for _, f := range t.meta.getFields() {
+		if _, ok := filled[f.pos]; !ok && f.pos >= len(record.Value) {
+			filled[f.pos] = f.defaultValue
+		}
+	}
+
+	// 重新计算生成列，并检查CHECK约束
+	updated := applyChange(record, filled)
+	gen, err := t.prepareRecord(updated)
+	if err != nil {
+		return err
+	}
+	for i, v := range gen {
+		filled[i] = v
+	}
+
+	// 先检查更新后的record是否违反唯一索引
+	indexes := t.meta.getIndexes()
+	oldKeys := make([]string, len(indexes))
+	for i, idx := range indexes {
+		oldKeys[i] = idx.keyOf(record)
+		if idx.keyOf(updated) != oldKeys[i] && idx.conflicts(updated) {
+			return uniqueError(idx, updated)
+		}
+	}
+
+	// 树中的record会被原地修改，先复制一份用于更新统计信息
+	old := applyChange(record, nil)
+	err = t.data.UpdateRecord(filled, key, ma)
+	if err != nil {
+		return err
+	}
+
+	for i, idx := range indexes {
+		newKey := idx.keyOf(updated)
+		if newKey != oldKeys[i] {
+			idx.remove(oldKeys[i], key)
+			idx.add(newKey, key)
+		}
+	}
+	fields := t.meta.getFields()
+	t.stats.remove(fields, old)
+	t.stats.add(fields, updated)
+	return nil
+}
+
+// deleteRecord 删除record以及索引、统计信息。inspect为true时inspector记录删除前的数据，事务提交时使用
+func (t *table) deleteRecord(key int, inspect bool) error {
+	record, err := t.data.Find(key)
+	if err != nil {
+		return err
+	}
+
+	if inspect {
+		err = t.data.deleteInspected(key)
+	} else {
+		err = t.data.Delete(key)
+	}
+	if err != nil {
+		return err
+	}
+
+	for _, idx := range t.allIndexes() {
+		idx.remove(idx.keyOf(record), key)
+	}
+	t.stats.remove(t.meta.getFields(), record)
+	return nil
+}
+
+// restoreRecord 将record恢复为before，同时恢复索引以及统计信息
+func (t *table) restoreRecord(before *Record) error {
+	current, err := t.data.Find(before.Key)
+	if err != nil {
+		return err
+	}
+	current = applyChange(current, nil)
+
+	values := make([]string, len(before.Value))
+	copy(values, before.Value)
+	err = t.data.restoreRecord(before.Key, values, before.Meta)
+	if err != nil {
+		return err
+	}
+
+	for _, idx := range t.allIndexes() {
+		oldKey, newKey := idx.keyOf(current), idx.keyOf(before)
+		if oldKey != newKey {
+			idx.remove(oldKey, before.Key)
+			idx.add(newKey, before.Key)
+		}
+	}
+	fields := t.meta.getFields()
+	t.stats.remove(fields, current)
+	t.stats.add(fields, before)
+	return nil
+}
+
+// txTables 事务涉及的表
+func txTables(cache map[string]*txCache) map[string]*table {
+	tables := make(map[string]*table, len(cache))
+	for name, c := range cache {
+		tables[name] = c.t
+	}
+	return tables
+}
+
+// lockTables 按表名顺序给表加写锁，返回解锁方法
+func lockTables(tables map[string]*table) func() {
+	names := make([]string, 0, len(tables))
+	for name := range tables {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	locked := make([]*table, 0, len(names))
+	for _, name := range names {
+		t := tables[name]
+		// 重命名后可能出现同一张表
+		if containsTable(locked, t) {
+			continue
+		}
+		t.writeMu.Lock()
+		locked = append(locked, t)
+	}
+
+	return func() {
+		for i := len(locked) - 1; i >= 0; i-- {
+			locked[i].writeMu.Unlock()
+		}
+	}
+}
+
+func containsTable(ts []*table, t *table) bool {
+	for _, e := range ts {
+		if e == t {
+			return true
+		}
+	}
+	return false
+}
@@ -2,9 +2,11 @@ package IDB
 
 import (
 	"errors"
+	"fmt"
 	"math"
 	"sync"
 	"sync/atomic"
+	"time"
 )
 
 const (
@@ -12,23 +14,46 @@ const (
 )
 
 var (
-	ErrNoSuchATableInTxMgr = errors.New("transaction: no such table in tx mgr")
+	ErrNoSuchATableInTxMgr  = errors.New("transaction: no such table in tx mgr")
+	ErrWriteConflict        = errors.New("transaction: write conflict")
+	ErrSerializationFailure = errors.New("transaction: could not serialize access")
+	ErrReadOnlyTx           = errors.New("transaction: read only transaction")
+	ErrTableChanged         = errors.New("transaction: table dropped, truncated or renamed during transaction")
 )
 
+// WriteConflictError 事务修改的record在事务开始后被其他事务提交修改或者删除，errors.Is可以匹配ErrWriteConflict
+type WriteConflictError struct {
+	Table string
+	ID    int
+}
+
+func (e *WriteConflictError) Error() string {
+	return fmt.Sprintf("%v: table %s id %d", ErrWriteConflict, e.Table, e.ID)
+}
+
+func (e *WriteConflictError) Is(target error) bool {
+	return target == ErrWriteConflict
+}
+
 type TxMgr interface {
 	StartTransaction() *Tx
+	StartTransactionWithOptions(level IsolationLevel, opts ...TxOptions) *Tx
 	AfterCommit(tx *Tx)
 	AfterRollback(tx *Tx)
-	FindRecordInUndoLog(tableName string, recordID int, activeTxIDs map[int]bool) (*Record, error)
+	FindRecordInUndoLog(tableName string, recordID int, rv *readView) (*Record, error)
+	RecordIDsInUndoLog(tableName string) []int
+	currentReadView() *readView
+	lockVersions() func()
+	rlockVersions() func()
 }
 
 type TxExecutor interface {
-	commit(cache map[string]*txCache) error
+	commit(tx *Tx) error
 }
 
 type UndoRecordsCollector interface {
-	GetRecordBeforeUpdate(txID, recordID int) (*Record, error)
-	GetRecordBeforeDelete(recordID int) (*Record, error)
+	GetRecordBeforeUpdate(tableName string, txID, recordID int) (*Record, error)
+	GetRecordBeforeDelete(tableName string, recordID int) (*Record, error)
 }
 
 type Tx struct {
@@ -37,6 +62,15 @@ type Tx struct {
 	cache    map[string]*txCache
 	rv       *readView
 	mgr      TxMgr
+	level    IsolationLevel
+	readOnly bool
+	// SERIALIZABLE事务的读写集合，安全快照的只读事务为nil
+	sx          *sxact
+	locks       *lockManager
+	lockTimeout time.Duration
+	// 被选为死锁的牺牲者时提交返回该错误
+	abortErr   error
+	savepoints []*savepoint
 }
 
 type readView struct {
@@ -45,6 +79,11 @@ type readView struct {
 	nextTxID    int
 }
 
+// visible 最后修改record的事务对readView是否可见。开始时没有活跃事务的minActiveID为InvalidTxID，之后开始的事务仍然不可见
+func (rv *readView) visible(lastTxID int) bool {
+	return lastTxID < rv.nextTxID && (lastTxID < rv.minActiveID || !rv.activeTxIDs[lastTxID])
+}
+
 type txCache struct {
 	t *table
 	// recordID以及该record的操作记录
@@ -80,18 +119,42 @@ type UpdateOpChange struct {
 	record *Record
 }
 
+// Commit 提交事务。提交失败时整个事务回滚，并返回失败原因
 func (tx *Tx) Commit() error {
-	err := tx.executor.commit(tx.cache)
+	if tx.abortErr != nil {
+		tx.Rollback()
+		return tx.abortErr
+	}
+	// 等待其他事务释放修改的行的锁之后才能写入
+	err := tx.lockWriteSet()
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	err = tx.apply()
 	if err != nil {
-		return tx.Rollback()
+		tx.Rollback()
+		return err
+	}
+	return nil
+}
+
+// apply 修改b+树并记录undoLog，期间读者等待
+func (tx *Tx) apply() error {
+	defer tx.mgr.lockVersions()()
+	err := tx.executor.commit(tx)
+	if err != nil {
+		return err
 	}
 	tx.mgr.AfterCommit(tx)
 	return nil
 }
 
 func (tx *Tx) Rollback() error {
-	defer tx.mgr.AfterRollback(tx)
+	// AfterRollback需要知道事务涉及的表，之后再清空缓存
+	tx.mgr.AfterRollback(tx)
 	tx.cache = make(map[string]*txCache)
+	tx.savepoints = nil
 	return nil
 }
 
@@ -103,32 +166,67 @@ type TxMgrImpl struct {
 	activeTxIDs   map[int]bool
 	undoLogs      map[string]*UndoLog
 	undoCollector UndoRecordsCollector
+	ssi           *ssiTracker
+	locks         *lockManager
+	// 提交修改b+树以及undoLog时持有写锁
+	versionMu *sync.RWMutex
 }
 
+// StartTransaction 开始REPEATABLE_READ隔离级别的事务
 func (tm *TxMgrImpl) StartTransaction() *Tx {
+	return tm.StartTransactionWithOptions(REPEATABLE_READ)
+}
+
+// StartTransactionWithOptions 开始指定隔离级别的事务
+func (tm *TxMgrImpl) StartTransactionWithOptions(level IsolationLevel, opts ...TxOptions) *Tx {
+	var opt TxOptions
+	if len(opts) > 0 {
+		opt = opts[0]
+	}
+
 	tm.mu.Lock()
 	defer tm.mu.Unlock()
 
 	id := int(atomic.AddInt64(&(tm.txIDCounter), 1))
+	rv := tm.newReadView(id + 1)
+	tm.activeTxIDs[id] = true
+
+	tx := &Tx{
+		id:          id,
+		executor:    tm.executor,
+		cache:       make(map[string]*txCache),
+		rv:          rv,
+		mgr:         tm,
+		level:       level,
+		readOnly:    opt.ReadOnly,
+		locks:       tm.locks,
+		lockTimeout: opt.LockTimeout,
+	}
+	if level == SERIALIZABLE {
+		tx.sx = tm.ssi.begin(id, rv, opt.ReadOnly)
+	}
+	return tx
+}
+
+// currentReadView 当前的readView，已经提交的事务都可见
+func (tm *TxMgrImpl) currentReadView() *readView {
+	tm.mu.Lock()
+	defer tm.mu.Unlock()
+
+	return tm.newReadView(int(atomic.LoadInt64(&tm.txIDCounter)) + 1)
+}
+
+// newReadView 调用方需持有mu
+func (tm *TxMgrImpl) newReadView(nextTxID int) *readView {
 	minTxID := InvalidTxID
 	for txid, _ := range tm.activeTxIDs {
 		minTxID = minInt(minTxID, txid)
 	}
-	rv := &readView{
+	return &readView{
 		// TODO map不复制就会跟着原来的map一直变化
 		activeTxIDs: copyMap(tm.activeTxIDs),
 		minActiveID: minTxID,
-		nextTxID:    id + 1,
-	}
-
-	tm.activeTxIDs[id] = true
-
-	return &Tx{
-		id:       id,
-		executor: tm.executor,
-		cache:    make(map[string]*txCache),
-		rv:       rv,
-		mgr:      tm,
+		nextTxID:    nextTxID,
 	}
 }
 
@@ -144,6 +242,10 @@ func (tm *TxMgrImpl) AfterCommit(tx *Tx) {
 	defer tm.mu.Unlock()
 
 	delete(tm.activeTxIDs, tx.id)
+	if tx.sx != nil {
+		tm.ssi.end(tx.sx)
+	}
+	tm.locks.releaseAll(tx.id)
 
 	// 遍历cache将提交添加到undoLog中
 	for tableName, tc := range tx.cache {
@@ -151,17 +253,17 @@ func (tm *TxMgrImpl) AfterCommit(tx *Tx) {
 			tm.undoLogs[tableName] = NewUndoLog()
 		}
 		log := tm.undoLogs[tableName]
-		log.Append(tx.id, tm.activeTxIDs, tm.convToUndoRecord(tx.id, tc.cache))
+		log.Append(tx.id, tm.activeTxIDs, tm.convToUndoRecord(tableName, tx.id, tc.cache))
 	}
 }
 
-func (tm *TxMgrImpl) convToUndoRecord(txID int, rcs map[int]*OpRecord) []*UndoRecord {
+func (tm *TxMgrImpl) convToUndoRecord(tableName string, txID int, rcs map[int]*OpRecord) []*UndoRecord {
 	// TODO 更新、删除的时候这不还要求传递原来record的进来嘛
 	records := make([]*UndoRecord, 0, len(rcs))
 	for rid, opRecord := range rcs {
 		switch opRecord.op {
 		case UPDATE:
-			r, err := tm.undoCollector.GetRecordBeforeUpdate(txID, rid)
+			r, err := tm.undoCollector.GetRecordBeforeUpdate(tableName, txID, rid)
 			if err != nil {
 				if err == ErrNoSuchRecordInUndoInspector {
 					continue
@@ -175,7 +277,7 @@ func (tm *TxMgrImpl) convToUndoRecord(txID int, rcs map[int]*OpRecord) []*UndoRe
 			records = append(records, ur)
 
 		case DELETE:
-			r, err := tm.undoCollector.GetRecordBeforeDelete(rid)
+			r, err := tm.undoCollector.GetRecordBeforeDelete(tableName, rid)
 			if err != nil {
 				if err == ErrNoSuchRecordInUndoInspector {
 					continue
@@ -208,6 +310,10 @@ func (tm *TxMgrImpl) AfterRollback(tx *Tx) {
 	defer tm.mu.Unlock()
 
 	delete(tm.activeTxIDs, tx.id)
+	if tx.sx != nil {
+		tm.ssi.end(tx.sx)
+	}
+	tm.locks.releaseAll(tx.id)
 
 	// 删除被该事务影响的缓存
 	for tableName, _ := range tx.cache {
@@ -226,22 +332,137 @@ func (tm *TxMgrImpl) AfterRollback(tx *Tx) {
 	}
 }
 
-func (tm *TxMgrImpl) FindRecordInUndoLog(tableName string, recordID int, activeTxIDs map[int]bool) (*Record, error) {
+// FindRecordInUndoLog 找到readView中record的版本，表没有undoLog时返回ErrNoSuchATableInTxMgr
+func (tm *TxMgrImpl) FindRecordInUndoLog(tableName string, recordID int, rv *readView) (*Record, error) {
+	tm.mu.RLock()
+	defer tm.mu.RUnlock()
+
 	if tm.undoLogs[tableName] == nil {
 		return nil, ErrNoSuchATableInTxMgr
 	}
 
-	// 不应该用当前tx，而是start之后的tx
-	return tm.undoLogs[tableName].Find(activeTxIDs, recordID)
+	return tm.undoLogs[tableName].Find(rv, recordID)
 }
 
+// lockVersions 提交期间持有，读者不会看到b+树已经修改而undoLog还没有记录的中间状态
+func (tm *TxMgrImpl) lockVersions() func() {
+	tm.versionMu.Lock()
+	return tm.versionMu.Unlock
+}
+
+// rlockVersions 读取b+树以及undoLog中的版本期间持有
+func (tm *TxMgrImpl) rlockVersions() func() {
+	tm.versionMu.RLock()
+	return tm.versionMu.RUnlock
+}
+
+// RecordIDsInUndoLog 表的undoLog中有历史版本的record id，可能已经被删除
+func (tm *TxMgrImpl) RecordIDsInUndoLog(tableName string) []int {
+	tm.mu.RLock()
+	defer tm.mu.RUnlock()
+
+	if tm.undoLogs[tableName] == nil {
+		return nil
+	}
+	return tm.undoLogs[tableName].RecordIDs()
+}
+
+// dropUndoLog 删除表对应的undoLog
+func (tm *TxMgrImpl) dropUndoLog(tableName string) {
+	tm.mu.Lock()
+	defer tm.mu.Unlock()
+
+	delete(tm.undoLogs, tableName)
+}
+
+// renameUndoLog 表重命名之后，undoLog跟着表走
+func (tm *TxMgrImpl) renameUndoLog(oldName, newName string) {
+	tm.mu.Lock()
+	defer tm.mu.Unlock()
+
+	log, ok := tm.undoLogs[oldName]
+	if !ok {
+		return
+	}
+	delete(tm.undoLogs, oldName)
+	tm.undoLogs[newName] = log
+}
+
+// txMgrRegistry 执行器可以实现该接口，只使用一个事务管理器。已经有事务管理器时返回它，否则使用tm
+type txMgrRegistry interface {
+	registerTxMgr(tm *TxMgrImpl) *TxMgrImpl
+}
+
+// undoCollectorProvider 执行器可以实现该接口，collector为nil时使用执行器提供的collector
+type undoCollectorProvider interface {
+	undoCollector() UndoRecordsCollector
+}
+
+// NewTxMgr 创建事务管理器。collector为nil时，idbServer使用自己的inspector收集修改前的数据。
+// idbServer只有一个事务管理器，已经创建过时返回它，collector不生效
 func NewTxMgr(e TxExecutor, collector UndoRecordsCollector) TxMgr {
-	return &TxMgrImpl{
+	if p, ok := e.(undoCollectorProvider); ok && collector == nil {
+		collector = p.undoCollector()
+	}
+	tm := &TxMgrImpl{
 		txIDCounter:   0,
 		executor:      e,
 		mu:            &sync.RWMutex{},
 		activeTxIDs:   make(map[int]bool),
 		undoLogs:      make(map[string]*UndoLog),
 		undoCollector: collector,
+		ssi:           newSSITracker(),
+		locks:         newLockManager(),
+		versionMu:     &sync.RWMutex{},
+	}
+	if r, ok := e.(txMgrRegistry); ok {
+		return r.registerTxMgr(tm)
+	}
+	return tm
+}
+
+// serverCollector 从server当前的inspector中取出修改前的数据，inspector替换后仍然一致
+type serverCollector struct {
+	s *idbServer
+}
+
+func (s *idbServer) undoCollector() UndoRecordsCollector {
+	return &serverCollector{s: s}
+}
+
+func (c *serverCollector) GetRecordBeforeUpdate(tableName string, txID, recordID int) (*Record, error) {
+	collector, ok := c.s.config.options.inspector.(UndoRecordsCollector)
+	if !ok {
+		return nil, ErrNoSuchRecordInUndoInspector
+	}
+	return collector.GetRecordBeforeUpdate(tableName, txID, recordID)
+}
+
+func (c *serverCollector) GetRecordBeforeDelete(tableName string, recordID int) (*Record, error) {
+	collector, ok := c.s.config.options.inspector.(UndoRecordsCollector)
+	if !ok {
+		return nil, ErrNoSuchRecordInUndoInspector
+	}
+	return collector.GetRecordBeforeDelete(tableName, recordID)
+}
+
+// TxMgr server的事务管理器，没有时创建一个
+func (s *idbServer) TxMgr() TxMgr {
+	s.txMgrMu.Lock()
+	tm := s.txMgr
+	s.txMgrMu.Unlock()
+
+	if tm != nil {
+		return tm
+	}
+	return NewTxMgr(s, nil)
+}
+
+// Begin 开始事务，opts中未指定隔离级别时使用REPEATABLE_READ
+func (s *idbServer) Begin(opts ...TxOptions) *Tx {
+	level := REPEATABLE_READ
+	if len(opts) > 0 && opts[0].Isolation != 0 {
+		level = opts[0].Isolation
 	}
+	return s.TxMgr().StartTransactionWithOptions(level, opts...)
 }
@@ -1,6 +1,9 @@
 package IDB
 
 import (
+	"context"
+	"errors"
+	"strings"
 	"sync"
 	"testing"
 )
@@ -693,6 +696,11 @@ func TestConcurrentCommit(t *testing.T) {
 				return
 			}
 			err = upTx.Commit()
+			// 同一record并发的更新和删除，后提交的失败
+			if errors.Is(err, ErrWriteConflict) {
+				t.Logf("update key %d err write conflict \n", i)
+				return
+			}
 			if err != nil {
 				t.Error(err)
 				return
@@ -712,6 +720,11 @@ func TestConcurrentCommit(t *testing.T) {
 				return
 			}
 			err = delTx.Commit()
+			// 同一record并发的更新和删除，后提交的失败
+			if errors.Is(err, ErrWriteConflict) {
+				t.Logf("delete key %d err write conflict \n", i)
+				return
+			}
 			if err != nil {
 				t.Error(err)
 				return
@@ -720,3 +733,529 @@ func TestConcurrentCommit(t *testing.T) {
 	}
 	wg.Wait()
 }
+
+func TestCommitAtomic(t *testing.T) {
+	server := NewIDBServer()
+	inspector := NewUndoInspector()
+	server.WithOptions(func(option *ServerOptionConfig) {
+		option.inspector = inspector
+	})
+	err := createPeopleTable(server)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = server.CreateIndex("people", "city", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = server.Analyze("people")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tm := NewTxMgr(server, inspector)
+
+	tx := tm.StartTransaction()
+	err = server.UpdateByIDTx(tx, "people", map[string]interface{}{"city": "guangzhou"}, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = server.DeleteByIDTx(tx, "people", 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = server.InsertTx(tx, "people", []interface{}{"frank", 7, "beijing"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 检查时发现不了的冲突，插入时才失败
+	tx.cache["people"].cache[3] = &OpRecord{
+		op:       INSERT,
+		opChange: &InsertOpChange{record: &Record{Key: 3, Value: []string{"x", "1", "y"}, Meta: &RecordMeta{LastTxID: tx.id}}},
+	}
+	err = tx.Commit()
+	if err != ErrKeyExists {
+		t.Fatalf("expected %v got %v", ErrKeyExists, err)
+	}
+
+	// 已经执行的操作都被撤销
+	names, err := selectNames(server, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(names, ",") != "alice,bob,carol,dave,eve_1" {
+		t.Fatalf("unexpected %v", names)
+	}
+	names, err = selectNames(server, Eq("city", "beijing"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(names, ",") != "alice,carol" {
+		t.Fatalf("unexpected %v", names)
+	}
+	stats, err := server.Stats("people")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.Rows != 5 || stats.Columns[2].Min != "beijing" {
+		t.Fatalf("unexpected %+v", stats.Columns[2])
+	}
+	if len(inspector.data.upData) != 0 || len(inspector.data.delData) != 0 {
+		t.Fatalf("unexpected %v %v", inspector.data.upData, inspector.data.delData)
+	}
+
+	// 之后的事务不受影响
+	tx = tm.StartTransaction()
+	err = server.UpdateByIDTx(tx, "people", map[string]interface{}{"city": "guangzhou"}, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = tx.Commit()
+	if err != nil {
+		t.Fatal(err)
+	}
+	names, err = selectNames(server, Eq("city", "guangzhou"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(names) != 1 || names[0] != "alice" {
+		t.Fatalf("unexpected %v", names)
+	}
+}
+
+func TestRevertError(t *testing.T) {
+	server := NewIDBServer()
+	err := createPeopleTable(server)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tb, err := server.getTable("people")
+	if err != nil {
+		t.Fatal(err)
+	}
+	before, err := tb.data.Find(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 删除的record仍在表中，撤销时无法插入回去
+	applied := []*appliedOp{{t: tb, op: DELETE, key: 1, before: before}}
+	err = withRevert(ErrWriteConflict, applied)
+	var re *RevertError
+	if !errors.As(err, &re) {
+		t.Fatalf("expected %T got %v", re, err)
+	}
+	if !errors.Is(err, ErrWriteConflict) || !errors.Is(err, ErrKeyExists) {
+		t.Fatalf("unexpected %v", err)
+	}
+
+	// 撤销成功时返回原来的错误
+	err = withRevert(ErrWriteConflict, nil)
+	if err != ErrWriteConflict {
+		t.Fatalf("expected %v got %v", ErrWriteConflict, err)
+	}
+}
+
+func TestWriteConflict(t *testing.T) {
+	server := NewIDBServer()
+	inspector := NewUndoInspector()
+	server.WithOptions(func(option *ServerOptionConfig) {
+		option.inspector = inspector
+	})
+	err := createPeopleTable(server)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tm := NewTxMgr(server, inspector)
+
+	// 两个事务更新同一个record，后提交的失败
+	tx1 := tm.StartTransaction()
+	tx2 := tm.StartTransaction()
+	err = server.UpdateByIDTx(tx1, "people", map[string]interface{}{"age": 1}, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = server.UpdateByIDTx(tx2, "people", map[string]interface{}{"age": 2}, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = server.UpdateByIDTx(tx2, "people", map[string]interface{}{"age": 3}, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = tx1.Commit()
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = tx2.Commit()
+	if !errors.Is(err, ErrWriteConflict) {
+		t.Fatalf("expected %v got %v", ErrWriteConflict, err)
+	}
+	var we *WriteConflictError
+	if !errors.As(err, &we) || we.Table != "people" || we.ID != 1 {
+		t.Fatalf("unexpected %v", err)
+	}
+	for id, age := range map[int]string{1: "1", 3: "9"} {
+		r, err := server.SelectByID("people", id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if r.Value[1] != age {
+			t.Fatalf("expected %v got %v", age, r.Value[1])
+		}
+	}
+
+	// 提交之后开始的事务可以继续更新
+	tx3 := tm.StartTransaction()
+	tx4 := tm.StartTransaction()
+	err = server.UpdateByIDTx(tx3, "people", map[string]interface{}{"age": 2}, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = server.DeleteByIDTx(tx4, "people", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = tx3.Commit()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 删除被其他事务更新过的record也冲突
+	err = tx4.Commit()
+	if !errors.Is(err, ErrWriteConflict) {
+		t.Fatalf("expected %v got %v", ErrWriteConflict, err)
+	}
+
+	// 被其他事务删除的record
+	tx5 := tm.StartTransaction()
+	tx6 := tm.StartTransaction()
+	err = server.DeleteByIDTx(tx5, "people", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = server.UpdateByIDTx(tx6, "people", map[string]interface{}{"age": 5}, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = tx5.Commit()
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = tx6.Commit()
+	if !errors.Is(err, ErrWriteConflict) {
+		t.Fatalf("expected %v got %v", ErrWriteConflict, err)
+	}
+}
+
+// recordIDs record的id以及余额
+func recordIDs(records []*Record) []string {
+	ids := make([]string, len(records))
+	for i, r := range records {
+		ids[i] = r.Value[0] + ":" + r.Value[1]
+	}
+	return ids
+}
+
+func TestSelectByFieldsTx(t *testing.T) {
+	server, tm := createAccounts(t)
+	w := tm.StartTransaction()
+	tx := tm.StartTransaction()
+
+	// 其他事务提交的修改不可见
+	err := server.UpdateByIDTx(w, "accounts", map[string]interface{}{"balance": 50}, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = server.InsertTx(w, "accounts", []interface{}{5, 100})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = w.Commit()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 自己的插入可见，删除的不可见
+	err = server.InsertTx(tx, "accounts", []interface{}{3, 100})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = server.DeleteByIDTx(tx, "accounts", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	records, err := server.SelectByFieldsTx(tx, "accounts", map[string]interface{}{"balance": 100})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ids := strings.Join(recordIDs(records), ","); ids != "2:100,3:100" {
+		t.Fatalf("expected %v got %v", "2:100,3:100", ids)
+	}
+
+	// 自己的更新可见
+	err = server.UpdateByIDTx(tx, "accounts", map[string]interface{}{"balance": 7}, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	records, err = server.SelectByFieldsTx(tx, "accounts", map[string]interface{}{"balance": 7})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ids := strings.Join(recordIDs(records), ","); ids != "3:7" {
+		t.Fatalf("expected %v got %v", "3:7", ids)
+	}
+	_, err = server.SelectByFieldsTx(tx, "accounts", map[string]interface{}{"balance": 50})
+	if err != ErrValueNotFound {
+		t.Fatalf("expected %v got %v", ErrValueNotFound, err)
+	}
+
+	// 主键条件缩小遍历范围
+	records, err = server.SelectByFieldsTx(tx, "accounts", map[string]interface{}{"id": 3, "balance": 7})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ids := strings.Join(recordIDs(records), ","); ids != "3:7" {
+		t.Fatalf("expected %v got %v", "3:7", ids)
+	}
+}
+
+func TestSelectRangeTx(t *testing.T) {
+	server, tm := createAccounts(t)
+	w := tm.StartTransaction()
+	tx := tm.StartTransaction()
+	err := server.DeleteByIDTx(w, "accounts", 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = server.InsertTx(w, "accounts", []interface{}{3, 30})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = w.Commit()
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = server.InsertTx(tx, "accounts", []interface{}{4, 40})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = server.UpdateByIDTx(tx, "accounts", map[string]interface{}{"balance": 10}, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 快照之后删除的record仍然可见，插入的不可见
+	records, err := server.SelectRangeTx(tx, "accounts", 1, 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ids := strings.Join(recordIDs(records), ","); ids != "1:10,2:100,4:40" {
+		t.Fatalf("expected %v got %v", "1:10,2:100,4:40", ids)
+	}
+	records, err = server.SelectRangeTx(tx, "accounts", 2, 10, QueryOptions{OrderBy: []OrderBy{{Field: "balance"}}, Limit: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ids := strings.Join(recordIDs(records), ","); ids != "4:40" {
+		t.Fatalf("expected %v got %v", "4:40", ids)
+	}
+	_, err = server.SelectRangeTx(tx, "accounts", 5, 10)
+	if err != ErrValueNotFound {
+		t.Fatalf("expected %v got %v", ErrValueNotFound, err)
+	}
+
+	// SERIALIZABLE事务遍历过的范围内插入了数据时无法序列化，范围之外的修改不冲突
+	for _, lo := range []int{3, 6} {
+		server, tm = createAccounts(t)
+		tx1 := tm.StartTransactionWithOptions(SERIALIZABLE)
+		tx2 := tm.StartTransactionWithOptions(SERIALIZABLE)
+		_, err = server.SelectRangeTx(tx1, "accounts", 3, 5)
+		if err != ErrValueNotFound {
+			t.Fatalf("expected %v got %v", ErrValueNotFound, err)
+		}
+		err = server.UpdateByIDTx(tx1, "accounts", map[string]interface{}{"balance": 0}, 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		balanceTx(t, server, tx2, 1)
+		err = server.InsertTx(tx2, "accounts", []interface{}{lo, 100})
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = tx2.Commit()
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = tx1.Commit()
+		if lo == 3 && err != ErrSerializationFailure {
+			t.Fatalf("expected %v got %v", ErrSerializationFailure, err)
+		}
+		if lo == 6 && err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestBegin(t *testing.T) {
+	// 不需要创建inspector以及事务管理器
+	server := NewIDBServer()
+	_, err := server.Exec("CREATE TABLE accounts (id INT PRIMARY KEY, balance INT)")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = server.Exec("INSERT INTO accounts VALUES (1, 100), (2, 100)")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tx := server.Begin()
+	if tx.level != REPEATABLE_READ {
+		t.Fatalf("expected %v got %v", REPEATABLE_READ, tx.level)
+	}
+	tx.Rollback()
+	r := server.Begin(TxOptions{Isolation: SNAPSHOT})
+	if r.level != SNAPSHOT {
+		t.Fatalf("expected %v got %v", SNAPSHOT, r.level)
+	}
+	err = server.RunInTx(context.Background(), TxOptions{}, func(tx *Tx) error {
+		return server.UpdateByIDTx(tx, "accounts", map[string]interface{}{"balance": 0}, 1)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b := balanceTx(t, server, r, 1); b != "100" {
+		t.Fatalf("expected %v got %v", "100", b)
+	}
+
+	// 返回错误或者panic时回滚
+	stop := errors.New("stop")
+	err = server.RunInTx(context.Background(), TxOptions{}, func(tx *Tx) error {
+		server.UpdateByIDTx(tx, "accounts", map[string]interface{}{"balance": 50}, 2)
+		return stop
+	})
+	if err != stop {
+		t.Fatalf("expected %v got %v", stop, err)
+	}
+	func() {
+		defer func() {
+			if recover() == nil {
+				t.Fatal("expected panic")
+			}
+		}()
+		server.RunInTx(context.Background(), TxOptions{}, func(tx *Tx) error {
+			server.UpdateByIDTx(tx, "accounts", map[string]interface{}{"balance": 50}, 2)
+			panic(stop)
+		})
+	}()
+	record, _ := server.SelectByID("accounts", 2)
+	if record.Value[1] != "100" {
+		t.Fatalf("expected %v got %v", "100", record.Value[1])
+	}
+	tm := server.TxMgr().(*TxMgrImpl)
+	if len(tm.activeTxIDs) != 1 {
+		t.Fatalf("expected %v got %v", 1, len(tm.activeTxIDs))
+	}
+
+	// SQL会话使用同一个事务管理器
+	ss := server.NewSession()
+	_, err = ss.Exec("BEGIN")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ss.tx.mgr != server.TxMgr() {
+		t.Fatal("expected same tx mgr")
+	}
+
+	// 创建表之后替换inspector
+	server.WithOptions(func(option *ServerOptionConfig) {
+		option.inspector = NewUndoInspector()
+	})
+	r2 := server.Begin()
+	err = server.RunInTx(context.Background(), TxOptions{}, func(tx *Tx) error {
+		return server.UpdateByIDTx(tx, "accounts", map[string]interface{}{"balance": 5}, 1)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b := balanceTx(t, server, r2, 1); b != "0" {
+		t.Fatalf("expected %v got %v", "0", b)
+	}
+}
+
+func TestUndoSameIDInTables(t *testing.T) {
+	server := NewIDBServer()
+	for _, name := range []string{"a", "b"} {
+		_, err := server.Exec("CREATE TABLE " + name + " (id INT PRIMARY KEY, v STRING)")
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = server.Exec("INSERT INTO " + name + " VALUES (1, '" + name + "')")
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	check := func(tx *Tx) {
+		for _, name := range []string{"a", "b"} {
+			r, err := server.SelectByIDTx(tx, name, 1)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if r.Value[1] != name {
+				t.Fatalf("expected %v got %v", name, r.Value[1])
+			}
+		}
+	}
+
+	// 同一个事务更新、删除两个表中id相同的record，旧快照读到各自表的数据
+	r1 := server.Begin()
+	err := server.RunInTx(context.Background(), TxOptions{}, func(tx *Tx) error {
+		for _, name := range []string{"a", "b"} {
+			if err := server.UpdateByIDTx(tx, name, map[string]interface{}{"v": "x"}, 1); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	check(r1)
+
+	err = server.RunInTx(context.Background(), TxOptions{}, func(tx *Tx) error {
+		for _, name := range []string{"a", "b"} {
+			if err := server.UpdateByIDTx(tx, name, map[string]interface{}{"v": name}, 1); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	r2 := server.Begin()
+	err = server.RunInTx(context.Background(), TxOptions{}, func(tx *Tx) error {
+		for _, name := range []string{"a", "b"} {
+			if err := server.DeleteByIDTx(tx, name, 1); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	check(r2)
+
+	// 不在事务中的删除不记录删除前的数据
+	_, err = server.Exec("INSERT INTO a VALUES (2, 'a')")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = server.DeleteByID("a", 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ui := server.config.options.inspector.(*UndoInspector)
+	if len(ui.data.delData) != 0 || len(ui.data.upData) != 0 {
+		t.Fatalf("expected empty inspector got %v %v", ui.data.delData, ui.data.upData)
+	}
+}
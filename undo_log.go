@@ -130,25 +130,35 @@ func (l *UndoLog) removeItem(item *UndoLogItem) {
 	l.items = append(l.items[:i], l.items[i+1:]...)
 }
 
-// TODO 问题在于如何找到最晚提交的那个活跃txID？
-func (l *UndoLog) Find(activeTxIDs map[int]bool, recordID int) (*Record, error) {
+// Find 找到readView中record的版本。undoLog按提交顺序记录，readView可见的提交都在不可见的提交之前，
+// 所以第一个readView不可见的提交之前的数据就是readView中的数据，在readView中不存在时返回nil。
+// 之后没有readView不可见的提交时返回ErrRecordNotCommit，应该使用最新的record
+func (l *UndoLog) Find(rv *readView, recordID int) (*Record, error) {
 	// 尝试从缓存中找该记录
 	rcs, ok := l.recordsCache[recordID]
 	if !ok {
 		return nil, ErrRecordNotCommit
 	}
 
-	// 尝试找到最早更新过该record的活跃txID
 	for i, txID := range rcs.commitTxIDs {
-		if activeTxIDs[txID] {
+		if !rv.visible(txID) {
 			return transferToRecord(rcs.records[i])
 		}
 	}
 
-	// 若没有任何事务更新过该record，则返回错误
+	// 若没有不可见的事务更新过该record，则返回错误
 	return nil, ErrRecordNotCommit
 }
 
+// RecordIDs 有历史版本的record id
+func (l *UndoLog) RecordIDs() []int {
+	ids := make([]int, 0, len(l.recordsCache))
+	for id := range l.recordsCache {
+		ids = append(ids, id)
+	}
+	return ids
+}
+
 func (l *UndoLog) IsEmpty() bool {
 	return len(l.items) == 0
 }
@@ -38,7 +38,7 @@ func TestUndoLogBasicPath(t *testing.T) {
 	ul := NewUndoLog()
 
 	// 根本不存在该记录
-	_, err := ul.Find(make(map[int]bool), 1)
+	_, err := ul.Find(testReadView(2), 1)
 	if err != ErrRecordNotCommit {
 		t.Fatal("根本不应该找到")
 	}
@@ -62,14 +62,15 @@ func TestUndoLogBasicPath(t *testing.T) {
 	ul.Append(1, map[int]bool{2: true, 3: true}, rs1)
 
 	// 假设存在一个tx4，在tx1提交之后才开始，那么应该要查到最新记录。也就是Find应该是ErrRecordNotCommit
-	_, err = ul.Find(map[int]bool{2: true, 3: true}, 1)
+	rv4 := testReadView(5, 2, 3)
+	_, err = ul.Find(rv4, 1)
 	if err != ErrRecordNotCommit {
 		t.Fatal("tx4 根本不应该找到")
 	}
 
 	// tx2应该找的到record 1
 	// TODO 似乎别人影响他和他影响别人的，都是同一个别人啊
-	record, err := ul.Find(map[int]bool{1: true, 3: true}, 1)
+	record, err := ul.Find(testReadView(3, 1), 1)
 	if err != nil {
 		t.Fatalf("应该找到 but %s", err)
 	}
@@ -79,11 +80,8 @@ func TestUndoLogBasicPath(t *testing.T) {
 
 	ul.Append(2, map[int]bool{3: true}, rs2)
 
-	aids3 := map[int]bool{
-		1: true,
-		2: true,
-	}
-	record, err = ul.Find(aids3, 2)
+	rv3 := testReadView(4, 1, 2)
+	record, err = ul.Find(rv3, 2)
 	if err != nil {
 		t.Fatalf("应该找到 but %s", err)
 	}
@@ -95,7 +93,7 @@ func TestUndoLogBasicPath(t *testing.T) {
 	// 有些怪异。如果tx1记录了活跃tx2，nextTxID为3。那么当tx2提交之后是不应该看到tx2更改的记录，更不应该看到tx3以及之后的提交记录
 	// 所以找到的记录应该是最早更新过该record的活跃tx undo record
 	// tx3应该找不到record1
-	record, err = ul.Find(aids3, 1)
+	record, err = ul.Find(rv3, 1)
 	if err != nil {
 		t.Fatalf("应该找到 but %s", err)
 	}
@@ -104,13 +102,32 @@ func TestUndoLogBasicPath(t *testing.T) {
 	}
 
 	// tx4 应该找到record 2
-	record, err = ul.Find(map[int]bool{2: true, 3: true}, 2)
+	record, err = ul.Find(rv4, 2)
 	if err != nil {
 		t.Fatalf("tx4应该找到2 but %s", err)
 	}
 	if len(record.Value) != 1 || record.Value[0] != "world" {
 		t.Fatalf("got %v", record)
 	}
+
+	// tx2开始之前的readView中tx2不可见，即使tx2开始时它已经不活跃
+	record, err = ul.Find(testReadView(2, 1), 2)
+	if err != nil {
+		t.Fatalf("应该找到 but %s", err)
+	}
+	if len(record.Value) != 1 || record.Value[0] != "world" {
+		t.Fatalf("got %v", record)
+	}
+}
+
+// testReadView nextTxID以及活跃的事务id组成的readView
+func testReadView(nextTxID int, activeTxIDs ...int) *readView {
+	rv := &readView{activeTxIDs: make(map[int]bool), minActiveID: InvalidTxID, nextTxID: nextTxID}
+	for _, id := range activeTxIDs {
+		rv.activeTxIDs[id] = true
+		rv.minActiveID = minInt(rv.minActiveID, id)
+	}
+	return rv
 }
 
 func TestReclaim(t *testing.T) {